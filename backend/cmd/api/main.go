@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
@@ -31,6 +32,16 @@ func main() {
 
 	srv := httpapi.NewServer(cfg, db)
 
+	if cfg.ShipmentPollInterval > 0 {
+		go srv.RunShipmentPoller(context.Background(), cfg.ShipmentPollInterval)
+	}
+	if cfg.PopularityInterval > 0 {
+		go srv.RunPopularityJob(context.Background(), cfg.PopularityInterval)
+	}
+	if cfg.AffinityInterval > 0 {
+		go srv.RunAffinityJob(context.Background(), cfg.AffinityInterval)
+	}
+
 	httpServer := &http.Server{
 		Addr:              cfg.Addr,
 		Handler:           srv.Routes(),
@@ -0,0 +1,105 @@
+// Command catalog imports and exports the perfume catalog directly against
+// the database, the same way POST /api/admin/catalog/import does. It is how
+// a fresh database gets its catalog, before any admin exists; there the
+// dictionaries are empty too, so -create-references adds the missing brands,
+// families and notes.
+//
+//	go run ./cmd/catalog -file scripts/perfumes.seed.json -create-references -dry-run
+//	go run ./cmd/catalog -file scripts/perfumes.seed.json -create-references -replace
+//	go run ./cmd/catalog -export -format csv > catalog.csv
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"parfum-backend/internal/app"
+	"parfum-backend/internal/httpapi"
+)
+
+func main() {
+	var (
+		mode    = flag.String("mode", "retail", "catalog mode: retail or wholesale")
+		file    = flag.String("file", "", "JSON or CSV file to import; - reads stdin")
+		format  = flag.String("format", "", "json or csv; taken from the file extension when empty")
+		dryRun  = flag.Bool("dry-run", false, "print the diff without writing")
+		replace = flag.Bool("replace", false, "delete perfumes of the mode missing from the file")
+		export  = flag.Bool("export", false, "write the catalog to stdout instead of importing")
+		refs    = flag.Bool("create-references", false, "add brands, families and notes missing from the dictionaries")
+	)
+	flag.Parse()
+
+	cfg := app.LoadConfig()
+	if cfg.DatabaseURL == "" {
+		log.Fatal("DATABASE_URL is required")
+	}
+	if *mode != "retail" && *mode != "wholesale" {
+		log.Fatal("mode must be retail or wholesale")
+	}
+	if *format == "" {
+		*format = "json"
+		if strings.EqualFold(filepath.Ext(*file), ".csv") {
+			*format = "csv"
+		}
+	}
+	if *format != "json" && *format != "csv" {
+		log.Fatal("format must be json or csv")
+	}
+
+	db, err := app.OpenDB(cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	srv := httpapi.NewServer(cfg, db)
+
+	if *export {
+		if err := srv.ExportCatalog(os.Stdout, *mode, *format); err != nil {
+			log.Fatalf("export: %v", err)
+		}
+		return
+	}
+
+	var in io.Reader = os.Stdin
+	if *file == "" {
+		log.Fatal("-file is required")
+	}
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			log.Fatalf("open %s: %v", *file, err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	report, err := srv.ImportCatalog(context.Background(), in, httpapi.CatalogImportOptions{
+		Mode:             *mode,
+		Format:           *format,
+		DryRun:           *dryRun,
+		Replace:          *replace,
+		CreateReferences: *refs,
+	})
+	if err != nil {
+		log.Fatalf("import: %v", err)
+	}
+	// The server rebuilds suggestions a moment after a catalog write; this
+	// process exits before that, so rebuild them here.
+	if report.Applied {
+		if err := srv.RefreshSuggestions(context.Background()); err != nil {
+			log.Fatalf("refresh suggestions: %v", err)
+		}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(report)
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+}
@@ -3,6 +3,7 @@ package app
 import (
 	"os"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -12,6 +13,11 @@ type Config struct {
 	UploadDir   string
 	CORSOrigins []string
 	CookieSecure bool
+	ShipmentPollInterval time.Duration
+	ShopName    string
+	IdempotencyWindow time.Duration
+	PopularityInterval time.Duration
+	AffinityInterval time.Duration
 }
 
 func LoadConfig() Config {
@@ -22,6 +28,11 @@ func LoadConfig() Config {
 	corsRaw := getEnv("CORS_ORIGINS", "http://localhost:3000")
 	cors := splitCSV(corsRaw)
 	cookieSecure := strings.EqualFold(getEnv("COOKIE_SECURE", "false"), "true")
+	shipmentPoll := getDuration("SHIPMENT_POLL_INTERVAL", 30*time.Minute)
+	shopName := getEnv("SHOP_NAME", "Memory")
+	idempotencyWindow := getDuration("ORDER_IDEMPOTENCY_WINDOW", 24*time.Hour)
+	popularityInterval := getDuration("POPULARITY_INTERVAL", time.Hour)
+	affinityInterval := getDuration("AFFINITY_INTERVAL", 6*time.Hour)
 
 	return Config{
 		Addr:        addr,
@@ -30,6 +41,11 @@ func LoadConfig() Config {
 		UploadDir:   uploadDir,
 		CORSOrigins: cors,
 		CookieSecure: cookieSecure,
+		ShipmentPollInterval: shipmentPoll,
+		ShopName:    shopName,
+		IdempotencyWindow: idempotencyWindow,
+		PopularityInterval: popularityInterval,
+		AffinityInterval: affinityInterval,
 	}
 }
 
@@ -40,6 +56,20 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+// getDuration parses values like "15m"; zero or negative disables the
+// feature that reads it.
+func getDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fallback
+	}
+	return d
+}
+
 func splitCSV(raw string) []string {
 	parts := strings.Split(raw, ",")
 	out := make([]string, 0, len(parts))
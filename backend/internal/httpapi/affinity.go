@@ -0,0 +1,179 @@
+package httpapi
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// The co-purchase model treats every order and every saved cart as a basket.
+// add_to_cart stat events are daily totals without a visitor, so saved carts
+// stand in for them; they weigh less than real orders.
+const (
+	affinityOrderWeight = 1
+	affinityCartWeight  = 0.3
+	affinityOrderDays   = 365
+	affinityCartDays    = 90
+
+	defaultAffinityLimit = 6
+	maxAffinityLimit     = 24
+	maxCartRecommendIDs  = 50
+)
+
+// RunAffinityJob rebuilds perfume_affinity every interval until ctx is
+// cancelled.
+func (s *Server) RunAffinityJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.RecomputeAffinity(ctx, time.Now().UTC()); err != nil {
+			log.Printf("recompute affinity: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RecomputeAffinity replaces perfume_affinity with pair scores from recent
+// orders and carts. The score is the cosine of the two perfumes' basket
+// vectors, so best sellers do not pair with everything.
+func (s *Server) RecomputeAffinity(ctx context.Context, now time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM perfume_affinity`); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		WITH baskets AS (
+			SELECT DISTINCT 'o:' || oi.order_id::text AS basket, oi.perfume_id, $1::float8 AS weight
+			FROM order_items oi
+			JOIN orders o ON o.id = oi.order_id
+			WHERE o.created_at >= $3
+			UNION ALL
+			SELECT DISTINCT 'c:' || c.user_id::text, item->>'id', $2::float8
+			FROM carts c, jsonb_array_elements(c.items) item
+			WHERE c.updated_at >= $4 AND COALESCE(item->>'id', '') <> ''
+		), freq AS (
+			SELECT perfume_id, SUM(weight) AS weight FROM baskets GROUP BY perfume_id
+		), pairs AS (
+			SELECT a.perfume_id, b.perfume_id AS related_id,
+				COUNT(*) FILTER (WHERE a.basket LIKE 'o:%') AS orders,
+				COUNT(*) FILTER (WHERE a.basket LIKE 'c:%') AS carts,
+				SUM(a.weight) AS weight
+			FROM baskets a
+			JOIN baskets b ON b.basket = a.basket AND b.perfume_id <> a.perfume_id
+			GROUP BY a.perfume_id, b.perfume_id
+		)
+		INSERT INTO perfume_affinity (perfume_id, related_id, orders, carts, score, updated_at)
+		SELECT p.perfume_id, p.related_id, p.orders, p.carts, p.weight / sqrt(fa.weight * fb.weight), now()
+		FROM pairs p
+		JOIN freq fa ON fa.perfume_id = p.perfume_id
+		JOIN freq fb ON fb.perfume_id = p.related_id
+		WHERE EXISTS (SELECT 1 FROM perfumes WHERE id = p.perfume_id)
+			AND EXISTS (SELECT 1 FROM perfumes WHERE id = p.related_id)
+	`, affinityOrderWeight, affinityCartWeight,
+		now.AddDate(0, 0, -affinityOrderDays), now.AddDate(0, 0, -affinityCartDays),
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Server) handleRecomputeAffinity(w http.ResponseWriter, r *http.Request) {
+	if err := s.RecomputeAffinity(r.Context(), time.Now().UTC()); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot recompute affinity")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func affinityLimit(r *http.Request) int {
+	limit := parsePositiveInt(strings.TrimSpace(r.URL.Query().Get("limit")), defaultAffinityLimit)
+	if limit > maxAffinityLimit {
+		limit = maxAffinityLimit
+	}
+	return limit
+}
+
+// handleBoughtTogether lists in-stock perfumes most often bought with {id}.
+func (s *Server) handleBoughtTogether(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "missing id")
+		return
+	}
+	s.writeAffinityPerfumes(w, r, pgtype.FlatArray[string]{id}, affinityLimit(r))
+}
+
+// handleCartRecommendations suggests perfumes to add to a cart given as
+// ?ids=; scores of all cart items are summed and the cart items themselves
+// are left out. Guest carts live in the browser, hence the ids parameter.
+func (s *Server) handleCartRecommendations(w http.ResponseWriter, r *http.Request) {
+	ids := splitQueryList(r.URL.Query().Get("ids"))
+	if len(ids) == 0 {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"items": []map[string]interface{}{}})
+		return
+	}
+	if len(ids) > maxCartRecommendIDs {
+		ids = ids[:maxCartRecommendIDs]
+	}
+	s.writeAffinityPerfumes(w, r, pgtype.FlatArray[string](ids), affinityLimit(r))
+}
+
+func (s *Server) writeAffinityPerfumes(w http.ResponseWriter, r *http.Request, ids pgtype.FlatArray[string], limit int) {
+	rows, err := s.db.QueryContext(r.Context(), `
+		WITH related AS (
+			SELECT related_id AS id, SUM(score) AS affinity, SUM(orders) AS together
+			FROM perfume_affinity
+			WHERE perfume_id = ANY($1) AND NOT related_id = ANY($1)
+			GROUP BY related_id
+		)
+		SELECT id, catalog_mode, brand, name, family, description,
+		       to_json(tags), to_json(notes_top), to_json(notes_heart), to_json(notes_base),
+		       to_json(seasons), to_json(day_night), base_price, base_volume, sillage, longevity,
+		       image_url, search_name_ru, is_hit, order_count, in_stock, stock_qty, currency,
+		       popularity, popularity_month, popularity_month_key,
+		       COALESCE(review_avg, 0), COALESCE(review_count, 0), created_at, updated_at,
+		       `+perfumeVariantsJSON+`, affinity, together
+		FROM perfumes
+		JOIN related USING (id)
+		WHERE in_stock
+		ORDER BY affinity DESC, together DESC, id
+		LIMIT $2
+	`, ids, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load recommendations")
+		return
+	}
+	defer rows.Close()
+
+	var (
+		affinity float64
+		together int
+	)
+	items, err := scanPerfumeRowsWith(rows, []interface{}{&affinity, &together}, func(_ perfumePayload, item map[string]interface{}) {
+		item["affinity"] = math.Round(affinity*1000) / 1000
+		item["boughtTogether"] = together
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot parse recommendations")
+		return
+	}
+	if items == nil {
+		items = []map[string]interface{}{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": items})
+}
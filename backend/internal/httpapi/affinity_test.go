@@ -0,0 +1,61 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgtype"
+	"parfum-backend/internal/app"
+)
+
+func TestRecomputeAffinityReplacesPairs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM perfume_affinity").WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectExec("(?s)WITH baskets AS .*INSERT INTO perfume_affinity").
+		WithArgs(affinityOrderWeight, affinityCartWeight, now.AddDate(0, 0, -affinityOrderDays), now.AddDate(0, 0, -affinityCartDays)).
+		WillReturnResult(sqlmock.NewResult(0, 12))
+	mock.ExpectCommit()
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	if err := s.RecomputeAffinity(context.Background(), now); err != nil {
+		t.Fatalf("RecomputeAffinity: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
+
+func TestHandleCartRecommendationsExcludesCart(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passthroughConverter{}))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("(?s)WHERE perfume_id = ANY\\(\\$1\\) AND NOT related_id = ANY\\(\\$1\\).*WHERE in_stock").
+		WithArgs(pgtype.FlatArray[string]{"p1", "p2"}, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	req := httptest.NewRequest(http.MethodGet, "/api/cart/recommendations?ids=p1,p2&limit=3", nil)
+	rec := httptest.NewRecorder()
+	s.handleCartRecommendations(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "{\"items\":[]}\n" {
+		t.Fatalf("unexpected response %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
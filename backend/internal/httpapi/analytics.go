@@ -0,0 +1,268 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const defaultAnalyticsDays = 30
+
+// analyticsRange is a half-open [from, to) range of whole days.
+type analyticsRange struct {
+	from        time.Time
+	to          time.Time
+	catalogMode string
+}
+
+func parseAnalyticsRange(q url.Values, now time.Time) (analyticsRange, error) {
+	var rng analyticsRange
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	rng.to = today.AddDate(0, 0, 1)
+	if raw := strings.TrimSpace(q.Get("to")); raw != "" {
+		to, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			return rng, errors.New("invalid to date")
+		}
+		rng.to = to.AddDate(0, 0, 1)
+	}
+	rng.from = rng.to.AddDate(0, 0, -defaultAnalyticsDays)
+	if raw := strings.TrimSpace(q.Get("from")); raw != "" {
+		from, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			return rng, errors.New("invalid from date")
+		}
+		rng.from = from
+	}
+	if !rng.from.Before(rng.to) {
+		return rng, errors.New("from must be before to")
+	}
+	if mode := strings.TrimSpace(q.Get("catalogMode")); mode != "" && mode != "all" {
+		if mode != "retail" && mode != "wholesale" {
+			return rng, errors.New("invalid catalog mode")
+		}
+		rng.catalogMode = mode
+	}
+	return rng, nil
+}
+
+func (rng analyticsRange) fromDate() string { return rng.from.Format("2006-01-02") }
+
+func (rng analyticsRange) toDate() string { return rng.to.AddDate(0, 0, -1).Format("2006-01-02") }
+
+func ratio(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(int(float64(part)/float64(whole)*10000+0.5)) / 10000
+}
+
+// fulfilledFilter turns ?fulfilled= into a condition on orders o: fulfilled
+// orders by default, "false" for open ones and "all" for both.
+func fulfilledFilter(raw string) (string, string, error) {
+	switch strings.TrimSpace(raw) {
+	case "", "true":
+		return "true", "AND o.fulfilled", nil
+	case "false":
+		return "false", "AND NOT o.fulfilled", nil
+	case "all":
+		return "all", "", nil
+	}
+	return "", "", errors.New("fulfilled must be true, false or all")
+}
+
+// handleRevenueAnalytics reports revenue per day, week or month. Revenue is
+// what was charged for the goods: each line less its share of the promo
+// discounts of its order, so it can still be split by catalog. Delivery
+// fees are not included. Only fulfilled orders count unless ?fulfilled=
+// says otherwise.
+func (s *Server) handleRevenueAnalytics(w http.ResponseWriter, r *http.Request) {
+	rng, err := parseAnalyticsRange(r.URL.Query(), time.Now().UTC())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	fulfilled, fulfilledSQL, err := fulfilledFilter(r.URL.Query().Get("fulfilled"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	interval := strings.TrimSpace(r.URL.Query().Get("interval"))
+	if interval == "" {
+		interval = "day"
+	}
+	if interval != "day" && interval != "week" && interval != "month" {
+		writeError(w, http.StatusBadRequest, "interval must be day, week or month")
+		return
+	}
+
+	args := []interface{}{interval, rng.from, rng.to}
+	modeSQL := ""
+	if rng.catalogMode != "" {
+		args = append(args, rng.catalogMode)
+		modeSQL = "AND oi.catalog_mode = $4"
+	}
+	// Promo discounts are recorded per perfume; a perfume on several lines
+	// of an order shares its discount by line amount.
+	rows, err := s.db.Query(`
+		WITH lines AS (
+			SELECT o.created_at, o.id AS order_id, oi.qty, oi.qty * oi.unit_price AS gross,
+				SUM(oi.qty * oi.unit_price) OVER (PARTITION BY o.id, oi.perfume_id) AS perfume_gross,
+				COALESCE((
+					SELECT SUM((d.item->>'amount')::numeric)
+					FROM jsonb_array_elements(o.discounts) disc, jsonb_array_elements(disc->'items') d(item)
+					WHERE d.item->>'id' = oi.perfume_id
+				), 0) AS perfume_discount
+			FROM orders o
+			JOIN order_items oi ON oi.order_id = o.id
+			WHERE o.created_at >= $2 AND o.created_at < $3 `+fulfilledSQL+` `+modeSQL+`
+		), sales AS (
+			SELECT date_trunc($1, created_at) AS period, order_id, qty,
+				gross - CASE WHEN perfume_gross > 0 THEN perfume_discount * gross / perfume_gross ELSE 0 END AS amount
+			FROM lines
+		)
+		SELECT to_char(p.period, 'YYYY-MM-DD'), COUNT(DISTINCT s.order_id),
+			COALESCE(SUM(s.qty), 0), COALESCE(SUM(s.amount), 0)
+		FROM generate_series(
+			date_trunc($1, $2::timestamptz),
+			$3::timestamptz - interval '1 second',
+			('1 ' || $1)::interval
+		) AS p(period)
+		LEFT JOIN sales s ON s.period = p.period
+		GROUP BY p.period
+		ORDER BY p.period
+	`, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load revenue")
+		return
+	}
+	defer rows.Close()
+
+	report := RevenueReport{
+		From:        rng.fromDate(),
+		To:          rng.toDate(),
+		Interval:    interval,
+		CatalogMode: rng.catalogMode,
+		Fulfilled:   fulfilled,
+		Buckets:     []RevenueBucket{},
+	}
+	for rows.Next() {
+		var b RevenueBucket
+		if err := rows.Scan(&b.Period, &b.Orders, &b.Items, &b.Revenue); err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot parse revenue")
+			return
+		}
+		b.Revenue = roundMoney(b.Revenue)
+		if b.Orders > 0 {
+			b.AvgOrderValue = roundMoney(b.Revenue / float64(b.Orders))
+		}
+		report.Orders += b.Orders
+		report.Items += b.Items
+		report.Revenue += b.Revenue
+		report.Buckets = append(report.Buckets, b)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load revenue")
+		return
+	}
+	report.Revenue = roundMoney(report.Revenue)
+	if report.Orders > 0 {
+		report.AvgOrderValue = roundMoney(report.Revenue / float64(report.Orders))
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+func (s *Server) handleTopPerfumesAnalytics(w http.ResponseWriter, r *http.Request) {
+	rng, err := parseAnalyticsRange(r.URL.Query(), time.Now().UTC())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	orderBy := "revenue DESC, qty DESC"
+	switch strings.TrimSpace(r.URL.Query().Get("by")) {
+	case "", "revenue":
+	case "qty":
+		orderBy = "qty DESC, revenue DESC"
+	default:
+		writeError(w, http.StatusBadRequest, "by must be revenue or qty")
+		return
+	}
+	limit := parsePositiveInt(strings.TrimSpace(r.URL.Query().Get("limit")), 10)
+	if limit > 100 {
+		limit = 100
+	}
+
+	args := []interface{}{rng.from, rng.to, limit}
+	modeSQL := ""
+	if rng.catalogMode != "" {
+		args = append(args, rng.catalogMode)
+		modeSQL = "AND oi.catalog_mode = $4"
+	}
+	rows, err := s.db.Query(`
+		SELECT oi.perfume_id, MAX(oi.brand), MAX(oi.name), COUNT(DISTINCT o.id),
+			SUM(oi.qty) AS qty, SUM(oi.qty * oi.unit_price) AS revenue
+		FROM orders o
+		JOIN order_items oi ON oi.order_id = o.id
+		WHERE o.created_at >= $1 AND o.created_at < $2 `+modeSQL+`
+		GROUP BY oi.perfume_id
+		ORDER BY `+orderBy+`, oi.perfume_id
+		LIMIT $3
+	`, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load top perfumes")
+		return
+	}
+	defer rows.Close()
+
+	list := []TopPerfume{}
+	for rows.Next() {
+		var p TopPerfume
+		if err := rows.Scan(&p.ID, &p.Brand, &p.Name, &p.Orders, &p.Qty, &p.Revenue); err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot parse top perfumes")
+			return
+		}
+		p.Revenue = roundMoney(p.Revenue)
+		list = append(list, p)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"from":  rng.fromDate(),
+		"to":    rng.toDate(),
+		"items": list,
+	})
+}
+
+// handleFunnelAnalytics reports the view → add_to_cart → buy conversion
+// from the daily event counters.
+func (s *Server) handleFunnelAnalytics(w http.ResponseWriter, r *http.Request) {
+	rng, err := parseAnalyticsRange(r.URL.Query(), time.Now().UTC())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	args := []interface{}{rng.from, rng.to}
+	joinSQL := ""
+	if rng.catalogMode != "" {
+		args = append(args, rng.catalogMode)
+		joinSQL = "JOIN perfumes p ON p.id = e.perfume_id AND p.catalog_mode = $3"
+	}
+	report := FunnelReport{From: rng.fromDate(), To: rng.toDate(), CatalogMode: rng.catalogMode}
+	if err := s.db.QueryRow(`
+		SELECT
+			COALESCE(SUM(e.count) FILTER (WHERE e.type = 'view'), 0),
+			COALESCE(SUM(e.count) FILTER (WHERE e.type = 'add_to_cart'), 0),
+			COALESCE(SUM(e.count) FILTER (WHERE e.type = 'buy'), 0)
+		FROM stat_events_daily e
+		`+joinSQL+`
+		WHERE e.day >= $1::date AND e.day < $2::date
+			AND e.type IN ('view', 'add_to_cart', 'buy')
+	`, args...).Scan(&report.Views, &report.AddToCart, &report.Buys); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load funnel")
+		return
+	}
+	report.ViewToCart = ratio(report.AddToCart, report.Views)
+	report.CartToBuy = ratio(report.Buys, report.AddToCart)
+	report.ViewToBuy = ratio(report.Buys, report.Views)
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,69 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"parfum-backend/internal/app"
+)
+
+func TestParseAnalyticsRange(t *testing.T) {
+	now := time.Date(2024, 3, 15, 18, 0, 0, 0, time.UTC)
+	rng, err := parseAnalyticsRange(url.Values{}, now)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if rng.fromDate() != "2024-02-15" || rng.toDate() != "2024-03-15" {
+		t.Fatalf("unexpected default range %s..%s", rng.fromDate(), rng.toDate())
+	}
+	q := url.Values{"from": {"2024-03-10"}, "to": {"2024-03-01"}}
+	if _, err := parseAnalyticsRange(q, now); err == nil {
+		t.Fatalf("expected error for reversed range")
+	}
+	q = url.Values{"catalogMode": {"vip"}}
+	if _, err := parseAnalyticsRange(q, now); err == nil {
+		t.Fatalf("expected error for unknown catalog mode")
+	}
+}
+
+func TestHandleRevenueAnalytics(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)
+	// Revenue is net of the promo discounts, and only fulfilled orders count.
+	mock.ExpectQuery("(?s)WITH lines AS .*jsonb_array_elements\\(o.discounts\\).*AND o.fulfilled AND oi.catalog_mode = \\$4.*"+
+		"perfume_discount \\* gross / perfume_gross.*generate_series").
+		WithArgs("day", from, to, "retail").
+		WillReturnRows(sqlmock.NewRows([]string{"period", "orders", "items", "revenue"}).
+			AddRow("2024-03-01", 2, 3, 4500.0).
+			AddRow("2024-03-02", 0, 0, 0.0))
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/analytics/revenue?from=2024-03-01&to=2024-03-02&catalogMode=retail", nil)
+	rec := httptest.NewRecorder()
+	s.handleRevenueAnalytics(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	var report RevenueReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if report.Orders != 2 || report.Revenue != 4500 || report.AvgOrderValue != 2250 || report.Fulfilled != "true" || len(report.Buckets) != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
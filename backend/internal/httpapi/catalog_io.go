@@ -0,0 +1,745 @@
+package httpapi
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// catalogColumns are the columns of the catalog CSV: the perfume id and the
+// editable fields, named by their perfumeEditableFields path.
+var catalogColumns = []string{
+	"id", "catalogMode", "brand", "name", "family", "description",
+	"tags", "notes.top", "notes.heart", "notes.base", "seasons", "dayNight",
+	"basePrice", "baseVolume", "sillage", "longevity", "image", "searchNameRu",
+	"isHit", "inStock", "stockQty", "currency",
+}
+
+// catalogListSeparator joins list fields in a CSV cell. Notes and tags
+// contain commas and spaces but never a pipe.
+const catalogListSeparator = "|"
+
+const maxCatalogImportSize = 20 << 20
+
+// catalogPerfumeColumns are the perfumes columns scanned by
+// scanCatalogPerfume.
+const catalogPerfumeColumns = `id, catalog_mode, brand, name, family, description,
+	to_json(tags), to_json(notes_top), to_json(notes_heart), to_json(notes_base),
+	to_json(seasons), to_json(day_night), base_price, base_volume, sillage, longevity,
+	image_url, search_name_ru, is_hit, order_count, in_stock, stock_qty, currency,
+	popularity, popularity_month, popularity_month_key,
+	COALESCE(review_avg, 0), COALESCE(review_count, 0)`
+
+func scanCatalogPerfume(row interface{ Scan(...interface{}) error }) (perfumePayload, error) {
+	var (
+		p                                         perfumePayload
+		tags, top, heart, base, seasons, dayNight []byte
+	)
+	err := row.Scan(
+		&p.ID, &p.CatalogMode, &p.Brand, &p.Name, &p.Family, &p.Description,
+		&tags, &top, &heart, &base,
+		&seasons, &dayNight, &p.BasePrice, &p.BaseVolume, &p.Sillage, &p.Longevity,
+		&p.Image, &p.SearchNameRu, &p.IsHit, &p.OrderCount, &p.InStock, &p.StockQty, &p.Currency,
+		&p.Popularity, &p.PopularityMonth, &p.PopularityMonthKey, &p.ReviewAvg, &p.ReviewCount,
+	)
+	if err != nil {
+		return p, err
+	}
+	p.Tags = parseTextArrayJSON(tags)
+	p.Notes.Top = parseTextArrayJSON(top)
+	p.Notes.Heart = parseTextArrayJSON(heart)
+	p.Notes.Base = parseTextArrayJSON(base)
+	p.Seasons = parseTextArrayJSON(seasons)
+	p.DayNight = parseTextArrayJSON(dayNight)
+	p.Price, p.Volume = p.BasePrice, p.BaseVolume
+	return p, nil
+}
+
+func loadCatalog(q dbQueryer, mode string) ([]perfumePayload, error) {
+	rows, err := q.Query(`SELECT `+catalogPerfumeColumns+` FROM perfumes WHERE catalog_mode = $1 ORDER BY id`, mode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []perfumePayload
+	for rows.Next() {
+		p, err := scanCatalogPerfume(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+// perfumeFieldValue returns the value of an editable field by its
+// perfumeEditableFields path.
+func perfumeFieldValue(p perfumePayload, path string) interface{} {
+	switch path {
+	case "id":
+		return p.ID
+	case "catalogMode":
+		return p.CatalogMode
+	case "brand":
+		return p.Brand
+	case "name":
+		return p.Name
+	case "family":
+		return p.Family
+	case "description":
+		return p.Description
+	case "tags":
+		return p.Tags
+	case "notes.top":
+		return p.Notes.Top
+	case "notes.heart":
+		return p.Notes.Heart
+	case "notes.base":
+		return p.Notes.Base
+	case "seasons":
+		return p.Seasons
+	case "dayNight":
+		return p.DayNight
+	case "basePrice":
+		return p.BasePrice
+	case "baseVolume":
+		return p.BaseVolume
+	case "sillage":
+		return p.Sillage
+	case "longevity":
+		return p.Longevity
+	case "image":
+		return p.Image
+	case "searchNameRu":
+		return p.SearchNameRu
+	case "isHit":
+		return p.IsHit
+	case "inStock":
+		return p.InStock
+	case "stockQty":
+		return p.StockQty
+	case "currency":
+		return p.Currency
+	}
+	return nil
+}
+
+func formatCatalogValue(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case []string:
+		return strings.Join(x, catalogListSeparator)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(x)
+	case bool:
+		return strconv.FormatBool(x)
+	case *int:
+		if x == nil {
+			return ""
+		}
+		return strconv.Itoa(*x)
+	}
+	return ""
+}
+
+// changedPerfumeFields lists the editable fields that differ between two
+// versions of a perfume.
+func changedPerfumeFields(before, after perfumePayload) []string {
+	var fields []string
+	for _, path := range catalogColumns[1:] {
+		if formatCatalogValue(perfumeFieldValue(before, path)) != formatCatalogValue(perfumeFieldValue(after, path)) {
+			fields = append(fields, path)
+		}
+	}
+	return fields
+}
+
+func isCatalogColumn(name string) bool {
+	for _, c := range catalogColumns {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+func splitCatalogList(raw string) []string {
+	out := []string{}
+	for _, v := range strings.Split(raw, catalogListSeparator) {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// setCatalogField sets a field from its CSV cell.
+func setCatalogField(p *perfumePayload, path, raw string) error {
+	raw = strings.TrimSpace(raw)
+	var err error
+	switch path {
+	case "id":
+		p.ID = raw
+	case "catalogMode":
+		p.CatalogMode = raw
+	case "brand":
+		p.Brand = raw
+	case "name":
+		p.Name = raw
+	case "family":
+		p.Family = raw
+	case "description":
+		p.Description = raw
+	case "tags":
+		p.Tags = splitCatalogList(raw)
+	case "notes.top":
+		p.Notes.Top = splitCatalogList(raw)
+	case "notes.heart":
+		p.Notes.Heart = splitCatalogList(raw)
+	case "notes.base":
+		p.Notes.Base = splitCatalogList(raw)
+	case "seasons":
+		p.Seasons = splitCatalogList(raw)
+	case "dayNight":
+		p.DayNight = splitCatalogList(raw)
+	case "basePrice":
+		p.BasePrice = 0
+		if raw != "" {
+			p.BasePrice, err = strconv.ParseFloat(raw, 64)
+		}
+	case "baseVolume":
+		p.BaseVolume, err = parseCatalogInt(raw)
+	case "sillage":
+		p.Sillage, err = parseCatalogInt(raw)
+	case "longevity":
+		p.Longevity, err = parseCatalogInt(raw)
+	case "image":
+		p.Image = raw
+	case "searchNameRu":
+		p.SearchNameRu = raw
+	case "isHit":
+		p.IsHit, err = parseCatalogBool(raw)
+	case "inStock":
+		p.InStock, err = parseCatalogBool(raw)
+	case "stockQty":
+		p.StockQty = nil
+		if raw != "" {
+			var n int
+			n, err = strconv.Atoi(raw)
+			p.StockQty = &n
+		}
+	case "currency":
+		p.Currency = raw
+	default:
+		return errors.New("unknown column " + path)
+	}
+	if err != nil {
+		return errors.New("invalid " + path)
+	}
+	return nil
+}
+
+// parseCatalogInt reads an empty cell as 0.
+func parseCatalogInt(raw string) (int, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(raw)
+}
+
+func parseCatalogBool(raw string) (bool, error) {
+	switch strings.ToLower(raw) {
+	case "", "false", "0", "нет":
+		return false, nil
+	case "true", "1", "да":
+		return true, nil
+	}
+	return false, errors.New("invalid bool")
+}
+
+// normalizeCatalogRow applies the defaults of handleUpsertPerfume to an
+// imported row and returns what is wrong with it, if anything.
+func normalizeCatalogRow(p *perfumePayload, mode string) string {
+	p.ID = strings.TrimSpace(p.ID)
+	p.Brand = strings.TrimSpace(p.Brand)
+	p.Name = strings.TrimSpace(p.Name)
+	if p.ID == "" {
+		return "missing id"
+	}
+	if p.CatalogMode == "" {
+		p.CatalogMode = mode
+	}
+	if p.CatalogMode != mode {
+		return "catalog mode must be " + mode
+	}
+	if p.Brand == "" || p.Name == "" {
+		return "missing brand or name"
+	}
+	if p.BasePrice == 0 && p.Price > 0 {
+		p.BasePrice = p.Price
+	}
+	if p.BaseVolume == 0 && p.Volume > 0 {
+		p.BaseVolume = p.Volume
+	}
+	p.Price, p.Volume = p.BasePrice, p.BaseVolume
+	if p.BasePrice < 0 {
+		return "invalid basePrice"
+	}
+	if p.BaseVolume <= 0 {
+		return "invalid baseVolume"
+	}
+	if p.StockQty != nil && *p.StockQty < 0 {
+		return "invalid stockQty"
+	}
+	if p.Currency == "" {
+		p.Currency = "₽"
+	}
+	for _, list := range []*[]string{&p.Tags, &p.Notes.Top, &p.Notes.Heart, &p.Notes.Base, &p.Seasons, &p.DayNight} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+	return ""
+}
+
+// catalogFileError is an import body that cannot be read at all, as opposed
+// to rows with errors.
+type catalogFileError struct{ msg string }
+
+func (e catalogFileError) Error() string { return e.msg }
+
+// catalogReadError turns a failed read of the import body into a
+// catalogFileError, except for a body over the size limit, which is
+// returned as is for the handler to answer 413.
+func catalogReadError(err error, msg string) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return err
+	}
+	return catalogFileError{msg}
+}
+
+type catalogRow struct {
+	row     int
+	perfume perfumePayload
+}
+
+// newCatalogPerfume is a new perfume before its row is read, with the
+// defaults the seed script used.
+func newCatalogPerfume() perfumePayload {
+	return perfumePayload{InStock: true, Sillage: 3, Longevity: 3}
+}
+
+// readCatalogJSON reads an array of perfumes in the perfumePayload shape.
+// Fields left out get the newCatalogPerfume defaults, also for existing
+// perfumes: a JSON row replaces the perfume as PUT /api/perfumes/{id} does.
+func readCatalogJSON(body io.Reader) ([]catalogRow, []CatalogImportError, error) {
+	var list []json.RawMessage
+	if err := json.NewDecoder(body).Decode(&list); err != nil {
+		return nil, nil, catalogReadError(err, "invalid json")
+	}
+	var (
+		rows []catalogRow
+		errs []CatalogImportError
+	)
+	for i, raw := range list {
+		p := newCatalogPerfume()
+		if err := json.Unmarshal(raw, &p); err != nil {
+			errs = append(errs, CatalogImportError{Row: i + 1, Error: "invalid json"})
+			continue
+		}
+		rows = append(rows, catalogRow{row: i + 1, perfume: p})
+	}
+	return rows, errs, nil
+}
+
+// readCatalogCSV reads a CSV with a header of catalogColumns. Columns left
+// out of the file keep their current values for existing perfumes. Row
+// numbers count the header as row 1, as spreadsheets do.
+func readCatalogCSV(body io.Reader, existing map[string]perfumePayload) ([]catalogRow, []CatalogImportError, error) {
+	cr := csv.NewReader(body)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, catalogReadError(err, "invalid csv")
+	}
+	header[0] = strings.TrimPrefix(header[0], "\xEF\xBB\xBF")
+	idColumn := -1
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if alias, ok := perfumeFieldAliases[name]; ok {
+			name = alias
+		}
+		if !isCatalogColumn(name) {
+			return nil, nil, catalogFileError{"unknown column " + name}
+		}
+		if name == "id" {
+			idColumn = i
+		}
+		header[i] = name
+	}
+	if idColumn < 0 {
+		return nil, nil, catalogFileError{"missing id column"}
+	}
+
+	var (
+		rows   []catalogRow
+		errs   []CatalogImportError
+		rowNum = 1
+	)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		rowNum++
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) && errors.Is(parseErr.Err, csv.ErrFieldCount) {
+				errs = append(errs, CatalogImportError{Row: rowNum, Error: "wrong number of columns"})
+				continue
+			}
+			return nil, nil, catalogReadError(err, "invalid csv")
+		}
+		id := strings.TrimSpace(record[idColumn])
+		p, ok := existing[id]
+		if !ok {
+			p = newCatalogPerfume()
+		}
+		// Only basePrice and baseVolume count in a CSV.
+		p.Price, p.Volume = 0, 0
+		var rowErr string
+		for i, name := range header {
+			if err := setCatalogField(&p, name, record[i]); err != nil {
+				rowErr = err.Error()
+				break
+			}
+		}
+		if rowErr != "" {
+			errs = append(errs, CatalogImportError{Row: rowNum, ID: id, Error: rowErr})
+			continue
+		}
+		rows = append(rows, catalogRow{row: rowNum, perfume: p})
+	}
+	return rows, errs, nil
+}
+
+// ExportCatalog writes the perfumes of a catalog mode as JSON or CSV in the
+// format ImportCatalog reads.
+func (s *Server) ExportCatalog(w io.Writer, mode, format string) error {
+	list, err := loadCatalog(s.db, mode)
+	if err != nil {
+		return err
+	}
+	if format == "json" {
+		if list == nil {
+			list = []perfumePayload{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(list)
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(catalogColumns); err != nil {
+		return err
+	}
+	for _, p := range list {
+		record := make([]string, len(catalogColumns))
+		for i, path := range catalogColumns {
+			record[i] = formatCatalogValue(perfumeFieldValue(p, path))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// CatalogImportOptions control ImportCatalog. With Replace the perfumes of
+// the mode missing from the file are deleted. ChangedBy is the user id the
+// new perfume versions are recorded for, if any. With CreateReferences
+// brands, families and notes missing from the dictionaries are added in the
+// import transaction instead of failing their rows, which is how a fresh
+// database gets its dictionaries.
+type CatalogImportOptions struct {
+	Mode             string
+	Format           string
+	DryRun           bool
+	Replace          bool
+	ChangedBy        string
+	CreateReferences bool
+}
+
+// ImportCatalog diffs a JSON or CSV catalog against the perfumes of
+// opts.Mode and, unless it is a dry run or any row has errors, applies it in
+// one transaction. Server-maintained counters are never imported.
+func (s *Server) ImportCatalog(ctx context.Context, body io.Reader, opts CatalogImportOptions) (CatalogImportReport, error) {
+	report := CatalogImportReport{
+		Mode:    opts.Mode,
+		DryRun:  opts.DryRun,
+		Created: []string{},
+		Changed: []CatalogChange{},
+		Removed: []string{},
+		Errors:  []CatalogImportError{},
+	}
+	current, err := loadCatalog(s.db, opts.Mode)
+	if err != nil {
+		return report, err
+	}
+	existing := make(map[string]perfumePayload, len(current))
+	for _, p := range current {
+		existing[p.ID] = p
+	}
+
+	var (
+		rows []catalogRow
+		errs []CatalogImportError
+	)
+	if opts.Format == "csv" {
+		rows, errs, err = readCatalogCSV(body, existing)
+	} else {
+		rows, errs, err = readCatalogJSON(body)
+	}
+	if err != nil {
+		return report, err
+	}
+	report.Errors = append(report.Errors, errs...)
+
+	// Entries created for the rows have to be visible to canonicalizePerfume
+	// and must not outlive a dry run or a rejected file, so then the whole
+	// import runs in one transaction.
+	var (
+		tx *sql.Tx
+		q  dbQueryer = s.db
+	)
+	if opts.CreateReferences {
+		if tx, err = s.db.Begin(); err != nil {
+			return report, err
+		}
+		defer tx.Rollback()
+		q = tx
+	}
+
+	seen := map[string]bool{}
+	var ids []string
+	valid := make([]catalogRow, 0, len(rows))
+	for _, row := range rows {
+		p := row.perfume
+		if msg := normalizeCatalogRow(&p, opts.Mode); msg != "" {
+			report.Errors = append(report.Errors, CatalogImportError{Row: row.row, ID: p.ID, Error: msg})
+			continue
+		}
+		if seen[p.ID] {
+			report.Errors = append(report.Errors, CatalogImportError{Row: row.row, ID: p.ID, Error: "duplicate id"})
+			continue
+		}
+		seen[p.ID] = true
+		if opts.CreateReferences {
+			if err := createPerfumeReferences(q, p); err != nil {
+				return report, err
+			}
+		}
+		if err := canonicalizePerfume(q, &p); err != nil {
+			var unknown unknownReferenceError
+			if !errors.As(err, &unknown) {
+				return report, err
+			}
+			report.Errors = append(report.Errors, CatalogImportError{Row: row.row, ID: p.ID, Error: unknown.Error()})
+			continue
+		}
+		ids = append(ids, p.ID)
+		valid = append(valid, catalogRow{row: row.row, perfume: p})
+	}
+
+	// Ids are global, so a perfume of the other catalog cannot be imported
+	// into this one.
+	taken := map[string]string{}
+	if len(ids) > 0 {
+		rs, err := q.Query(`SELECT id, catalog_mode FROM perfumes WHERE id = ANY($1) AND catalog_mode <> $2`, pgtype.FlatArray[string](ids), opts.Mode)
+		if err != nil {
+			return report, err
+		}
+		for rs.Next() {
+			var id, mode string
+			if err := rs.Scan(&id, &mode); err != nil {
+				rs.Close()
+				return report, err
+			}
+			taken[id] = mode
+		}
+		rs.Close()
+		if err := rs.Err(); err != nil {
+			return report, err
+		}
+	}
+
+	var apply []perfumePayload
+	for _, row := range valid {
+		p := row.perfume
+		if mode, ok := taken[p.ID]; ok {
+			report.Errors = append(report.Errors, CatalogImportError{Row: row.row, ID: p.ID, Error: "id is used in the " + mode + " catalog"})
+			continue
+		}
+		old, ok := existing[p.ID]
+		if !ok {
+			report.Created = append(report.Created, p.ID)
+			apply = append(apply, p)
+			continue
+		}
+		if fields := changedPerfumeFields(old, p); len(fields) > 0 {
+			report.Changed = append(report.Changed, CatalogChange{ID: p.ID, Fields: fields})
+			apply = append(apply, p)
+			continue
+		}
+		report.Unchanged++
+	}
+	if opts.Replace {
+		for _, p := range current {
+			if !seen[p.ID] {
+				report.Removed = append(report.Removed, p.ID)
+			}
+		}
+	}
+	sort.Slice(report.Errors, func(i, j int) bool { return report.Errors[i].Row < report.Errors[j].Row })
+	if opts.DryRun || len(report.Errors) > 0 {
+		return report, nil
+	}
+
+	if tx == nil {
+		if tx, err = s.db.Begin(); err != nil {
+			return report, err
+		}
+		defer tx.Rollback()
+	}
+	for _, p := range apply {
+		if _, err := upsertPerfume(tx, p.ID, opts.Mode, p, sql.NullTime{}); err != nil {
+			return report, err
+		}
+		if err := syncDefaultVariant(tx, p.ID); err != nil {
+			return report, err
+		}
+		if err := recordPerfumeVersion(tx, p.ID, opts.ChangedBy, 0); err != nil {
+			return report, err
+		}
+	}
+	if len(report.Removed) > 0 {
+		if _, err := tx.Exec(`DELETE FROM perfumes WHERE catalog_mode = $1 AND id = ANY($2)`, opts.Mode, pgtype.FlatArray[string](report.Removed)); err != nil {
+			return report, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return report, err
+	}
+	report.Applied = true
+	if len(apply) > 0 || len(report.Removed) > 0 {
+		s.catalogChanged(ctx)
+	}
+	return report, nil
+}
+
+func catalogModeParam(r *http.Request) (string, bool) {
+	mode := strings.TrimSpace(r.URL.Query().Get("mode"))
+	if mode == "" {
+		mode = "retail"
+	}
+	return mode, mode == "retail" || mode == "wholesale"
+}
+
+// handleExportCatalog downloads the catalog of ?mode= as JSON or CSV.
+func (s *Server) handleExportCatalog(w http.ResponseWriter, r *http.Request) {
+	mode, ok := catalogModeParam(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid catalog mode")
+		return
+	}
+	format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format")))
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		writeError(w, http.StatusBadRequest, "format must be json or csv")
+		return
+	}
+	filename := "catalog-" + mode + "-" + time.Now().Format("20060102-150405") + "." + format
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	if err := s.ExportCatalog(w, mode, format); err != nil {
+		log.Printf("export catalog: %v", err)
+	}
+}
+
+// handleImportCatalog imports a catalog of ?mode=. The format comes from
+// ?format= or the Content-Type. With ?dryRun=true nothing is written and
+// the diff is returned; rows with errors fail the whole import with 422.
+func (s *Server) handleImportCatalog(w http.ResponseWriter, r *http.Request) {
+	mode, ok := catalogModeParam(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid catalog mode")
+		return
+	}
+	query := r.URL.Query()
+	format := strings.ToLower(strings.TrimSpace(query.Get("format")))
+	if format == "" {
+		format = "json"
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+			format = "csv"
+		}
+	}
+	if format != "json" && format != "csv" {
+		writeError(w, http.StatusBadRequest, "format must be json or csv")
+		return
+	}
+	defer r.Body.Close()
+	user, _ := authUserFrom(r.Context())
+	// A cut-off file could still parse and, with replace, delete every
+	// perfume after the cut, so an oversized body fails as a whole.
+	report, err := s.ImportCatalog(r.Context(), http.MaxBytesReader(w, r.Body, maxCatalogImportSize), CatalogImportOptions{
+		Mode:      mode,
+		Format:    format,
+		DryRun:    query.Get("dryRun") == "true" || query.Get("dryRun") == "1",
+		Replace:   query.Get("replace") == "true" || query.Get("replace") == "1",
+		ChangedBy: user.ID,
+	})
+	if err != nil {
+		var fileErr catalogFileError
+		if errors.As(err, &fileErr) {
+			writeError(w, http.StatusBadRequest, fileErr.Error())
+			return
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "catalog file too large")
+			return
+		}
+		if isVariantVolumeConflict(err) {
+			writeError(w, http.StatusConflict, "volume already used by another variant")
+			return
+		}
+		log.Printf("import catalog: %v", err)
+		writeError(w, http.StatusInternalServerError, "cannot import catalog")
+		return
+	}
+	status := http.StatusOK
+	if len(report.Errors) > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, report)
+}
@@ -0,0 +1,183 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"parfum-backend/internal/app"
+)
+
+func TestHandleImportCatalogCSVDryRun(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passthroughConverter{}))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("(?s)SELECT id, catalog_mode, brand.*FROM perfumes WHERE catalog_mode = \\$1 ORDER BY id").
+		WithArgs("retail").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "catalog_mode", "brand", "name", "family", "description",
+			"tags", "notes_top", "notes_heart", "notes_base", "seasons", "day_night",
+			"base_price", "base_volume", "sillage", "longevity", "image_url", "search_name_ru",
+			"is_hit", "order_count", "in_stock", "stock_qty", "currency",
+			"popularity", "popularity_month", "popularity_month_key", "review_avg", "review_count",
+		}).AddRow(
+			"p1", "retail", "Chanel", "Chance", "", "Нежный",
+			[]byte(`["женский"]`), []byte(`[]`), []byte(`[]`), []byte(`[]`), []byte(`[]`), []byte(`[]`),
+			100.0, 50, 3, 3, "/p1.jpg", "", false, 7, true, nil, "₽",
+			0, 0, "", 0.0, 0,
+		))
+	for _, brand := range []string{"Chanel", "Dior"} {
+		mock.ExpectQuery("(?s)LEFT JOIN brands r").
+			WithArgs(pgtype.FlatArray[string]{brand}).
+			WillReturnRows(sqlmock.NewRows([]string{"v", "name"}).AddRow(brand, brand))
+	}
+	mock.ExpectQuery("SELECT id, catalog_mode FROM perfumes WHERE id = ANY\\(\\$1\\) AND catalog_mode <> \\$2").
+		WithArgs(pgtype.FlatArray[string]{"p1", "p2"}, "retail").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "catalog_mode"}))
+
+	// p1 only changes its price: the columns left out keep their values.
+	body := "id,brand,name,basePrice,baseVolume\n" +
+		"p1,Chanel,Chance,120,50\n" +
+		"p2,Dior,Sauvage,90,100\n" +
+		"p3,,Nameless,10,30\n"
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/catalog/import?dryRun=true", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	rec := httptest.NewRecorder()
+	s.handleImportCatalog(rec, req)
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var report CatalogImportReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if report.Applied || len(report.Created) != 1 || report.Created[0] != "p2" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if len(report.Changed) != 1 || strings.Join(report.Changed[0].Fields, ",") != "basePrice" {
+		t.Fatalf("unexpected changes: %+v", report.Changed)
+	}
+	if len(report.Errors) != 1 || report.Errors[0].Row != 4 || report.Errors[0].Error != "missing brand or name" {
+		t.Fatalf("unexpected errors: %+v", report.Errors)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
+
+func TestHandleImportCatalogRejectsOversizedFile(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("(?s)SELECT id, catalog_mode, brand.*FROM perfumes WHERE catalog_mode = \\$1 ORDER BY id").
+		WithArgs("retail").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	// The file must fail as a whole instead of being imported, and with
+	// replace applied, up to the limit.
+	body := "id,brand,name,description\np1,Chanel,Chance," + strings.Repeat("x", maxCatalogImportSize) + "\n"
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/catalog/import?replace=true", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	rec := httptest.NewRecorder()
+	s.handleImportCatalog(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
+
+func TestIsVariantVolumeConflict(t *testing.T) {
+	if !isVariantVolumeConflict(&pgconn.PgError{Code: "23505", ConstraintName: "perfume_variants_perfume_id_volume_mix_key"}) {
+		t.Fatal("expected the variant constraint to be a volume conflict")
+	}
+	if isVariantVolumeConflict(&pgconn.PgError{Code: "23505", ConstraintName: "brands_slug_key"}) {
+		t.Fatal("a slug race is not a volume conflict")
+	}
+}
+
+func TestImportCatalogCreatesReferences(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passthroughConverter{}))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// A fresh database: no perfumes and empty dictionaries.
+	mock.ExpectQuery("(?s)SELECT id, catalog_mode, brand.*FROM perfumes WHERE catalog_mode = \\$1 ORDER BY id").
+		WithArgs("retail").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectBegin()
+	mock.ExpectExec("(?s)INSERT INTO brands \\(slug, name, created_at, updated_at\\).*WHERE NOT EXISTS").
+		WithArgs("Tom Ford", "tom-ford").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("(?s)INSERT INTO families").
+		WithArgs("Древесные", "drevesnye").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("(?s)INSERT INTO notes").
+		WithArgs("Уд", "ud").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("(?s)LEFT JOIN brands r").
+		WithArgs(pgtype.FlatArray[string]{"Tom Ford"}).
+		WillReturnRows(sqlmock.NewRows([]string{"v", "name"}).AddRow("Tom Ford", "Tom Ford"))
+	mock.ExpectQuery("(?s)LEFT JOIN families r").
+		WithArgs(pgtype.FlatArray[string]{"Древесные"}).
+		WillReturnRows(sqlmock.NewRows([]string{"v", "name"}).AddRow("Древесные", "Древесные"))
+	mock.ExpectQuery("(?s)LEFT JOIN notes r").
+		WithArgs(pgtype.FlatArray[string]{"Уд"}).
+		WillReturnRows(sqlmock.NewRows([]string{"v", "name"}).AddRow("Уд", "Уд"))
+	mock.ExpectQuery("SELECT id, catalog_mode FROM perfumes WHERE id = ANY\\(\\$1\\) AND catalog_mode <> \\$2").
+		WithArgs(pgtype.FlatArray[string]{"p1"}, "retail").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "catalog_mode"}))
+	// A dry run rolls the new dictionary entries back.
+	mock.ExpectRollback()
+
+	body := `[{"id":"p1","brand":"Tom Ford","name":"Oud Wood","family":"Древесные","notes":{"top":["Уд"]},"basePrice":100,"baseVolume":50}]`
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	report, err := s.ImportCatalog(context.Background(), strings.NewReader(body), CatalogImportOptions{
+		Mode:             "retail",
+		Format:           "json",
+		DryRun:           true,
+		CreateReferences: true,
+	})
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if len(report.Errors) != 0 || len(report.Created) != 1 || report.Created[0] != "p1" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
+
+func TestCatalogCSVRoundTrip(t *testing.T) {
+	qty := 4
+	p := perfumePayload{ID: "p1", Brand: "Tom Ford", Tags: []string{"унисекс", "древесный, тёплый"}, BasePrice: 99.5, StockQty: &qty, IsHit: true}
+	var back perfumePayload
+	for _, path := range catalogColumns {
+		if err := setCatalogField(&back, path, formatCatalogValue(perfumeFieldValue(p, path))); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+	if fields := changedPerfumeFields(p, back); len(fields) != 0 {
+		t.Fatalf("round trip changed %v", fields)
+	}
+}
@@ -0,0 +1,394 @@
+package httpapi
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var (
+	errDeliveryUnknown     = errors.New("invalid delivery method")
+	errDeliveryUnavailable = errors.New("delivery method not available")
+	errDeliveryAddress     = errors.New("missing delivery address")
+)
+
+type deliveryMethodPayload struct {
+	ID           string   `json:"id"`
+	Kind         string   `json:"kind"`
+	Title        string   `json:"title"`
+	Description  string   `json:"description"`
+	Address      string   `json:"address"`
+	Price        float64  `json:"price"`
+	FreeFrom     *float64 `json:"freeFrom"`
+	CatalogModes []string `json:"catalogModes"`
+	IsActive     *bool    `json:"isActive"`
+	Position     int      `json:"position"`
+}
+
+// deliveryQuoteRequest is a cart to price shipping for. With a promo code
+// free shipping is checked against the discounted goods total, as on the
+// order itself.
+type deliveryQuoteRequest struct {
+	Items     []OrderItem `json:"items"`
+	PromoCode string      `json:"promoCode"`
+	Contact   struct {
+		Phone string `json:"phone"`
+	} `json:"contact"`
+}
+
+type deliveryMethod struct {
+	ID           string
+	Kind         string
+	Title        string
+	Description  string
+	Address      string
+	Price        float64
+	FreeFrom     *float64
+	CatalogModes []string
+	IsActive     bool
+	Position     int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+const deliveryColumns = `id, kind, title, description, address, price, free_from, to_json(catalog_modes),
+	is_active, position, created_at, updated_at`
+
+func scanDeliveryMethod(row interface{ Scan(...interface{}) error }) (deliveryMethod, error) {
+	var (
+		m         deliveryMethod
+		modesJSON []byte
+	)
+	if err := row.Scan(
+		&m.ID, &m.Kind, &m.Title, &m.Description, &m.Address, &m.Price, &m.FreeFrom, &modesJSON,
+		&m.IsActive, &m.Position, &m.CreatedAt, &m.UpdatedAt,
+	); err != nil {
+		return deliveryMethod{}, err
+	}
+	m.CatalogModes = parseTextArrayJSON(modesJSON)
+	return m, nil
+}
+
+func deliveryToResponse(m deliveryMethod) DeliveryMethod {
+	return DeliveryMethod{
+		ID:              m.ID,
+		Kind:            m.Kind,
+		Title:           m.Title,
+		Description:     m.Description,
+		Address:         m.Address,
+		Price:           m.Price,
+		FreeFrom:        m.FreeFrom,
+		CatalogModes:    m.CatalogModes,
+		RequiresAddress: m.requiresAddress(),
+		IsActive:        m.IsActive,
+		Position:        m.Position,
+	}
+}
+
+// requiresAddress is true for methods that ship to the customer; pickup
+// points carry their own address.
+func (m deliveryMethod) requiresAddress() bool {
+	return m.Kind == "courier" || m.Kind == "post"
+}
+
+// availableFor reports whether every catalog mode present in the cart is
+// served by the method.
+func (m deliveryMethod) availableFor(lines []cartLine) bool {
+	for _, line := range lines {
+		found := false
+		for _, mode := range m.CatalogModes {
+			if mode == line.CatalogMode {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// fee is the shipping price for a cart whose goods cost goodsTotal after
+// promo discounts.
+func (m deliveryMethod) fee(goodsTotal float64) float64 {
+	if m.FreeFrom != nil && goodsTotal >= *m.FreeFrom {
+		return 0
+	}
+	return m.Price
+}
+
+func loadDeliveryMethod(q dbQueryer, id string) (deliveryMethod, error) {
+	m, err := scanDeliveryMethod(q.QueryRow(`
+		SELECT `+deliveryColumns+`
+		FROM delivery_methods
+		WHERE id = $1 AND is_active = true
+	`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return deliveryMethod{}, errDeliveryUnknown
+		}
+		return deliveryMethod{}, err
+	}
+	return m, nil
+}
+
+// resolveDelivery validates the chosen method against the cart and returns
+// the fee and the address to store on the order. goodsTotal is the subtotal
+// less promo discounts.
+func resolveDelivery(q dbQueryer, methodID, address string, lines []cartLine, goodsTotal float64) (deliveryMethod, float64, string, error) {
+	if methodID == "" {
+		methodID = "pickup"
+	}
+	m, err := loadDeliveryMethod(q, methodID)
+	if err != nil {
+		return deliveryMethod{}, 0, "", err
+	}
+	if !m.availableFor(lines) {
+		return deliveryMethod{}, 0, "", errDeliveryUnavailable
+	}
+	if m.requiresAddress() && address == "" {
+		return deliveryMethod{}, 0, "", errDeliveryAddress
+	}
+	if !m.requiresAddress() && address == "" {
+		address = m.Address
+	}
+	return m, m.fee(goodsTotal), address, nil
+}
+
+func isDeliveryError(err error) bool {
+	return errors.Is(err, errDeliveryUnknown) || errors.Is(err, errDeliveryUnavailable) || errors.Is(err, errDeliveryAddress)
+}
+
+func (s *Server) handleListDeliveryMethods(w http.ResponseWriter, r *http.Request) {
+	mode := strings.TrimSpace(r.URL.Query().Get("mode"))
+	includeInactive := strings.EqualFold(strings.TrimSpace(r.URL.Query().Get("includeInactive")), "true")
+	if includeInactive {
+		user, err := s.parseAuth(r)
+		if err != nil || !user.IsAdmin {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+	}
+
+	where := []string{}
+	args := []interface{}{}
+	if !includeInactive {
+		where = append(where, "is_active = true")
+	}
+	if mode != "" {
+		args = append(args, mode)
+		where = append(where, "$"+itoa(len(args))+" = ANY(catalog_modes)")
+	}
+	whereSQL := ""
+	if len(where) > 0 {
+		whereSQL = "WHERE " + strings.Join(where, " AND ")
+	}
+
+	rows, err := s.db.Query(`
+		SELECT `+deliveryColumns+`
+		FROM delivery_methods
+		`+whereSQL+`
+		ORDER BY position ASC, title ASC
+	`, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load delivery methods")
+		return
+	}
+	defer rows.Close()
+	list := []DeliveryMethod{}
+	for rows.Next() {
+		m, err := scanDeliveryMethod(rows)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot parse delivery methods")
+			return
+		}
+		list = append(list, deliveryToResponse(m))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleDeliveryQuote(w http.ResponseWriter, r *http.Request) {
+	var req deliveryQuoteRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	lines, err := loadCartLines(s.db, req.Items)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load perfumes")
+		return
+	}
+	var subtotal float64
+	for _, line := range lines {
+		subtotal += line.Price * float64(line.Qty)
+	}
+	subtotal = roundMoney(subtotal)
+
+	var (
+		discount   float64
+		promoError string
+	)
+	if code := strings.TrimSpace(req.PromoCode); code != "" {
+		user, err := s.parseAuth(r)
+		if err != nil {
+			user = authUser{IsAnonymous: true}
+		}
+		_, d, err := applyPromo(s.db, code, promoUserKey(user, strings.TrimSpace(req.Contact.Phone)), lines, time.Now(), false)
+		switch {
+		case isPromoError(err):
+			promoError = err.Error()
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, "cannot validate promo")
+			return
+		default:
+			discount = d.Amount
+		}
+	}
+	goodsTotal := roundMoney(subtotal - discount)
+
+	rows, err := s.db.Query(`
+		SELECT ` + deliveryColumns + `
+		FROM delivery_methods
+		WHERE is_active = true
+		ORDER BY position ASC, title ASC
+	`)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load delivery methods")
+		return
+	}
+	defer rows.Close()
+
+	type quote struct {
+		DeliveryMethod
+		Fee       float64 `json:"fee"`
+		Total     float64 `json:"total"`
+		FreeShort float64 `json:"freeShort"`
+	}
+	list := []quote{}
+	for rows.Next() {
+		m, err := scanDeliveryMethod(rows)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot parse delivery methods")
+			return
+		}
+		if !m.availableFor(lines) {
+			continue
+		}
+		fee := m.fee(goodsTotal)
+		q := quote{
+			DeliveryMethod: deliveryToResponse(m),
+			Fee:            fee,
+			Total:          roundMoney(goodsTotal + fee),
+		}
+		if m.FreeFrom != nil && fee > 0 {
+			q.FreeShort = roundMoney(*m.FreeFrom - goodsTotal)
+		}
+		list = append(list, q)
+	}
+
+	resp := map[string]interface{}{
+		"subtotal": subtotal,
+		"discount": discount,
+		"methods":  list,
+	}
+	if promoError != "" {
+		resp["promoError"] = promoError
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleUpsertDeliveryMethod(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var body deliveryMethodPayload
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if id == "" {
+		id = body.ID
+	}
+	id = strings.TrimSpace(id)
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "missing id")
+		return
+	}
+	title := strings.TrimSpace(body.Title)
+	if title == "" {
+		writeError(w, http.StatusBadRequest, "missing title")
+		return
+	}
+	kind := strings.TrimSpace(body.Kind)
+	if kind == "" {
+		kind = "pickup"
+	}
+	if kind != "pickup" && kind != "courier" && kind != "post" {
+		writeError(w, http.StatusBadRequest, "invalid kind")
+		return
+	}
+	if body.Price < 0 || (body.FreeFrom != nil && *body.FreeFrom < 0) {
+		writeError(w, http.StatusBadRequest, "invalid price")
+		return
+	}
+	modes := splitQueryList(strings.Join(body.CatalogModes, ","))
+	if len(modes) == 0 {
+		modes = []string{"retail", "wholesale"}
+	}
+	for _, mode := range modes {
+		if mode != "retail" && mode != "wholesale" {
+			writeError(w, http.StatusBadRequest, "invalid catalog mode")
+			return
+		}
+	}
+	isActive := true
+	if body.IsActive != nil {
+		isActive = *body.IsActive
+	}
+
+	_, err := s.db.Exec(`
+		INSERT INTO delivery_methods (
+			id, kind, title, description, address, price, free_from, catalog_modes, is_active, position,
+			created_at, updated_at
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,now(),now())
+		ON CONFLICT (id) DO UPDATE SET
+			kind = EXCLUDED.kind,
+			title = EXCLUDED.title,
+			description = EXCLUDED.description,
+			address = EXCLUDED.address,
+			price = EXCLUDED.price,
+			free_from = EXCLUDED.free_from,
+			catalog_modes = EXCLUDED.catalog_modes,
+			is_active = EXCLUDED.is_active,
+			position = EXCLUDED.position,
+			updated_at = now()
+	`, id, kind, title, strings.TrimSpace(body.Description), strings.TrimSpace(body.Address), body.Price, body.FreeFrom,
+		pgtype.FlatArray[string](modes), isActive, body.Position)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot save delivery method")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"id": id})
+}
+
+func (s *Server) handleDeleteDeliveryMethod(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "missing id")
+		return
+	}
+	res, err := s.db.Exec(`DELETE FROM delivery_methods WHERE id=$1`, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot delete delivery method")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,92 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"parfum-backend/internal/app"
+)
+
+func TestDeliveryMethodFeeAndAvailability(t *testing.T) {
+	freeFrom := 5000.0
+	m := deliveryMethod{Kind: "courier", Price: 350, FreeFrom: &freeFrom, CatalogModes: []string{"retail"}}
+
+	if fee := m.fee(4999); fee != 350 {
+		t.Fatalf("expected fee 350 below threshold, got %v", fee)
+	}
+	if fee := m.fee(5000); fee != 0 {
+		t.Fatalf("expected free shipping at threshold, got %v", fee)
+	}
+	if !m.availableFor([]cartLine{{CatalogMode: "retail"}}) {
+		t.Fatal("expected retail cart to be served")
+	}
+	if m.availableFor([]cartLine{{CatalogMode: "retail"}, {CatalogMode: "wholesale"}}) {
+		t.Fatal("expected mixed cart with wholesale items to be rejected")
+	}
+	if !m.requiresAddress() {
+		t.Fatal("expected courier to require an address")
+	}
+}
+
+func TestHandleDeliveryQuoteChecksFreeShippingAfterPromo(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("(?s)FROM perfumes p\\s+JOIN perfume_variants v").
+		WithArgs("p1", "", 0.0, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "volume", "mix", "price", "currency", "brand", "name", "catalog_mode", "matched"}).
+			AddRow("v1", "p1-50", 50, "60/40", 9000.0, "₽", "Chanel", "Chance", "retail", false))
+	mock.ExpectQuery("(?s)FROM promo_codes WHERE code = \\$1").
+		WithArgs("MINUS2000").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "code", "description", "kind", "amount", "min_total", "max_uses", "max_uses_per_user", "used_count",
+			"starts_at", "ends_at", "catalog_mode", "brands", "perfume_ids", "is_active", "created_at", "updated_at",
+		}).AddRow("promo1", "MINUS2000", "", "fixed", 2000.0, 0.0, nil, nil, 0,
+			nil, nil, "", []byte(`[]`), []byte(`[]`), true, now, now))
+	mock.ExpectQuery("(?s)FROM delivery_methods\\s+WHERE is_active = true").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "kind", "title", "description", "address", "price", "free_from", "catalog_modes",
+			"is_active", "position", "created_at", "updated_at",
+		}).AddRow("courier", "courier", "Доставка", "", "", 350.0, 8000.0, []byte(`["retail"]`), true, 0, now, now))
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	body := `{"items":[{"id":"p1","qty":1}],"promoCode":"MINUS2000"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/delivery/quote", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	s.handleDeliveryQuote(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Subtotal float64 `json:"subtotal"`
+		Discount float64 `json:"discount"`
+		Methods  []struct {
+			Fee       float64 `json:"fee"`
+			Total     float64 `json:"total"`
+			FreeShort float64 `json:"freeShort"`
+		} `json:"methods"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	// 9000 less 2000 is below the 8000 threshold, so shipping is paid.
+	if resp.Subtotal != 9000 || resp.Discount != 2000 || len(resp.Methods) != 1 {
+		t.Fatalf("unexpected quote: %s", rec.Body.String())
+	}
+	if m := resp.Methods[0]; m.Fee != 350 || m.Total != 7350 || m.FreeShort != 1000 {
+		t.Fatalf("unexpected method quote: %+v", m)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
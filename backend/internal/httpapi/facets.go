@@ -0,0 +1,201 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// defaultPriceBucketEdges split the catalog into price ranges for the price
+// facet; ?priceBuckets=10000,20000 overrides them.
+var defaultPriceBucketEdges = []float64{5000, 10000, 20000, 30000, 50000}
+
+// perfumeFacetNames lists the facets in response order with the filter group
+// each one leaves out of its own counts.
+var perfumeFacetNames = []struct{ name, group string }{
+	{"families", "family"},
+	{"brands", "brand"},
+	{"notesTop", "notes"},
+	{"notesHeart", "notes"},
+	{"notesBase", "notes"},
+	{"seasons", "seasons"},
+	{"dayNight", "dayNight"},
+	{"price", "price"},
+	{"inStock", "inStock"},
+}
+
+// perfumeFilterGroup is a catalog filter that facets can switch off: the
+// counts of a facet are computed as if its own filter was not applied.
+type perfumeFilterGroup struct {
+	name string
+	cond string
+}
+
+// parseFacetsParam returns the requested facet names. "1", "true" and "all"
+// request every facet.
+func parseFacetsParam(raw string) ([]string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw == "0" || raw == "false" {
+		return nil, nil
+	}
+	if raw == "1" || raw == "true" || raw == "all" {
+		names := make([]string, 0, len(perfumeFacetNames))
+		for _, f := range perfumeFacetNames {
+			names = append(names, f.name)
+		}
+		return names, nil
+	}
+	var names []string
+	for _, name := range splitQueryList(raw) {
+		known := false
+		for _, f := range perfumeFacetNames {
+			if f.name == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, errors.New("unknown facet " + name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func parsePriceBucketEdges(raw string) ([]float64, error) {
+	parts := splitQueryList(raw)
+	if len(parts) == 0 {
+		return defaultPriceBucketEdges, nil
+	}
+	edges := make([]float64, 0, len(parts))
+	for _, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v <= 0 || (len(edges) > 0 && v <= edges[len(edges)-1]) {
+			return nil, errors.New("invalid price buckets")
+		}
+		edges = append(edges, v)
+	}
+	return edges, nil
+}
+
+// facetValueSQL is the grouped value of each facet over the base CTE.
+var facetValueSQL = map[string]string{
+	"families":   "SELECT family AS value FROM base WHERE family <> ''",
+	"brands":     "SELECT brand AS value FROM base WHERE brand <> ''",
+	"notesTop":   "SELECT v AS value FROM base, unnest(notes_top) v WHERE true",
+	"notesHeart": "SELECT v AS value FROM base, unnest(notes_heart) v WHERE true",
+	"notesBase":  "SELECT v AS value FROM base, unnest(notes_base) v WHERE true",
+	"seasons":    "SELECT v AS value FROM base, unnest(seasons) v WHERE true",
+	"dayNight":   "SELECT v AS value FROM base, unnest(day_night) v WHERE true",
+	"inStock":    "SELECT in_stock::text AS value FROM base WHERE true",
+}
+
+// loadPerfumeFacets counts facet values in one query. The base CTE applies
+// the filters every facet shares and turns each facet filter into a flag, so
+// every facet can require all flags but its own. q is the search
+// transaction when the list has a text search.
+func loadPerfumeFacets(ctx context.Context, q dbQueryer, names []string, common []string, groups []perfumeFilterGroup, args []interface{}, priceEdges []float64) (map[string]interface{}, error) {
+	args = append([]interface{}{}, args...)
+	flags := make([]string, 0, len(groups))
+	for i, g := range groups {
+		flags = append(flags, "("+g.cond+") AS f"+itoa(i))
+	}
+	flagColumns := ""
+	if len(flags) > 0 {
+		flagColumns = ", " + strings.Join(flags, ", ")
+	}
+	othersOf := func(group string) string {
+		var conds []string
+		for i, g := range groups {
+			if g.name != group {
+				conds = append(conds, "f"+itoa(i))
+			}
+		}
+		if len(conds) == 0 {
+			return ""
+		}
+		return " AND " + strings.Join(conds, " AND ")
+	}
+
+	var parts []string
+	for _, name := range names {
+		group := ""
+		for _, f := range perfumeFacetNames {
+			if f.name == name {
+				group = f.group
+			}
+		}
+		if name == "price" {
+			args = append(args, pgtype.FlatArray[float64](priceEdges))
+			parts = append(parts, "SELECT 'price', width_bucket(price::float8, $"+itoa(len(args))+"::float8[])::text, COUNT(*) "+
+				"FROM base WHERE true"+othersOf(group)+" GROUP BY 2")
+			continue
+		}
+		parts = append(parts, "SELECT '"+name+"', value, COUNT(*) FROM ("+facetValueSQL[name]+othersOf(group)+") t GROUP BY value")
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		WITH base AS (
+			SELECT family, brand, notes_top, notes_heart, notes_base, seasons, day_night, `+currentPriceSQL+` AS price, in_stock`+flagColumns+`
+			FROM perfumes
+			WHERE `+strings.Join(common, " AND ")+`
+		)
+		`+strings.Join(parts, "\n\t\tUNION ALL\n\t\t")+`
+		ORDER BY 1, 3 DESC, 2
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := map[string][]FacetCount{}
+	priceCounts := make([]int, len(priceEdges)+1)
+	for rows.Next() {
+		var (
+			facet string
+			item  FacetCount
+		)
+		if err := rows.Scan(&facet, &item.Value, &item.Count); err != nil {
+			return nil, err
+		}
+		if facet == "price" {
+			if bucket, err := strconv.Atoi(item.Value); err == nil && bucket >= 0 && bucket < len(priceCounts) {
+				priceCounts[bucket] = item.Count
+			}
+			continue
+		}
+		values[facet] = append(values[facet], item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	facets := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		if name == "price" {
+			buckets := make([]PriceFacetBucket, 0, len(priceCounts))
+			for i, count := range priceCounts {
+				b := PriceFacetBucket{Count: count}
+				if i > 0 {
+					b.Min = priceEdges[i-1]
+				}
+				if i < len(priceEdges) {
+					max := priceEdges[i]
+					b.Max = &max
+				}
+				buckets = append(buckets, b)
+			}
+			facets[name] = buckets
+			continue
+		}
+		list := values[name]
+		if list == nil {
+			list = []FacetCount{}
+		}
+		facets[name] = list
+	}
+	return facets, nil
+}
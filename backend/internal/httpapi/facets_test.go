@@ -0,0 +1,85 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgtype"
+	"parfum-backend/internal/app"
+)
+
+func TestParseFacetsParam(t *testing.T) {
+	all, err := parseFacetsParam("1")
+	if err != nil || len(all) != len(perfumeFacetNames) {
+		t.Fatalf("parseFacetsParam(1) = %v, %v", all, err)
+	}
+	if names, err := parseFacetsParam("brands, price"); err != nil || strings.Join(names, ",") != "brands,price" {
+		t.Fatalf("parseFacetsParam(list) = %v, %v", names, err)
+	}
+	if _, err := parseFacetsParam("colour"); err == nil {
+		t.Fatalf("expected unknown facet to be rejected")
+	}
+}
+
+func TestHandleListPerfumesFacetsSkipOwnFilter(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passthroughConverter{}))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	brands := pgtype.FlatArray[string]{"Chanel"}
+	seasons := pgtype.FlatArray[string]{"Лето"}
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM perfumes WHERE catalog_mode = \\$1 AND brand = ANY\\(\\$2\\) AND seasons && \\$3").
+		WithArgs("retail", brands, seasons).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery("(?s)SELECT id, catalog_mode.*LIMIT \\$4 OFFSET \\$5").
+		WithArgs("retail", brands, seasons, 13, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("(?s)WITH base AS \\(.*\\(brand = ANY\\(\\$2\\)\\) AS f0, \\(seasons && \\$3\\) AS f1\\s+FROM perfumes\\s+WHERE catalog_mode = \\$1\\s+\\)"+
+		".*SELECT 'brands', value, COUNT\\(\\*\\) FROM \\(SELECT brand AS value FROM base WHERE brand <> '' AND f1\\)"+
+		".*SELECT 'seasons', value, COUNT\\(\\*\\) FROM \\(SELECT v AS value FROM base, unnest\\(seasons\\) v WHERE true AND f0\\)"+
+		".*width_bucket\\(price::float8, \\$4::float8\\[\\]\\).*WHERE true AND f0 AND f1").
+		WithArgs("retail", brands, seasons, pgtype.FlatArray[float64]{10000, 20000}).
+		WillReturnRows(sqlmock.NewRows([]string{"facet", "value", "count"}).
+			AddRow("brands", "Chanel", 4).
+			AddRow("brands", "Dior", 2).
+			AddRow("price", "1", 3).
+			AddRow("seasons", "Лето", 4))
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	req := httptest.NewRequest(http.MethodGet, "/api/perfumes?brands=Chanel&seasons=Лето&facets=brands,seasons,price&priceBuckets=10000,20000", nil)
+	rec := httptest.NewRecorder()
+	s.handleListPerfumes(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Facets struct {
+			Brands  []FacetCount       `json:"brands"`
+			Seasons []FacetCount       `json:"seasons"`
+			Price   []PriceFacetBucket `json:"price"`
+		} `json:"facets"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp.Facets.Brands) != 2 || resp.Facets.Brands[1].Value != "Dior" {
+		t.Fatalf("unexpected brands facet: %+v", resp.Facets.Brands)
+	}
+	if len(resp.Facets.Seasons) != 1 || resp.Facets.Seasons[0].Count != 4 {
+		t.Fatalf("unexpected seasons facet: %+v", resp.Facets.Seasons)
+	}
+	price := resp.Facets.Price
+	if len(price) != 3 || price[0].Count != 0 || price[1].Min != 10000 || *price[1].Max != 20000 || price[1].Count != 3 || price[2].Max != nil {
+		t.Fatalf("unexpected price facet: %+v", price)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
@@ -10,12 +10,13 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 	"strconv"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgtype"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -64,6 +65,9 @@ type perfumePayload struct {
 	PopularityMonthKey string   `json:"popularityMonthKey"`
 	ReviewAvg          float64  `json:"reviewAvg"`
 	ReviewCount        int      `json:"reviewCount"`
+	// Variants are read-only here; they are edited through their own
+	// endpoints.
+	Variants []PerfumeVariant `json:"variants,omitempty"`
 }
 
 type reviewPayload struct {
@@ -88,6 +92,7 @@ type createOrderRequest struct {
 		Method  string `json:"method"`
 		Address string `json:"address"`
 	} `json:"delivery"`
+	PromoCode string `json:"promoCode"`
 }
 
 type cartPayload struct {
@@ -363,59 +368,59 @@ func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleListPerfumes(w http.ResponseWriter, r *http.Request) {
-	mode := strings.TrimSpace(r.URL.Query().Get("mode"))
+	s.writePerfumeList(w, r, r.URL.Query(), "", nil)
+}
+
+// writePerfumeList answers a catalog query: the plain list of a catalog, or
+// a page of filtered perfumes with fields added to the response. A non-empty
+// note limits the perfumes to those having it, whatever the sort.
+func (s *Server) writePerfumeList(w http.ResponseWriter, r *http.Request, query url.Values, note string, fields map[string]interface{}) {
+	mode := strings.TrimSpace(query.Get("mode"))
 	if mode == "" {
 		mode = "retail"
 	}
-	q := strings.TrimSpace(r.URL.Query().Get("q"))
-	mustNotes := splitQueryList(r.URL.Query().Get("mustNotes"))
-	avoidNotes := splitQueryList(r.URL.Query().Get("avoidNotes"))
-	seasons := splitQueryList(r.URL.Query().Get("seasons"))
-	dayNight := splitQueryList(r.URL.Query().Get("dayNight"))
-	presetIDs := splitQueryList(r.URL.Query().Get("presetIds"))
-	sort := strings.TrimSpace(r.URL.Query().Get("sort"))
-
-	pageParam := strings.TrimSpace(r.URL.Query().Get("page"))
-	pageSizeParam := strings.TrimSpace(r.URL.Query().Get("pageSize"))
-	page := parsePositiveInt(pageParam, 1)
-	pageSize := parsePositiveInt(pageSizeParam, 12)
-	if pageSize < 1 {
-		pageSize = 12
+	q := strings.TrimSpace(query.Get("q"))
+	mustNotes := splitQueryList(query.Get("mustNotes"))
+	avoidNotes := splitQueryList(query.Get("avoidNotes"))
+	seasons := splitQueryList(query.Get("seasons"))
+	dayNight := splitQueryList(query.Get("dayNight"))
+	presetIDs := splitQueryList(query.Get("presetIds"))
+	families := splitQueryList(query.Get("families"))
+	brands := splitQueryList(query.Get("brands"))
+	minPrice := strings.TrimSpace(query.Get("minPrice"))
+	maxPrice := strings.TrimSpace(query.Get("maxPrice"))
+	inStock := strings.TrimSpace(query.Get("inStock"))
+	sort := strings.TrimSpace(query.Get("sort"))
+	facetNames, err := parseFacetsParam(query.Get("facets"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
 	}
-	if pageSize > 60 {
-		pageSize = 60
+	priceEdges, err := parsePriceBucketEdges(query.Get("priceBuckets"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	usePaging := pageParam != "" || pageSizeParam != "" || q != "" || len(mustNotes) > 0 || len(avoidNotes) > 0 || len(seasons) > 0 || len(dayNight) > 0 || len(presetIDs) > 0 || sort != ""
+	usePaging := query.Get("page") != "" || query.Get("pageSize") != "" || query.Get("cursor") != "" || q != "" || len(mustNotes) > 0 || len(avoidNotes) > 0 || len(seasons) > 0 || len(dayNight) > 0 || len(presetIDs) > 0 || sort != "" ||
+		len(families) > 0 || len(brands) > 0 || minPrice != "" || maxPrice != "" || inStock != "" || len(facetNames) > 0 || note != ""
 
 	where := []string{"catalog_mode = $1"}
 	args := []interface{}{mode}
 	presetArgIndex := 0
+	if note != "" {
+		args = append(args, note)
+		where = append(where, "$"+itoa(len(args))+" = ANY(notes_top || notes_heart || notes_base)")
+	}
 
+	var search perfumeSearch
 	if q != "" {
-		args = append(args, "%"+q+"%")
-		idx := len(args)
-		where = append(where, "(brand ILIKE $"+itoa(idx)+" OR name ILIKE $"+itoa(idx)+" OR search_name_ru ILIKE $"+itoa(idx)+
-			" OR array_to_string(tags, ' ') ILIKE $"+itoa(idx)+
-			" OR array_to_string(notes_top, ' ') ILIKE $"+itoa(idx)+
-			" OR array_to_string(notes_heart, ' ') ILIKE $"+itoa(idx)+
-			" OR array_to_string(notes_base, ' ') ILIKE $"+itoa(idx)+")")
-	}
-	if len(seasons) > 0 {
-		args = append(args, pgtype.FlatArray[string](seasons))
-		where = append(where, "seasons && $"+itoa(len(args)))
-	}
-	if len(dayNight) > 0 {
-		args = append(args, pgtype.FlatArray[string](dayNight))
-		where = append(where, "day_night && $"+itoa(len(args)))
-	}
-	if len(mustNotes) > 0 {
-		args = append(args, pgtype.FlatArray[string](mustNotes))
-		where = append(where, "(notes_top || notes_heart || notes_base) @> $"+itoa(len(args)))
-	}
-	if len(avoidNotes) > 0 {
-		args = append(args, pgtype.FlatArray[string](avoidNotes))
-		where = append(where, "NOT ((notes_top || notes_heart || notes_base) && $"+itoa(len(args))+")")
+		search = buildPerfumeSearch(q, len(args))
+		args = append(args, search.args...)
+		where = append(where, search.match)
+		if sort == "" {
+			sort = "relevance"
+		}
 	}
 	if len(presetIDs) > 0 {
 		args = append(args, pgtype.FlatArray[string](presetIDs))
@@ -423,22 +428,126 @@ func (s *Server) handleListPerfumes(w http.ResponseWriter, r *http.Request) {
 		where = append(where, "id = ANY($"+itoa(len(args))+")")
 	}
 
-	whereSQL := strings.Join(where, " AND ")
-	orderBy := "updated_at DESC NULLS LAST, id"
+	// Filters below can be left out by facet counts, see loadPerfumeFacets.
+	var groups []perfumeFilterGroup
+	addGroup := func(name, cond string) {
+		for i := range groups {
+			if groups[i].name == name {
+				groups[i].cond += " AND " + cond
+				return
+			}
+		}
+		groups = append(groups, perfumeFilterGroup{name: name, cond: cond})
+	}
+	if len(families) > 0 {
+		args = append(args, pgtype.FlatArray[string](families))
+		addGroup("family", "family = ANY($"+itoa(len(args))+")")
+	}
+	if len(brands) > 0 {
+		args = append(args, pgtype.FlatArray[string](brands))
+		addGroup("brand", "brand = ANY($"+itoa(len(args))+")")
+	}
+	// The "match" sort treats notes, seasons and dayNight as preferences the
+	// way the catalog page does: they rank results instead of filtering them.
+	// With must notes, perfumes scoring below 1 are dropped, like minScore in
+	// computeCatalog; a season or dayNight hit alone can reach 1, so a kept
+	// perfume does not necessarily have a must note.
+	prefs := matchPreferences{mustNotes: mustNotes, avoidNotes: avoidNotes, seasons: seasons, dayNight: dayNight}
+	matchSQL := ""
+	if sort == "match" {
+		if !prefs.empty() {
+			matchSQL = prefs.scoreSQL(&args)
+		}
+		if len(mustNotes) > 0 {
+			addGroup("notes", matchSQL+" >= 1")
+		}
+	} else {
+		if len(seasons) > 0 {
+			args = append(args, pgtype.FlatArray[string](seasons))
+			addGroup("seasons", "seasons && $"+itoa(len(args)))
+		}
+		if len(dayNight) > 0 {
+			args = append(args, pgtype.FlatArray[string](dayNight))
+			addGroup("dayNight", "day_night && $"+itoa(len(args)))
+		}
+		if len(mustNotes) > 0 {
+			args = append(args, pgtype.FlatArray[string](mustNotes))
+			addGroup("notes", "(notes_top || notes_heart || notes_base) @> $"+itoa(len(args)))
+		}
+		if len(avoidNotes) > 0 {
+			args = append(args, pgtype.FlatArray[string](avoidNotes))
+			addGroup("notes", "NOT ((notes_top || notes_heart || notes_base) && $"+itoa(len(args))+")")
+		}
+	}
+	if minPrice != "" {
+		v, err := strconv.ParseFloat(minPrice, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid minPrice")
+			return
+		}
+		args = append(args, v)
+		addGroup("price", currentPriceSQL+" >= $"+itoa(len(args)))
+	}
+	if maxPrice != "" {
+		v, err := strconv.ParseFloat(maxPrice, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid maxPrice")
+			return
+		}
+		args = append(args, v)
+		addGroup("price", currentPriceSQL+" < $"+itoa(len(args)))
+	}
+	if inStock != "" {
+		v, err := strconv.ParseBool(inStock)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid inStock")
+			return
+		}
+		args = append(args, v)
+		addGroup("inStock", "in_stock = $"+itoa(len(args)))
+	}
+
+	commonWhere := where
+	where = append([]string{}, where...)
+	for _, g := range groups {
+		where = append(where, g.cond)
+	}
+	const updatedKey = "COALESCE(updated_at, '-infinity')"
+	updated := keysetKey{expr: updatedKey, cast: "timestamptz", desc: true}
+	order := keysetOrder{name: "updated", idCast: "text", keys: []keysetKey{updated}}
 	switch sort {
 	case "popular":
-		orderBy = "order_count DESC, is_hit DESC, updated_at DESC NULLS LAST, id"
+		order.name = sort
+		order.keys = []keysetKey{{expr: "order_count", cast: "int", desc: true}, {expr: "is_hit", cast: "boolean", desc: true}, updated}
 	case "new":
-		orderBy = "updated_at DESC NULLS LAST, created_at DESC, id"
+		order.name = sort
+		order.keys = []keysetKey{updated, {expr: "COALESCE(created_at, '-infinity')", cast: "timestamptz", desc: true}}
 	case "priceAsc":
-		orderBy = "base_price ASC, id"
+		order.name = sort
+		order.keys = []keysetKey{{expr: currentPriceSQL, cast: "numeric"}}
 	case "priceDesc":
-		orderBy = "base_price DESC, id"
+		order.name = sort
+		order.keys = []keysetKey{{expr: currentPriceSQL, cast: "numeric", desc: true}}
 	case "hit":
-		orderBy = "is_hit DESC, order_count DESC, id"
+		order.name = sort
+		order.keys = []keysetKey{{expr: "is_hit", cast: "boolean", desc: true}, {expr: "order_count", cast: "int", desc: true}}
+	case "trending":
+		order.name = sort
+		order.keys = []keysetKey{{expr: "trending_score", cast: "float8", desc: true}, {expr: "order_count", cast: "int", desc: true}}
+	case "match":
+		if matchSQL != "" {
+			order.name = sort
+			order.keys = []keysetKey{{expr: matchSQL, cast: "float8", desc: true}, updated}
+		}
+	case "relevance":
+		if q != "" {
+			order.name = sort
+			order.keys = []keysetKey{{expr: search.rank, cast: "float8", desc: true}, {expr: "order_count", cast: "int", desc: true}}
+		}
 	case "preset":
 		if presetArgIndex > 0 {
-			orderBy = "array_position($" + itoa(presetArgIndex) + "::text[], id) ASC NULLS LAST"
+			order.name = sort
+			order.keys = []keysetKey{{expr: "COALESCE(array_position($" + itoa(presetArgIndex) + "::text[], id), 2147483647)", cast: "int"}}
 		}
 	}
 
@@ -449,10 +558,11 @@ func (s *Server) handleListPerfumes(w http.ResponseWriter, r *http.Request) {
 			       to_json(seasons), to_json(day_night), base_price, base_volume, sillage, longevity,
 		       image_url, search_name_ru, is_hit, order_count, in_stock, stock_qty, currency,
 			       popularity, popularity_month, popularity_month_key,
-			       COALESCE(review_avg, 0), COALESCE(review_count, 0), created_at, updated_at
+			       COALESCE(review_avg, 0), COALESCE(review_count, 0), created_at, updated_at,
+			       `+perfumeVariantsJSON+`
 			FROM perfumes
-			WHERE `+whereSQL+`
-			ORDER BY `+orderBy, args...)
+			WHERE `+strings.Join(where, " AND ")+`
+			ORDER BY `+order.orderBy(), args...)
 		if err != nil {
 			writeError(w, http.StatusInternalServerError, "cannot load perfumes")
 			return
@@ -467,44 +577,96 @@ func (s *Server) handleListPerfumes(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var total int
-	if err := s.db.QueryRow("SELECT COUNT(*) FROM perfumes WHERE "+whereSQL, args...).Scan(&total); err != nil {
-		writeError(w, http.StatusInternalServerError, "cannot count perfumes")
+	paging, err := parsePageRequest(query, 12, 60, order)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	var db dbQueryer = s.db
+	if q != "" {
+		tx, err := beginPerfumeSearch(r.Context(), s.db)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot load perfumes")
+			return
+		}
+		defer tx.Rollback()
+		db = tx
+	}
+	var total int
+	if paging.withTotal {
+		if err := db.QueryRow("SELECT COUNT(*) FROM perfumes "+whereClause(where), args...).Scan(&total); err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot count perfumes")
+			return
+		}
+	}
 
-	offset := (page - 1) * pageSize
-	argsPage := append([]interface{}{}, args...)
-	argsPage = append(argsPage, pageSize, offset)
-	rows, err := s.db.Query(`
+	pageWhere, argsPage, tail := paging.apply(order, where, args)
+	searchColumns := ""
+	var (
+		relevance float64
+		snippet   string
+		keysJSON  []byte
+		ids       []string
+		keys      [][]string
+	)
+	extra := []interface{}{&keysJSON}
+	if q != "" {
+		searchColumns = ", " + search.rank + ", " + search.snippet
+		extra = append(extra, &relevance, &snippet)
+	}
+	decorate := func(p perfumePayload, item map[string]interface{}) {
+		ids = append(ids, p.ID)
+		keys = append(keys, parseTextArrayJSON(keysJSON))
+		if q != "" {
+			item["relevance"] = relevance
+			item["snippet"] = snippet
+		}
+		if !prefs.empty() {
+			match := scorePerfume(p, prefs)
+			item["score"] = match.score
+			item["matchedNotes"] = match.matchedNotes
+			item["avoidedNotes"] = match.avoidedNotes
+		}
+	}
+	rows, err := db.Query(`
 		SELECT id, catalog_mode, brand, name, family, description,
 		       to_json(tags), to_json(notes_top), to_json(notes_heart), to_json(notes_base),
 		       to_json(seasons), to_json(day_night), base_price, base_volume, sillage, longevity,
 		       image_url, search_name_ru, is_hit, order_count, in_stock, stock_qty, currency,
 		       popularity, popularity_month, popularity_month_key,
-		       COALESCE(review_avg, 0), COALESCE(review_count, 0), created_at, updated_at
+		       COALESCE(review_avg, 0), COALESCE(review_count, 0), created_at, updated_at,
+		       `+perfumeVariantsJSON+`, `+order.cursorColumn()+searchColumns+`
 		FROM perfumes
-		WHERE `+whereSQL+`
-		ORDER BY `+orderBy+`
-		LIMIT $`+itoa(len(argsPage)-1)+` OFFSET $`+itoa(len(argsPage))+`
-	`, argsPage...)
+		`+whereClause(pageWhere)+`
+		`+tail, argsPage...)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "cannot load perfumes")
 		return
 	}
 	defer rows.Close()
-	list, err := scanPerfumeRows(rows)
+	list, err := scanPerfumeRowsWith(rows, extra, decorate)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "cannot parse perfumes")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"items":    list,
-		"total":    total,
-		"page":     page,
-		"pageSize": pageSize,
-	})
+	next := paging.nextCursor(order, ids, keys)
+	if len(list) > paging.pageSize {
+		list = list[:paging.pageSize]
+	}
+	resp := paging.response(list, total, next)
+	for k, v := range fields {
+		resp[k] = v
+	}
+	if len(facetNames) > 0 {
+		facets, err := loadPerfumeFacets(r.Context(), db, facetNames, commonWhere, groups, args, priceEdges)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot load facets")
+			return
+		}
+		resp["facets"] = facets
+	}
+	writeJSON(w, http.StatusOK, resp)
 }
 
 func (s *Server) handleGetPerfume(w http.ResponseWriter, r *http.Request) {
@@ -522,6 +684,7 @@ func (s *Server) handleGetPerfume(w http.ResponseWriter, r *http.Request) {
 		notesBaseJSON  []byte
 		seasonsJSON    []byte
 		dayNightJSON   []byte
+		variantsJSON   []byte
 		createdAt      time.Time
 		updatedAt      sql.NullTime
 	)
@@ -531,7 +694,8 @@ func (s *Server) handleGetPerfume(w http.ResponseWriter, r *http.Request) {
 		       to_json(seasons), to_json(day_night), base_price, base_volume, sillage, longevity,
 		       image_url, search_name_ru, is_hit, order_count, in_stock, stock_qty, currency,
 		       popularity, popularity_month, popularity_month_key,
-		       COALESCE(review_avg, 0), COALESCE(review_count, 0), created_at, updated_at
+		       COALESCE(review_avg, 0), COALESCE(review_count, 0), created_at, updated_at,
+		       `+perfumeVariantsJSON+`
 		FROM perfumes
 		WHERE id = $1
 	`, id)
@@ -541,6 +705,7 @@ func (s *Server) handleGetPerfume(w http.ResponseWriter, r *http.Request) {
 		&seasonsJSON, &dayNightJSON, &p.BasePrice, &p.BaseVolume, &p.Sillage, &p.Longevity,
 		&p.Image, &p.SearchNameRu, &p.IsHit, &p.OrderCount, &p.InStock, &p.StockQty, &p.Currency,
 		&p.Popularity, &p.PopularityMonth, &p.PopularityMonthKey, &p.ReviewAvg, &p.ReviewCount, &createdAt, &updatedAt,
+		&variantsJSON,
 	); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			writeError(w, http.StatusNotFound, "not found")
@@ -555,8 +720,12 @@ func (s *Server) handleGetPerfume(w http.ResponseWriter, r *http.Request) {
 	p.Notes.Base = parseTextArrayJSON(notesBaseJSON)
 	p.Seasons = parseTextArrayJSON(seasonsJSON)
 	p.DayNight = parseTextArrayJSON(dayNightJSON)
+	p.Variants = parseVariantsJSON(variantsJSON)
 
 	payload := perfumeToResponse(p, createdAt, updatedAt)
+	if updatedAt.Valid {
+		w.Header().Set("ETag", perfumeETag(updatedAt.Time))
+	}
 	writeJSON(w, http.StatusOK, payload)
 }
 
@@ -575,6 +744,8 @@ func perfumeToResponse(p perfumePayload, createdAt time.Time, updatedAt sql.Null
 		"basePrice":          p.BasePrice,
 		"baseVolume":         p.BaseVolume,
 		"price":              p.BasePrice,
+		"compareAtPrice":     nil,
+		"saleEndsAt":         nil,
 		"volume":             p.BaseVolume,
 		"sillage":            p.Sillage,
 		"longevity":          p.Longevity,
@@ -590,16 +761,34 @@ func perfumeToResponse(p perfumePayload, createdAt time.Time, updatedAt sql.Null
 		"popularityMonthKey": p.PopularityMonthKey,
 		"reviewAvg":          p.ReviewAvg,
 		"reviewCount":        p.ReviewCount,
+		"variants":           p.Variants,
 		"createdAt":          createdAt.UTC().Format(time.RFC3339),
 	}
+	// On sale, price is what the default variant sells for now and
+	// compareAtPrice the regular price.
+	for _, v := range p.Variants {
+		if v.IsDefault && v.SalePrice != nil {
+			payload["price"] = *v.SalePrice
+			payload["compareAtPrice"] = p.BasePrice
+			if v.SaleEndsAt != "" {
+				payload["saleEndsAt"] = v.SaleEndsAt
+			}
+		}
+	}
 	if updatedAt.Valid {
 		payload["updatedAt"] = updatedAt.Time.UTC().Format(time.RFC3339)
+		payload["version"] = perfumeVersion(updatedAt.Time)
 	}
 	return payload
 }
 
 func (s *Server) handleUpsertPerfume(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
+	expected, err := parseIfMatch(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	var payload perfumePayload
 	if err := readJSON(r, &payload); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid json")
@@ -628,20 +817,69 @@ func (s *Server) handleUpsertPerfume(w http.ResponseWriter, r *http.Request) {
 	if payload.BaseVolume == 0 && payload.Volume > 0 {
 		payload.BaseVolume = payload.Volume
 	}
+	if payload.BaseVolume <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid baseVolume")
+		return
+	}
+	if err := canonicalizePerfume(s.db, &payload); err != nil {
+		writeReferenceError(w, err)
+		return
+	}
 
-	_, err := s.db.Exec(`
+	// The perfume, its default variant and its version are written together,
+	// so a variant conflict leaves the perfume as it was.
+	tx, err := s.db.Begin()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot start transaction")
+		return
+	}
+	defer tx.Rollback()
+	updatedAt, err := upsertPerfume(tx, id, mode, payload, expected)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.writePerfumeWriteMiss(w, id)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot save perfume")
+		return
+	}
+	if err := syncDefaultVariant(tx, id); err != nil {
+		writeDefaultVariantError(w, err)
+		return
+	}
+	user, _ := authUserFrom(r.Context())
+	if err := recordPerfumeVersion(tx, id, user.ID, 0); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot record perfume version")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot save perfume")
+		return
+	}
+
+	s.catalogChanged(r.Context())
+
+	w.Header().Set("ETag", perfumeETag(updatedAt))
+	writeJSON(w, http.StatusOK, map[string]string{"id": id, "version": perfumeVersion(updatedAt)})
+}
+
+// upsertPerfume writes the catalog fields of a perfume. Counters
+// (order_count, reviews, popularity) are maintained by the server and are
+// never taken from the payload. With expected set the update only applies to
+// that version; otherwise sql.ErrNoRows is returned.
+func upsertPerfume(q dbQueryer, id, mode string, payload perfumePayload, expected sql.NullTime) (time.Time, error) {
+	var updatedAt time.Time
+	err := q.QueryRow(`
 		INSERT INTO perfumes (
 			id, catalog_mode, brand, name, family, description,
 			tags, notes_top, notes_heart, notes_base, seasons, day_night,
 			base_price, base_volume, sillage, longevity, image_url, search_name_ru,
-			is_hit, order_count, in_stock, stock_qty, currency, popularity, popularity_month,
-			popularity_month_key, review_avg, review_count, created_at, updated_at
+			is_hit, in_stock, stock_qty, currency, created_at, updated_at
 		) VALUES (
 			$1,$2,$3,$4,$5,$6,
 			$7,$8,$9,$10,$11,$12,
 			$13,$14,$15,$16,$17,$18,
-			$19,$20,$21,$22,$23,$24,
-			$25,$26,$27,$28,now(),now()
+			$19,$20,$21,$22,now(),now()
 		)
 		ON CONFLICT (id) DO UPDATE SET
 			catalog_mode = EXCLUDED.catalog_mode,
@@ -662,16 +900,12 @@ func (s *Server) handleUpsertPerfume(w http.ResponseWriter, r *http.Request) {
 			image_url = EXCLUDED.image_url,
 			search_name_ru = EXCLUDED.search_name_ru,
 			is_hit = EXCLUDED.is_hit,
-			order_count = EXCLUDED.order_count,
 			in_stock = EXCLUDED.in_stock,
 			stock_qty = EXCLUDED.stock_qty,
 			currency = EXCLUDED.currency,
-			popularity = EXCLUDED.popularity,
-			popularity_month = EXCLUDED.popularity_month,
-			popularity_month_key = EXCLUDED.popularity_month_key,
-			review_avg = EXCLUDED.review_avg,
-			review_count = EXCLUDED.review_count,
 			updated_at = now()
+		WHERE $23::timestamptz IS NULL OR perfumes.updated_at = $23
+		RETURNING updated_at
 	`,
 		id, mode, payload.Brand, payload.Name, payload.Family, payload.Description,
 		pgtype.FlatArray[string](payload.Tags),
@@ -681,15 +915,9 @@ func (s *Server) handleUpsertPerfume(w http.ResponseWriter, r *http.Request) {
 		pgtype.FlatArray[string](payload.Seasons),
 		pgtype.FlatArray[string](payload.DayNight),
 		payload.BasePrice, payload.BaseVolume, payload.Sillage, payload.Longevity, payload.Image, payload.SearchNameRu,
-		payload.IsHit, payload.OrderCount, payload.InStock, payload.StockQty, payload.Currency, payload.Popularity,
-		payload.PopularityMonth, payload.PopularityMonthKey, payload.ReviewAvg, payload.ReviewCount,
-	)
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, "cannot save perfume")
-		return
-	}
-
-	writeJSON(w, http.StatusOK, map[string]string{"id": id})
+		payload.IsHit, payload.InStock, payload.StockQty, payload.Currency, expected,
+	).Scan(&updatedAt)
+	return updatedAt, err
 }
 
 func (s *Server) handleDeletePerfume(w http.ResponseWriter, r *http.Request) {
@@ -702,6 +930,7 @@ func (s *Server) handleDeletePerfume(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, "cannot delete perfume")
 		return
 	}
+	s.catalogChanged(r.Context())
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
@@ -720,6 +949,11 @@ func (s *Server) handleCreateOrder(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "empty order")
 		return
 	}
+	idempotencyKey := strings.TrimSpace(r.Header.Get(idempotencyHeader))
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		writeError(w, http.StatusBadRequest, "idempotency key too long")
+		return
+	}
 
 	contactName := strings.TrimSpace(req.Contact.Name)
 	contactEmail := strings.TrimSpace(req.Contact.Email)
@@ -758,36 +992,68 @@ func (s *Server) handleCreateOrder(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
+	// Retries of the same checkout get the original order back instead of
+	// creating a duplicate.
+	if idempotencyKey != "" {
+		replay, err := claimIdempotencyKey(tx, userCtx.ID, idempotencyKey, hashCreateOrderRequest(req), s.cfg.IdempotencyWindow, time.Now())
+		if err != nil {
+			if errors.Is(err, errIdempotencyMismatch) {
+				writeError(w, http.StatusConflict, err.Error())
+				return
+			}
+			writeError(w, http.StatusInternalServerError, "cannot check idempotency key")
+			return
+		}
+		if replay != nil {
+			writeIdempotentReplay(w, replay)
+			return
+		}
+	}
+
 	orderItems := make([]OrderItem, 0, len(req.Items))
+	lines := make([]cartLine, 0, len(req.Items))
 	var currency string
 	var total float64
 	for _, item := range req.Items {
 		if item.ID == "" || item.Qty <= 0 {
 			continue
 		}
-		var price float64
-		var itemCurrency string
-		if err := tx.QueryRow(`SELECT base_price, currency FROM perfumes WHERE id=$1`, item.ID).Scan(&price, &itemCurrency); err != nil {
+		variant, err := resolveOrderVariant(tx, item)
+		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
 				writeError(w, http.StatusBadRequest, "invalid perfume id")
 				return
 			}
+			if errors.Is(err, errInvalidVariant) || errors.Is(err, errUnknownVariant) {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
 			writeError(w, http.StatusInternalServerError, "cannot load perfume")
 			return
 		}
+		price := variant.Price
+		line := cartLine{Brand: variant.brand, Name: variant.name, CatalogMode: variant.catalogMode}
 		if currency == "" {
-			currency = itemCurrency
-		} else if itemCurrency != "" && itemCurrency != currency {
+			currency = variant.currency
+		} else if variant.currency != "" && variant.currency != currency {
 			writeError(w, http.StatusBadRequest, "mixed currency")
 			return
 		}
 		orderItems = append(orderItems, OrderItem{
-			ID:     item.ID,
-			Volume: item.Volume,
-			Mix:    item.Mix,
-			Qty:    item.Qty,
-			Price:  price,
+			ID:        item.ID,
+			VariantID: variant.ID,
+			SKU:       variant.SKU,
+			Brand:     line.Brand,
+			Name:      line.Name,
+			Volume:    float64(variant.Volume),
+			Mix:       variant.Mix,
+			Qty:       item.Qty,
+			Price:     price,
 		})
+		line.ID = item.ID
+		line.Price = price
+		line.Qty = item.Qty
+		lines = append(lines, line)
 		total += price * float64(item.Qty)
 	}
 	if len(orderItems) == 0 {
@@ -798,9 +1064,44 @@ func (s *Server) handleCreateOrder(w http.ResponseWriter, r *http.Request) {
 		currency = "₽"
 	}
 
-	itemsJSON, err := json.Marshal(orderItems)
+	subtotal := roundMoney(total)
+	discounts := []OrderDiscount{}
+	var discountTotal float64
+	var promo promoCode
+	promoCodeValue := strings.TrimSpace(req.PromoCode)
+	userKey := promoUserKey(userCtx, contactPhone)
+	if promoCodeValue != "" {
+		var discount OrderDiscount
+		promo, discount, err = applyPromo(tx, promoCodeValue, userKey, lines, time.Now(), true)
+		if err != nil {
+			if isPromoError(err) {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			writeError(w, http.StatusInternalServerError, "cannot apply promo")
+			return
+		}
+		promoCodeValue = promo.Code
+		discounts = append(discounts, discount)
+		discountTotal = discount.Amount
+	}
+
+	delivery, deliveryFee, deliveryAddress, err := resolveDelivery(
+		tx, strings.TrimSpace(req.Delivery.Method), strings.TrimSpace(req.Delivery.Address), lines, roundMoney(subtotal-discountTotal),
+	)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "cannot encode items")
+		if isDeliveryError(err) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "cannot load delivery method")
+		return
+	}
+	total = roundMoney(subtotal - discountTotal + deliveryFee)
+
+	discountsJSON, err := json.Marshal(discounts)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot encode discounts")
 		return
 	}
 
@@ -813,34 +1114,40 @@ func (s *Server) handleCreateOrder(w http.ResponseWriter, r *http.Request) {
 	}
 	err = tx.QueryRow(`
 		INSERT INTO orders (
-			user_id, is_anonymous, email, display_name, phone, items, total, currency,
-			channel, delivery_method, delivery_address, fulfilled, created_at
-		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,false,now())
+			user_id, is_anonymous, email, display_name, phone, total, currency,
+			channel, delivery_method, delivery_address, fulfilled, created_at,
+			subtotal, discount_total, promo_code, discounts, delivery_fee
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,false,now(),$11,$12,$13,$14,$15)
 		RETURNING id
-	`, userID, user.IsAnonymous, user.Email, user.DisplayName, contactPhone, itemsJSON, total, currency,
-		req.Channel, req.Delivery.Method, strings.TrimSpace(req.Delivery.Address),
+	`, userID, user.IsAnonymous, user.Email, user.DisplayName, contactPhone, total, currency,
+		req.Channel, delivery.ID, deliveryAddress,
+		subtotal, discountTotal, promoCodeValue, discountsJSON, deliveryFee,
 	).Scan(&orderID)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "cannot create order")
 		return
 	}
+	if err := insertOrderItems(tx, orderID, orderItems); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot create order")
+		return
+	}
 
-	counts := make(map[string]int)
-	for _, item := range orderItems {
-		if item.ID == "" {
-			continue
+	if promo.ID != "" {
+		if err := redeemPromo(tx, promo, orderID, userKey, discountTotal); err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot redeem promo")
+			return
 		}
-		counts[item.ID] += item.Qty
 	}
 
-	for id, qty := range counts {
-		if qty <= 0 {
-			continue
-		}
-		if _, err := tx.Exec(`
-			UPDATE perfumes SET order_count = COALESCE(order_count, 0) + $1 WHERE id = $2
-		`, qty, id); err != nil {
-			writeError(w, http.StatusInternalServerError, "cannot update perfume order count")
+	if err := incrementOrderCounts(tx, orderID); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot update perfume order count")
+		return
+	}
+
+	response := map[string]string{"id": orderID, "accessToken": s.orderAccessToken(orderID)}
+	if idempotencyKey != "" {
+		if err := storeIdempotentResponse(tx, userCtx.ID, idempotencyKey, orderID, http.StatusCreated, response); err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot save order")
 			return
 		}
 	}
@@ -850,93 +1157,100 @@ func (s *Server) handleCreateOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeJSON(w, http.StatusCreated, map[string]string{"id": orderID})
+	writeJSON(w, http.StatusCreated, response)
 }
 
 func (s *Server) handleListOrders(w http.ResponseWriter, r *http.Request) {
-	page := parsePositiveInt(strings.TrimSpace(r.URL.Query().Get("page")), 1)
-	pageSize := parsePositiveInt(strings.TrimSpace(r.URL.Query().Get("pageSize")), 20)
-	if pageSize < 1 {
-		pageSize = 20
-	}
-	if pageSize > 100 {
-		pageSize = 100
-	}
-	channel := strings.TrimSpace(r.URL.Query().Get("channel"))
-	fulfilledParam := strings.TrimSpace(r.URL.Query().Get("fulfilled"))
-
-	where := []string{}
-	args := []interface{}{}
-	if channel != "" && channel != "all" {
-		args = append(args, channel)
-		where = append(where, "channel = $"+itoa(len(args)))
+	sort, err := parseOrderSort(r.URL.Query().Get("sort"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
 	}
-	if fulfilledParam != "" {
-		if fulfilledParam == "true" || fulfilledParam == "false" {
-			args = append(args, fulfilledParam == "true")
-			where = append(where, "fulfilled = $"+itoa(len(args)))
-		}
+	paging, err := parsePageRequest(r.URL.Query(), 20, 100, sort)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
 	}
-	whereSQL := ""
-	if len(where) > 0 {
-		whereSQL = "WHERE " + strings.Join(where, " AND ")
+	where, args, err := parseOrderFilters(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	var total int
-	if err := s.db.QueryRow("SELECT COUNT(*) FROM orders "+whereSQL, args...).Scan(&total); err != nil {
-		writeError(w, http.StatusInternalServerError, "cannot count orders")
-		return
+	if paging.withTotal {
+		if err := s.db.QueryRow("SELECT COUNT(*) FROM orders "+whereClause(where), args...).Scan(&total); err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot count orders")
+			return
+		}
 	}
-	offset := (page - 1) * pageSize
-	argsPage := append([]interface{}{}, args...)
-	argsPage = append(argsPage, pageSize, offset)
 
+	pageWhere, argsPage, tail := paging.apply(sort, where, args)
 	rows, err := s.db.Query(`
-		SELECT id, user_id, is_anonymous, email, display_name, phone, items, total, currency,
-		       channel, delivery_method, delivery_address, fulfilled, created_at
+		SELECT `+orderColumns+`, `+sort.cursorColumn()+`
 		FROM orders
-		`+whereSQL+`
-		ORDER BY created_at DESC
-		LIMIT $`+itoa(len(argsPage)-1)+` OFFSET $`+itoa(len(argsPage))+`
-	`, argsPage...)
+		`+whereClause(pageWhere)+`
+		`+tail, argsPage...)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "cannot load orders")
 		return
 	}
 	defer rows.Close()
 
-	var list []Order
+	var (
+		list []Order
+		ids  []string
+		keys [][]string
+	)
 	for rows.Next() {
-		var (
-			order     Order
-			userID    sql.NullString
-			itemsJSON []byte
-			createdAt time.Time
-		)
-		if err := rows.Scan(
-			&order.ID, &userID, &order.IsAnonymous, &order.Email, &order.DisplayName, &order.Phone, &itemsJSON,
-			&order.Total, &order.Currency, &order.Channel, &order.DeliveryMethod, &order.DeliveryAddress,
-			&order.Fulfilled, &createdAt,
-		); err != nil {
+		var keysJSON []byte
+		order, err := scanOrder(extraScanner{row: rows, extra: []interface{}{&keysJSON}})
+		if err != nil {
 			writeError(w, http.StatusInternalServerError, "cannot parse orders")
 			return
 		}
-		if userID.Valid {
-			order.UserID = userID.String
-		}
-		if err := json.Unmarshal(itemsJSON, &order.Items); err != nil {
-			order.Items = []OrderItem{}
-		}
-		order.CreatedAt = createdAt.UTC().Format(time.RFC3339)
 		list = append(list, order)
+		ids = append(ids, order.ID)
+		keys = append(keys, parseTextArrayJSON(keysJSON))
+	}
+	next := paging.nextCursor(sort, ids, keys)
+	if len(list) > paging.pageSize {
+		list = list[:paging.pageSize]
 	}
+	writeJSON(w, http.StatusOK, paging.response(list, total, next))
+}
 
-	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"items":    list,
-		"total":    total,
-		"page":     page,
-		"pageSize": pageSize,
-	})
+const orderColumns = `id, user_id, is_anonymous, email, display_name, phone, ` + orderItemsJSON + ` AS items, total, currency,
+	channel, delivery_method, delivery_address, fulfilled, created_at,
+	subtotal, discount_total, promo_code, discounts, delivery_fee`
+
+func scanOrder(row interface{ Scan(...interface{}) error }) (Order, error) {
+	var (
+		order         Order
+		userID        sql.NullString
+		itemsJSON     []byte
+		discountsJSON []byte
+		createdAt     time.Time
+	)
+	if err := row.Scan(
+		&order.ID, &userID, &order.IsAnonymous, &order.Email, &order.DisplayName, &order.Phone, &itemsJSON,
+		&order.Total, &order.Currency, &order.Channel, &order.DeliveryMethod, &order.DeliveryAddress,
+		&order.Fulfilled, &createdAt,
+		&order.Subtotal, &order.Discount, &order.PromoCode, &discountsJSON, &order.DeliveryFee,
+	); err != nil {
+		return Order{}, err
+	}
+	if userID.Valid {
+		order.UserID = userID.String
+	}
+	if err := json.Unmarshal(itemsJSON, &order.Items); err != nil {
+		order.Items = []OrderItem{}
+	}
+	if err := json.Unmarshal(discountsJSON, &order.Discounts); err != nil || order.Discounts == nil {
+		order.Discounts = []OrderDiscount{}
+	}
+	order.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+	return order, nil
 }
 
 func (s *Server) handleUpdateOrder(w http.ResponseWriter, r *http.Request) {
@@ -960,8 +1274,7 @@ func (s *Server) handleUpdateOrder(w http.ResponseWriter, r *http.Request) {
 	defer tx.Rollback()
 
 	var currentFulfilled bool
-	var itemsJSON []byte
-	if err := tx.QueryRow(`SELECT fulfilled, items FROM orders WHERE id=$1`, id).Scan(&currentFulfilled, &itemsJSON); err != nil {
+	if err := tx.QueryRow(`SELECT fulfilled FROM orders WHERE id=$1`, id).Scan(&currentFulfilled); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			writeError(w, http.StatusNotFound, "not found")
 			return
@@ -976,22 +1289,13 @@ func (s *Server) handleUpdateOrder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !currentFulfilled && body.Fulfilled {
-		var items []OrderItem
-		if err := json.Unmarshal(itemsJSON, &items); err == nil {
-			for _, item := range items {
-				if item.ID == "" || item.Qty <= 0 {
-					continue
-				}
-				if _, err := tx.Exec(`
-					UPDATE perfumes
-					SET stock_qty = GREATEST(stock_qty - $1, 0),
-					    in_stock = CASE WHEN stock_qty - $1 <= 0 THEN false ELSE in_stock END
-					WHERE id = $2 AND stock_qty IS NOT NULL
-				`, item.Qty, item.ID); err != nil {
-					writeError(w, http.StatusInternalServerError, "cannot update stock")
-					return
-				}
-			}
+		if err := decrementOrderStock(tx, id); err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot update stock")
+			return
+		}
+		if _, _, err := assignInvoiceNumber(tx, id, time.Now().UTC()); err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot assign invoice number")
+			return
 		}
 	}
 
@@ -1015,47 +1319,50 @@ func (s *Server) handleDeleteOrder(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
+// usersOrder is the order of the user list, newest first.
+var usersOrder = keysetOrder{name: "created_desc", idCast: "uuid", idDesc: true, keys: []keysetKey{{"created_at", "timestamptz", true}}}
+
+// handleListUsers returns a page of registered users, newest first,
+// optionally filtered by q.
 func (s *Server) handleListUsers(w http.ResponseWriter, r *http.Request) {
-	page := parsePositiveInt(strings.TrimSpace(r.URL.Query().Get("page")), 1)
-	pageSize := parsePositiveInt(strings.TrimSpace(r.URL.Query().Get("pageSize")), 20)
-	if pageSize < 1 {
-		pageSize = 20
-	}
-	if pageSize > 100 {
-		pageSize = 100
+	query := r.URL.Query()
+	paging, err := parsePageRequest(query, 20, 100, usersOrder)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
 	}
-	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	q := strings.TrimSpace(query.Get("q"))
 	where := []string{"is_anonymous = false"}
 	args := []interface{}{}
 	if q != "" {
 		args = append(args, "%"+q+"%")
 		where = append(where, "(email ILIKE $"+itoa(len(args))+" OR display_name ILIKE $"+itoa(len(args))+" OR id::text ILIKE $"+itoa(len(args))+")")
 	}
-	whereSQL := "WHERE " + strings.Join(where, " AND ")
 
 	var total int
-	if err := s.db.QueryRow("SELECT COUNT(*) FROM users "+whereSQL, args...).Scan(&total); err != nil {
-		writeError(w, http.StatusInternalServerError, "cannot count users")
-		return
+	if paging.withTotal {
+		if err := s.db.QueryRow("SELECT COUNT(*) FROM users "+whereClause(where), args...).Scan(&total); err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot count users")
+			return
+		}
 	}
-	offset := (page - 1) * pageSize
-	argsPage := append([]interface{}{}, args...)
-	argsPage = append(argsPage, pageSize, offset)
-
+	pageWhere, argsPage, tail := paging.apply(usersOrder, where, args)
 	rows, err := s.db.Query(`
-		SELECT id, email, display_name, is_admin, is_anonymous, created_at, updated_at
+		SELECT id, email, display_name, is_admin, is_anonymous, created_at, updated_at, `+usersOrder.cursorColumn()+`
 		FROM users
-		`+whereSQL+`
-		ORDER BY created_at DESC
-		LIMIT $`+itoa(len(argsPage)-1)+` OFFSET $`+itoa(len(argsPage))+`
-	`, argsPage...)
+		`+whereClause(pageWhere)+`
+		`+tail, argsPage...)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "cannot load users")
 		return
 	}
 	defer rows.Close()
 
-	var list []User
+	var (
+		list []User
+		ids  []string
+		keys [][]string
+	)
 	for rows.Next() {
 		var (
 			user        User
@@ -1063,8 +1370,9 @@ func (s *Server) handleListUsers(w http.ResponseWriter, r *http.Request) {
 			displayName sql.NullString
 			createdAt   time.Time
 			updatedAt   sql.NullTime
+			keysJSON    []byte
 		)
-		if err := rows.Scan(&user.ID, &dbEmail, &displayName, &user.IsAdmin, &user.IsAnonymous, &createdAt, &updatedAt); err != nil {
+		if err := rows.Scan(&user.ID, &dbEmail, &displayName, &user.IsAdmin, &user.IsAnonymous, &createdAt, &updatedAt, &keysJSON); err != nil {
 			log.Printf("scan users: %v", err)
 			writeError(w, http.StatusInternalServerError, "cannot parse users")
 			return
@@ -1080,13 +1388,14 @@ func (s *Server) handleListUsers(w http.ResponseWriter, r *http.Request) {
 			user.UpdatedAt = updatedAt.Time.UTC().Format(time.RFC3339)
 		}
 		list = append(list, user)
+		ids = append(ids, user.ID)
+		keys = append(keys, parseTextArrayJSON(keysJSON))
 	}
-	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"items":    list,
-		"total":    total,
-		"page":     page,
-		"pageSize": pageSize,
-	})
+	next := paging.nextCursor(usersOrder, ids, keys)
+	if len(list) > paging.pageSize {
+		list = list[:paging.pageSize]
+	}
+	writeJSON(w, http.StatusOK, paging.response(list, total, next))
 }
 
 func (s *Server) handleSetUserAdmin(w http.ResponseWriter, r *http.Request) {
@@ -1182,6 +1491,12 @@ func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
+// stockOrder lists tracked stock first, lowest quantity first.
+var stockOrder = keysetOrder{name: "stock", idCast: "text", keys: []keysetKey{
+	{"COALESCE(stock_qty, 2147483647)", "int", false},
+	{"COALESCE(updated_at, '-infinity')", "timestamptz", true},
+}}
+
 func (s *Server) handleStockReport(w http.ResponseWriter, r *http.Request) {
 	q := strings.TrimSpace(r.URL.Query().Get("q"))
 	includeUnlimited := strings.EqualFold(strings.TrimSpace(r.URL.Query().Get("includeUnlimited")), "true")
@@ -1189,13 +1504,10 @@ func (s *Server) handleStockReport(w http.ResponseWriter, r *http.Request) {
 	if low <= 0 {
 		low = 5
 	}
-	page := parsePositiveInt(strings.TrimSpace(r.URL.Query().Get("page")), 1)
-	pageSize := parsePositiveInt(strings.TrimSpace(r.URL.Query().Get("pageSize")), 20)
-	if pageSize < 1 {
-		pageSize = 20
-	}
-	if pageSize > 100 {
-		pageSize = 100
+	paging, err := parsePageRequest(r.URL.Query(), 20, 100, stockOrder)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	where := []string{}
@@ -1207,10 +1519,7 @@ func (s *Server) handleStockReport(w http.ResponseWriter, r *http.Request) {
 		args = append(args, "%"+q+"%")
 		where = append(where, "(id ILIKE $"+itoa(len(args))+" OR brand ILIKE $"+itoa(len(args))+" OR name ILIKE $"+itoa(len(args))+")")
 	}
-	whereSQL := ""
-	if len(where) > 0 {
-		whereSQL = "WHERE " + strings.Join(where, " AND ")
-	}
+	whereSQL := whereClause(where)
 
 	var summary struct {
 		Total     int `json:"total"`
@@ -1233,23 +1542,14 @@ func (s *Server) handleStockReport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var total int
-	if err := s.db.QueryRow("SELECT COUNT(*) FROM perfumes "+whereSQL, args...).Scan(&total); err != nil {
-		writeError(w, http.StatusInternalServerError, "cannot count stock")
-		return
-	}
-
-	offset := (page - 1) * pageSize
-	argsPage := append([]interface{}{}, args...)
-	argsPage = append(argsPage, pageSize, offset)
-
+	// The summary already counts every matching row, so the page total comes
+	// from it instead of a separate COUNT(*).
+	pageWhere, argsPage, tail := paging.apply(stockOrder, where, args)
 	rows, err := s.db.Query(`
-		SELECT id, brand, name, image_url, in_stock, stock_qty, updated_at
+		SELECT id, brand, name, image_url, in_stock, stock_qty, updated_at, `+stockOrder.cursorColumn()+`
 		FROM perfumes
-		`+whereSQL+`
-		ORDER BY stock_qty ASC NULLS LAST, updated_at DESC NULLS LAST, id
-		LIMIT $`+itoa(len(argsPage)-1)+` OFFSET $`+itoa(len(argsPage))+`
-	`, argsPage...)
+		`+whereClause(pageWhere)+`
+		`+tail, argsPage...)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "cannot load stock")
 		return
@@ -1265,14 +1565,19 @@ func (s *Server) handleStockReport(w http.ResponseWriter, r *http.Request) {
 		StockQty *int   `json:"stockQty"`
 		Updated  string `json:"updatedAt"`
 	}
-	var list []stockRow
+	var (
+		list []stockRow
+		ids  []string
+		keys [][]string
+	)
 	for rows.Next() {
 		var (
 			row      stockRow
 			qty      sql.NullInt64
 			updated  sql.NullTime
+			keysJSON []byte
 		)
-		if err := rows.Scan(&row.ID, &row.Brand, &row.Name, &row.Image, &row.InStock, &qty, &updated); err != nil {
+		if err := rows.Scan(&row.ID, &row.Brand, &row.Name, &row.Image, &row.InStock, &qty, &updated, &keysJSON); err != nil {
 			writeError(w, http.StatusInternalServerError, "cannot parse stock")
 			return
 		}
@@ -1284,15 +1589,18 @@ func (s *Server) handleStockReport(w http.ResponseWriter, r *http.Request) {
 			row.Updated = updated.Time.UTC().Format(time.RFC3339)
 		}
 		list = append(list, row)
+		ids = append(ids, row.ID)
+		keys = append(keys, parseTextArrayJSON(keysJSON))
 	}
 
-	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"items":    list,
-		"summary":  summary,
-		"total":    total,
-		"page":     page,
-		"pageSize": pageSize,
-	})
+	next := paging.nextCursor(stockOrder, ids, keys)
+	if len(list) > paging.pageSize {
+		list = list[:paging.pageSize]
+	}
+	resp := paging.response(list, summary.Total, next)
+	resp["total"] = summary.Total
+	resp["summary"] = summary
+	writeJSON(w, http.StatusOK, resp)
 }
 
 func (s *Server) handleUpdateStock(w http.ResponseWriter, r *http.Request) {
@@ -1313,16 +1621,24 @@ func (s *Server) handleUpdateStock(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// With several variants the perfume stock is their sum and has to be
+	// edited per variant.
+	var variants int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM perfume_variants WHERE perfume_id=$1`, id).Scan(&variants); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot update stock")
+		return
+	}
+	if variants > 1 {
+		writeError(w, http.StatusConflict, "stock is tracked per variant")
+		return
+	}
+
 	if body.StockQty == nil {
 		if _, err := s.db.Exec(`UPDATE perfumes SET stock_qty=NULL WHERE id=$1`, id); err != nil {
 			writeError(w, http.StatusInternalServerError, "cannot update stock")
 			return
 		}
-		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
-		return
-	}
-
-	if _, err := s.db.Exec(`
+	} else if _, err := s.db.Exec(`
 		UPDATE perfumes
 		SET stock_qty=$1,
 		    in_stock = CASE WHEN $1 > 0 THEN true ELSE false END
@@ -1331,6 +1647,10 @@ func (s *Server) handleUpdateStock(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, "cannot update stock")
 		return
 	}
+	if err := syncDefaultVariant(s.db, id); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot update stock")
+		return
+	}
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
@@ -1376,6 +1696,23 @@ func (s *Server) handleSaveCart(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "invalid json")
 		return
 	}
+	// Cart lines matching a variant by volume and mix are pinned to it, so
+	// the cart keeps the customer's pick when variants are edited.
+	for i, item := range payload.Items {
+		v, err := resolveOrderVariant(s.db, item)
+		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errInvalidVariant) || errors.Is(err, errUnknownVariant) {
+			continue
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot save cart")
+			return
+		}
+		if !v.matched {
+			continue
+		}
+		payload.Items[i].VariantID = v.ID
+		payload.Items[i].SKU = v.SKU
+	}
 	itemsJSON, err := json.Marshal(payload.Items)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "cannot encode cart")
@@ -1880,6 +2217,13 @@ func parseTextArrayJSON(data []byte) []string {
 }
 
 func scanPerfumeRows(rows *sql.Rows) ([]map[string]interface{}, error) {
+	return scanPerfumeRowsWith(rows, nil, nil)
+}
+
+// scanPerfumeRowsWith scans perfume rows followed by extra columns; decorate
+// is called after each row to add the extra values or computed fields to the
+// item.
+func scanPerfumeRowsWith(rows *sql.Rows, extra []interface{}, decorate func(perfumePayload, map[string]interface{})) ([]map[string]interface{}, error) {
 	var list []map[string]interface{}
 	for rows.Next() {
 		var (
@@ -1890,16 +2234,19 @@ func scanPerfumeRows(rows *sql.Rows) ([]map[string]interface{}, error) {
 			notesBaseJSON  []byte
 			seasonsJSON    []byte
 			dayNightJSON   []byte
+			variantsJSON   []byte
 			createdAt      time.Time
 			updatedAt      sql.NullTime
 		)
-		if err := rows.Scan(
+		dest := []interface{}{
 			&p.ID, &p.CatalogMode, &p.Brand, &p.Name, &p.Family, &p.Description,
 			&tagsJSON, &notesTopJSON, &notesHeartJSON, &notesBaseJSON,
 			&seasonsJSON, &dayNightJSON, &p.BasePrice, &p.BaseVolume, &p.Sillage, &p.Longevity,
 		&p.Image, &p.SearchNameRu, &p.IsHit, &p.OrderCount, &p.InStock, &p.StockQty, &p.Currency,
 			&p.Popularity, &p.PopularityMonth, &p.PopularityMonthKey, &p.ReviewAvg, &p.ReviewCount, &createdAt, &updatedAt,
-		); err != nil {
+			&variantsJSON,
+		}
+		if err := rows.Scan(append(dest, extra...)...); err != nil {
 			log.Printf("scan perfumes: %v", err)
 			return nil, err
 		}
@@ -1909,7 +2256,11 @@ func scanPerfumeRows(rows *sql.Rows) ([]map[string]interface{}, error) {
 		p.Notes.Base = parseTextArrayJSON(notesBaseJSON)
 		p.Seasons = parseTextArrayJSON(seasonsJSON)
 		p.DayNight = parseTextArrayJSON(dayNightJSON)
+		p.Variants = parseVariantsJSON(variantsJSON)
 		payload := perfumeToResponse(p, createdAt, updatedAt)
+		if decorate != nil {
+			decorate(p, payload)
+		}
 		list = append(list, payload)
 	}
 	return list, nil
@@ -3,6 +3,7 @@ package httpapi
 import (
 	"bytes"
 	"context"
+	"database/sql/driver"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -14,6 +15,23 @@ import (
 	"parfum-backend/internal/app"
 )
 
+// passthroughConverter lets pgx-specific argument types such as
+// pgtype.FlatArray reach sqlmock untouched.
+type passthroughConverter struct{}
+
+func (passthroughConverter) ConvertValue(v interface{}) (driver.Value, error) {
+	if driver.IsValue(v) {
+		return v, nil
+	}
+	if valuer, ok := v.(driver.Valuer); ok {
+		return valuer.Value()
+	}
+	if conv, err := driver.DefaultParameterConverter.ConvertValue(v); err == nil {
+		return conv, nil
+	}
+	return v, nil
+}
+
 func TestRequireAdminRejectsNonAdmin(t *testing.T) {
 	s := NewServer(app.Config{JWTSecret: "test-secret"}, nil)
 	token, err := s.issueToken(authUser{ID: "u1", IsAdmin: false}, time.Minute)
@@ -102,9 +120,12 @@ func TestHandleListUsers(t *testing.T) {
 	defer db.Close()
 
 	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
-	rows := sqlmock.NewRows([]string{"id", "email", "display_name", "is_admin", "is_anonymous", "created_at", "updated_at"}).
-		AddRow("u1", "a@example.com", "Alice", false, false, time.Now(), time.Now())
-	mock.ExpectQuery("(?s)SELECT id, email, display_name, is_admin, is_anonymous, created_at, updated_at FROM users.*is_anonymous = false").
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM users WHERE is_anonymous = false").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	rows := sqlmock.NewRows([]string{"id", "email", "display_name", "is_admin", "is_anonymous", "created_at", "updated_at", "cursor"}).
+		AddRow("u1", "a@example.com", "Alice", false, false, time.Now(), time.Now(), []byte(`["2024-03-01 10:00:00+00"]`))
+	mock.ExpectQuery("(?s)SELECT id, email, display_name, is_admin, is_anonymous, created_at, updated_at, to_json.*FROM users.*is_anonymous = false.*LIMIT \\$1 OFFSET \\$2").
+		WithArgs(21, 0).
 		WillReturnRows(rows)
 
 	req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
@@ -115,11 +136,17 @@ func TestHandleListUsers(t *testing.T) {
 		t.Fatalf("expected %d, got %d", http.StatusOK, rr.Code)
 	}
 
-	var out []User
+	// A bare request still gets the page envelope with the default size.
+	var out struct {
+		Items    []User `json:"items"`
+		Total    int    `json:"total"`
+		Page     int    `json:"page"`
+		PageSize int    `json:"pageSize"`
+	}
 	if err := json.NewDecoder(rr.Body).Decode(&out); err != nil {
 		t.Fatalf("decode: %v", err)
 	}
-	if len(out) != 1 || out[0].ID != "u1" {
+	if len(out.Items) != 1 || out.Items[0].ID != "u1" || out.Total != 1 || out.Page != 1 || out.PageSize != 20 {
 		t.Fatalf("unexpected response: %#v", out)
 	}
 
@@ -129,7 +156,7 @@ func TestHandleListUsers(t *testing.T) {
 }
 
 func TestHandleCreateOrderGuest(t *testing.T) {
-	db, mock, err := sqlmock.New()
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passthroughConverter{}))
 	if err != nil {
 		t.Fatalf("sqlmock: %v", err)
 	}
@@ -160,20 +187,31 @@ func TestHandleCreateOrderGuest(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	mock.ExpectBegin()
-	mock.ExpectQuery("SELECT base_price, currency FROM perfumes WHERE id=\\$1").
-		WithArgs("p1").
-		WillReturnRows(sqlmock.NewRows([]string{"base_price", "currency"}).AddRow(50.0, "₽"))
+	mock.ExpectQuery("(?s)FROM perfumes p\\s+JOIN perfume_variants v ON v.perfume_id = p.id\\s+LEFT JOIN LATERAL variant_sale\\(v.id\\) s ON true\\s+WHERE p.id = \\$1").
+		WithArgs("p1", "", 0.0, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "volume", "mix", "price", "currency", "brand", "name", "catalog_mode", "matched"}).
+			AddRow("v1", "p1-50", 50, "60/40", 50.0, "₽", "Chanel", "Chance", "retail", false))
+	mock.ExpectQuery("(?s)FROM delivery_methods\\s+WHERE id = \\$1").
+		WithArgs("pickup").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "kind", "title", "description", "address", "price", "free_from", "catalog_modes",
+			"is_active", "position", "created_at", "updated_at",
+		}).AddRow("pickup", "pickup", "Самовывоз", "", "", 0.0, nil, []byte(`["retail","wholesale"]`), true, 0, time.Now(), time.Now()))
 	mock.ExpectQuery("(?s)INSERT INTO orders").
-		WithArgs(nil, true, "", "Гость", "+79990001122", sqlmock.AnyArg(), 100.0, "₽", "wa", "pickup", "").
+		WithArgs(nil, true, "", "Гость", "+79990001122", 100.0, "₽", "wa", "pickup", "",
+			100.0, 0.0, "", sqlmock.AnyArg(), 0.0).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("order1"))
-	mock.ExpectExec("(?s)UPDATE perfumes SET order_count").
-		WithArgs(2, "p1").
+	mock.ExpectExec("(?s)INSERT INTO order_items").
+		WithArgs("order1", 0, "p1", "Chanel", "Chance", 50.0, "60/40", 2, 50.0, "v1", "p1-50").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("(?s)UPDATE perfumes p\\s+SET order_count").
+		WithArgs("order1").
 		WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
 
 	s.handleCreateOrder(rr, req)
 	if rr.Code != http.StatusCreated {
-		t.Fatalf("expected %d, got %d", http.StatusCreated, rr.Code)
+		t.Fatalf("expected %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
 	}
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Fatalf("db expectations: %v", err)
@@ -0,0 +1,108 @@
+package httpapi
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const (
+	idempotencyHeader        = "Idempotency-Key"
+	maxIdempotencyKeyLength  = 255
+	defaultIdempotencyWindow = 24 * time.Hour
+)
+
+var errIdempotencyMismatch = errors.New("idempotency key reused with a different request")
+
+// idempotentResponse is a stored response that is replayed for retries.
+type idempotentResponse struct {
+	status int
+	body   []byte
+}
+
+// hashCreateOrderRequest hashes the decoded request so that retries which
+// only differ in whitespace or key order still match.
+func hashCreateOrderRequest(req createOrderRequest) string {
+	data, _ := json.Marshal(req)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// claimIdempotencyKey records key for the caller inside the order
+// transaction. A concurrent retry blocks on the insert until the first
+// request commits and then sees its stored response. Keys older than window
+// are treated as unused.
+func claimIdempotencyKey(q dbQueryer, scope, key, hash string, window time.Duration, now time.Time) (*idempotentResponse, error) {
+	if window <= 0 {
+		window = defaultIdempotencyWindow
+	}
+	cutoff := now.Add(-window)
+	if _, err := q.Exec(`DELETE FROM order_idempotency_keys WHERE created_at < $1 AND NOT (scope = $2 AND key = $3)`, cutoff, scope, key); err != nil {
+		return nil, err
+	}
+
+	var claimed string
+	err := q.QueryRow(`
+		INSERT INTO order_idempotency_keys (scope, key, request_hash, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (scope, key) DO UPDATE SET
+			request_hash = EXCLUDED.request_hash,
+			order_id = NULL,
+			response_status = NULL,
+			response_body = NULL,
+			created_at = EXCLUDED.created_at
+		WHERE order_idempotency_keys.created_at < $5
+		RETURNING key
+	`, scope, key, hash, now, cutoff).Scan(&claimed)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	var (
+		storedHash string
+		status     sql.NullInt64
+		body       []byte
+	)
+	if err := q.QueryRow(`
+		SELECT request_hash, response_status, response_body
+		FROM order_idempotency_keys
+		WHERE scope = $1 AND key = $2
+	`, scope, key).Scan(&storedHash, &status, &body); err != nil {
+		return nil, err
+	}
+	if storedHash != hash {
+		return nil, errIdempotencyMismatch
+	}
+	if !status.Valid {
+		return nil, errors.New("idempotency key has no stored response")
+	}
+	return &idempotentResponse{status: int(status.Int64), body: body}, nil
+}
+
+func storeIdempotentResponse(q dbQueryer, scope, key, orderID string, status int, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(`
+		UPDATE order_idempotency_keys
+		SET order_id = $3, response_status = $4, response_body = $5
+		WHERE scope = $1 AND key = $2
+	`, scope, key, orderID, status, body)
+	return err
+}
+
+func writeIdempotentReplay(w http.ResponseWriter, resp *idempotentResponse) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(resp.status)
+	_, _ = w.Write(resp.body)
+	_, _ = w.Write([]byte("\n"))
+}
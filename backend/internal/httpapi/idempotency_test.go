@@ -0,0 +1,74 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"parfum-backend/internal/app"
+)
+
+func idempotentOrderRequest(t *testing.T, s *Server, body createOrderRequest, key string) *httptest.ResponseRecorder {
+	t.Helper()
+	payload, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/api/orders", bytes.NewReader(payload))
+	req.Header.Set(idempotencyHeader, key)
+	req = req.WithContext(withAuthUser(context.Background(), authUser{ID: "guest_1", IsAnonymous: true}))
+	rr := httptest.NewRecorder()
+	s.handleCreateOrder(rr, req)
+	return rr
+}
+
+func TestHandleCreateOrderIdempotencyKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	body := createOrderRequest{Items: []OrderItem{{ID: "p1", Qty: 1}}, Channel: "web"}
+	body.Contact.Phone = "+79990001600"
+	hash := hashCreateOrderRequest(body)
+
+	expectExisting := func(storedHash string) {
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM order_idempotency_keys").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("(?s)INSERT INTO order_idempotency_keys").
+			WithArgs("guest_1", "key-1", hash, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery("(?s)SELECT request_hash, response_status, response_body").
+			WithArgs("guest_1", "key-1").
+			WillReturnRows(sqlmock.NewRows([]string{"request_hash", "response_status", "response_body"}).
+				AddRow(storedHash, 201, []byte(`{"id": "order1"}`)))
+		mock.ExpectRollback()
+	}
+
+	// A retry with the same payload gets the original order back.
+	expectExisting(hash)
+	rr := idempotentOrderRequest(t, s, body, "key-1")
+	if rr.Code != http.StatusCreated || rr.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("expected replayed 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp map[string]string
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || resp["id"] != "order1" {
+		t.Fatalf("unexpected replay body: %s", rr.Body.String())
+	}
+
+	// The same key with a different payload is rejected.
+	expectExisting("other-hash")
+	rr = idempotentOrderRequest(t, s, body, "key-1")
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
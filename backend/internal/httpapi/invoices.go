@@ -0,0 +1,331 @@
+package httpapi
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-pdf/fpdf"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+func deliveryTitle(q dbQueryer, methodID string) string {
+	var title string
+	if err := q.QueryRow(`SELECT title FROM delivery_methods WHERE id = $1`, methodID).Scan(&title); err != nil {
+		return methodID
+	}
+	return title
+}
+
+// assignInvoiceNumber returns the order's invoice number, allocating the next
+// one in the yearly sequence on first use. It runs in the caller's
+// transaction; the counter row lock keeps the numbering gapless. Numbers are
+// issued when an order is fulfilled or on an explicit admin request, never
+// by reading the invoice.
+func assignInvoiceNumber(tx dbQueryer, orderID string, now time.Time) (string, time.Time, error) {
+	var (
+		number   sql.NullString
+		issuedAt sql.NullTime
+	)
+	if err := tx.QueryRow(`
+		SELECT invoice_number, invoice_issued_at FROM orders WHERE id = $1 FOR UPDATE
+	`, orderID).Scan(&number, &issuedAt); err != nil {
+		return "", time.Time{}, err
+	}
+	if number.Valid && issuedAt.Valid {
+		return number.String, issuedAt.Time, nil
+	}
+
+	year := now.Year()
+	var seq int
+	if err := tx.QueryRow(`
+		INSERT INTO invoice_counters (year, last_number) VALUES ($1, 1)
+		ON CONFLICT (year) DO UPDATE SET last_number = invoice_counters.last_number + 1
+		RETURNING last_number
+	`, year).Scan(&seq); err != nil {
+		return "", time.Time{}, err
+	}
+	value := fmt.Sprintf("%d-%06d", year, seq)
+	if _, err := tx.Exec(`
+		UPDATE orders SET invoice_number = $1, invoice_issued_at = $2 WHERE id = $3
+	`, value, now, orderID); err != nil {
+		return "", time.Time{}, err
+	}
+	return value, now, nil
+}
+
+// handleIssueInvoice issues the invoice number of an order before it is
+// fulfilled. Issuing twice returns the same number.
+func (s *Server) handleIssueInvoice(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+	tx, err := s.db.Begin()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot start transaction")
+		return
+	}
+	defer tx.Rollback()
+	number, issuedAt, err := assignInvoiceNumber(tx, id, time.Now().UTC())
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot assign invoice number")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot assign invoice number")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"invoiceNumber": number, "issuedAt": issuedAt.UTC().Format(time.RFC3339)})
+}
+
+func (s *Server) handleOrderInvoice(w http.ResponseWriter, r *http.Request) {
+	order, ok := s.loadAccessibleOrder(w, r)
+	if !ok {
+		return
+	}
+	var (
+		number   sql.NullString
+		issuedAt sql.NullTime
+	)
+	if err := s.db.QueryRow(`SELECT invoice_number, invoice_issued_at FROM orders WHERE id = $1`, order.ID).Scan(&number, &issuedAt); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load invoice number")
+		return
+	}
+	if !number.Valid || !issuedAt.Valid {
+		writeError(w, http.StatusConflict, "invoice not issued")
+		return
+	}
+	var buf bytes.Buffer
+	if err := renderInvoicePDF(&buf, s.cfg.ShopName, order, number.String, issuedAt.Time, deliveryTitle(s.db, order.DeliveryMethod)); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot render invoice")
+		return
+	}
+	writePDF(w, "invoice-"+number.String+".pdf", buf.Bytes())
+}
+
+func (s *Server) handleOrderPackingSlip(w http.ResponseWriter, r *http.Request) {
+	order, ok := s.loadAccessibleOrder(w, r)
+	if !ok {
+		return
+	}
+	var buf bytes.Buffer
+	if err := renderPackingSlipPDF(&buf, s.cfg.ShopName, order, deliveryTitle(s.db, order.DeliveryMethod)); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot render packing slip")
+		return
+	}
+	writePDF(w, "packing-slip-"+order.ID+".pdf", buf.Bytes())
+}
+
+func writePDF(w http.ResponseWriter, filename string, data []byte) {
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `inline; filename="`+filename+`"`)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+const pdfFont = "Go"
+
+func newDocumentPDF() *fpdf.Fpdf {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.AddUTF8FontFromBytes(pdfFont, "", goregular.TTF)
+	pdf.AddUTF8FontFromBytes(pdfFont, "B", gobold.TTF)
+	pdf.SetMargins(15, 15, 15)
+	pdf.SetAutoPageBreak(true, 15)
+	pdf.AliasNbPages("{nb}")
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont(pdfFont, "", 8)
+		pdf.CellFormat(0, 5, fmt.Sprintf("%d / {nb}", pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+	pdf.AddPage()
+	return pdf
+}
+
+// pdfCurrency replaces symbols the embedded font has no glyph for.
+func pdfCurrency(currency string) string {
+	switch currency {
+	case "", "₽":
+		return "руб."
+	default:
+		return currency
+	}
+}
+
+func pdfMoney(v float64, currency string) string {
+	return strconv.FormatFloat(roundMoney(v), 'f', 2, 64) + " " + pdfCurrency(currency)
+}
+
+func pdfItemTitle(item OrderItem) string {
+	title := strings.TrimSpace(item.Brand + " " + item.Name)
+	if title == "" {
+		title = item.ID
+	}
+	return title
+}
+
+func pdfVolume(v float64) string {
+	if v <= 0 {
+		return "—"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64) + " мл"
+}
+
+func pdfDate(rfc3339 string) string {
+	t, err := time.Parse(time.RFC3339, rfc3339)
+	if err != nil {
+		return rfc3339
+	}
+	return t.Format("02.01.2006 15:04")
+}
+
+func pdfHeader(pdf *fpdf.Fpdf, shop, title string) {
+	pdf.SetFont(pdfFont, "B", 16)
+	pdf.CellFormat(0, 8, shop, "", 1, "L", false, 0, "")
+	pdf.SetFont(pdfFont, "B", 13)
+	pdf.CellFormat(0, 8, title, "", 1, "L", false, 0, "")
+	pdf.Ln(2)
+}
+
+func pdfCustomer(pdf *fpdf.Fpdf, order Order, delivery string) {
+	pdf.SetFont(pdfFont, "", 10)
+	rows := [][2]string{
+		{"Заказ", order.ID},
+		{"Дата заказа", pdfDate(order.CreatedAt)},
+		{"Покупатель", order.DisplayName},
+		{"Телефон", order.Phone},
+		{"Email", order.Email},
+		{"Доставка", delivery},
+		{"Адрес", order.DeliveryAddress},
+	}
+	for _, row := range rows {
+		if strings.TrimSpace(row[1]) == "" {
+			continue
+		}
+		pdf.SetFont(pdfFont, "B", 10)
+		pdf.CellFormat(35, 6, row[0]+":", "", 0, "L", false, 0, "")
+		pdf.SetFont(pdfFont, "", 10)
+		pdf.MultiCell(0, 6, row[1], "", "L", false)
+	}
+	pdf.Ln(3)
+}
+
+// pdfTableRow draws one bordered table row. Cells wrap onto as many lines as
+// the longest of them needs, so long perfume titles stay inside their column;
+// a row that does not fit on the page starts the next one.
+func pdfTableRow(pdf *fpdf.Fpdf, widths []float64, aligns, cells []string) {
+	const lineHeight, padding = 5.0, 1.0
+	lines := make([][]string, len(cells))
+	rows := 1
+	for i, c := range cells {
+		lines[i] = pdf.SplitText(c, widths[i])
+		if len(lines[i]) > rows {
+			rows = len(lines[i])
+		}
+	}
+	h := float64(rows)*lineHeight + 2*padding
+	_, pageHeight := pdf.GetPageSize()
+	if _, _, _, bottom := pdf.GetMargins(); pdf.GetY()+h > pageHeight-bottom {
+		pdf.AddPage()
+	}
+	left, _, _, _ := pdf.GetMargins()
+	x, y := left, pdf.GetY()
+	for i, w := range widths {
+		pdf.Rect(x, y, w, h, "D")
+		for k, line := range lines[i] {
+			pdf.SetXY(x, y+padding+float64(k)*lineHeight)
+			pdf.CellFormat(w, lineHeight, line, "", 0, aligns[i], false, 0, "")
+		}
+		x += w
+	}
+	pdf.SetXY(left, y+h)
+}
+
+func renderInvoicePDF(w io.Writer, shop string, order Order, number string, issuedAt time.Time, delivery string) error {
+	pdf := newDocumentPDF()
+	pdfHeader(pdf, shop, "Счёт № "+number+" от "+issuedAt.Format("02.01.2006"))
+	pdfCustomer(pdf, order, delivery)
+
+	widths := []float64{8, 72, 20, 22, 12, 23, 23}
+	headers := []string{"№", "Наименование", "Объём", "Микс", "Кол.", "Цена", "Сумма"}
+	pdf.SetFont(pdfFont, "B", 9)
+	pdf.SetFillColor(235, 235, 235)
+	for i, h := range headers {
+		pdf.CellFormat(widths[i], 7, h, "1", 0, "C", true, 0, "")
+	}
+	pdf.Ln(-1)
+	pdf.SetFont(pdfFont, "", 9)
+	for i, item := range order.Items {
+		cells := []string{
+			strconv.Itoa(i + 1),
+			pdfItemTitle(item),
+			pdfVolume(item.Volume),
+			item.Mix,
+			strconv.Itoa(item.Qty),
+			pdfMoney(item.Price, order.Currency),
+			pdfMoney(item.Price*float64(item.Qty), order.Currency),
+		}
+		pdfTableRow(pdf, widths, []string{"C", "L", "C", "C", "C", "R", "R"}, cells)
+	}
+	pdf.Ln(3)
+
+	totals := [][2]string{{"Сумма товаров", pdfMoney(order.Subtotal, order.Currency)}}
+	if order.Discount > 0 {
+		label := "Скидка"
+		if order.PromoCode != "" {
+			label += " (" + order.PromoCode + ")"
+		}
+		totals = append(totals, [2]string{label, "-" + pdfMoney(order.Discount, order.Currency)})
+	}
+	totals = append(totals, [2]string{"Доставка", pdfMoney(order.DeliveryFee, order.Currency)})
+	for _, t := range totals {
+		pdf.SetFont(pdfFont, "", 10)
+		pdf.CellFormat(140, 6, t[0], "", 0, "R", false, 0, "")
+		pdf.CellFormat(40, 6, t[1], "", 1, "R", false, 0, "")
+	}
+	pdf.SetFont(pdfFont, "B", 11)
+	pdf.CellFormat(140, 8, "Итого к оплате", "", 0, "R", false, 0, "")
+	pdf.CellFormat(40, 8, pdfMoney(order.Total, order.Currency), "", 1, "R", false, 0, "")
+
+	return pdf.Output(w)
+}
+
+func renderPackingSlipPDF(w io.Writer, shop string, order Order, delivery string) error {
+	pdf := newDocumentPDF()
+	pdfHeader(pdf, shop, "Упаковочный лист")
+	pdfCustomer(pdf, order, delivery)
+
+	widths := []float64{10, 100, 25, 30, 15}
+	headers := []string{"№", "Наименование", "Объём", "Микс", "Кол."}
+	pdf.SetFont(pdfFont, "B", 9)
+	pdf.SetFillColor(235, 235, 235)
+	for i, h := range headers {
+		pdf.CellFormat(widths[i], 7, h, "1", 0, "C", true, 0, "")
+	}
+	pdf.Ln(-1)
+	pdf.SetFont(pdfFont, "", 9)
+	var count int
+	for i, item := range order.Items {
+		cells := []string{strconv.Itoa(i + 1), pdfItemTitle(item), pdfVolume(item.Volume), item.Mix, strconv.Itoa(item.Qty)}
+		pdfTableRow(pdf, widths, []string{"C", "L", "C", "C", "C"}, cells)
+		count += item.Qty
+	}
+	pdf.Ln(3)
+	pdf.SetFont(pdfFont, "B", 10)
+	pdf.CellFormat(0, 6, "Всего единиц: "+strconv.Itoa(count), "", 1, "R", false, 0, "")
+	pdf.Ln(10)
+	pdf.SetFont(pdfFont, "", 10)
+	pdf.CellFormat(0, 6, "Собрал: ____________________    Проверил: ____________________", "", 1, "L", false, 0, "")
+
+	return pdf.Output(w)
+}
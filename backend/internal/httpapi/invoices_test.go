@@ -0,0 +1,123 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"parfum-backend/internal/app"
+)
+
+func TestRenderInvoicePDF(t *testing.T) {
+	order := Order{
+		ID:          "order1",
+		DisplayName: "Анна",
+		Phone:       "+79990001122",
+		Items: []OrderItem{
+			{ID: "p1", Brand: "Chanel", Name: "Шанс", Volume: 30, Mix: "классика", Qty: 2, Price: 1500},
+		},
+		Subtotal:       3000,
+		Discount:       300,
+		PromoCode:      "SPRING",
+		DeliveryFee:    350,
+		Total:          3050,
+		Currency:       "₽",
+		DeliveryMethod: "courier",
+		CreatedAt:      "2024-05-10T12:00:00Z",
+	}
+	var buf bytes.Buffer
+	if err := renderInvoicePDF(&buf, "Memory", order, "2024-000001", time.Date(2024, 5, 11, 0, 0, 0, 0, time.UTC), "Курьер"); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("%PDF-")) {
+		t.Fatalf("output is not a PDF: %q", buf.Bytes()[:16])
+	}
+	buf.Reset()
+	if err := renderPackingSlipPDF(&buf, "Memory", order, "Курьер"); err != nil {
+		t.Fatalf("render packing slip: %v", err)
+	}
+}
+
+func TestPDFTableRowWrapsLongTitle(t *testing.T) {
+	title := "Maison Francis Kurkdjian Baccarat Rouge 540 Extrait de Parfum — limited edition с длинным описанием и без сокращений"
+	widths := []float64{10, 100, 25, 30, 15}
+	aligns := []string{"C", "L", "C", "C", "C"}
+
+	pdf := newDocumentPDF()
+	pdf.SetFont(pdfFont, "", 9)
+	y := pdf.GetY()
+	pdfTableRow(pdf, widths, aligns, []string{"1", "Chanel Шанс", "30 мл", "", "1"})
+	if h := pdf.GetY() - y; h != 7 {
+		t.Fatalf("expected a single-line row of 7 mm, got %v", h)
+	}
+	y = pdf.GetY()
+	pdfTableRow(pdf, widths, aligns, []string{"2", title, "50 мл", "60/40", "1"})
+	if h := pdf.GetY() - y; h <= 7 {
+		t.Fatalf("expected the long title to wrap, row height %v", h)
+	}
+	for _, line := range pdf.SplitText(title, widths[1]) {
+		if w := pdf.GetStringWidth(line); w > widths[1] {
+			t.Fatalf("line %q is %v mm wide, column is %v mm", line, w, widths[1])
+		}
+	}
+
+	order := Order{
+		ID:       "order1",
+		Items:    []OrderItem{{ID: "p1", Brand: "Maison Francis Kurkdjian", Name: title, Volume: 50, Qty: 1, Price: 1500}},
+		Currency: "₽",
+	}
+	for i := 0; i < 40; i++ {
+		order.Items = append(order.Items, order.Items[0])
+	}
+	var buf bytes.Buffer
+	if err := renderInvoicePDF(&buf, "Memory", order, "2024-000001", time.Date(2024, 5, 11, 0, 0, 0, 0, time.UTC), "Курьер"); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	buf.Reset()
+	if err := renderPackingSlipPDF(&buf, "Memory", order, "Курьер"); err != nil {
+		t.Fatalf("render packing slip: %v", err)
+	}
+}
+
+func TestHandleIssueInvoiceAllocatesNumber(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT invoice_number, invoice_issued_at FROM orders WHERE id = \\$1 FOR UPDATE").
+		WithArgs("order1").
+		WillReturnRows(sqlmock.NewRows([]string{"invoice_number", "invoice_issued_at"}).AddRow(nil, nil))
+	mock.ExpectQuery("INSERT INTO invoice_counters").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"last_number"}).AddRow(7))
+	mock.ExpectExec("UPDATE orders SET invoice_number = \\$1").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "order1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	req := httptest.NewRequest(http.MethodPost, "/api/orders/order1/invoice", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "order1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	rec := httptest.NewRecorder()
+	s.handleIssueInvoice(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !bytes.Contains(rec.Body.Bytes(), []byte("-000007")) {
+		t.Fatalf("unexpected body: %s", rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
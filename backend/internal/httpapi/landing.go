@@ -0,0 +1,131 @@
+package httpapi
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// landingSQL selects the Landing fields of the entries of kind.
+func landingSQL(kind referenceKind) string {
+	return `
+		SELECT r.slug, r.name, r.name_ru, r.name_en, r.title, r.description,
+		       COALESCE(NULLIF(r.hero_image, ''), c.image, ''), c.retail, c.wholesale
+		FROM ` + kind.table + ` r
+		CROSS JOIN LATERAL (
+			SELECT COUNT(*) FILTER (WHERE catalog_mode = 'retail') AS retail,
+			       COUNT(*) FILTER (WHERE catalog_mode = 'wholesale') AS wholesale,
+			       (array_agg(image_url ORDER BY popularity DESC, id) FILTER (WHERE image_url <> ''))[1] AS image
+			FROM perfumes
+			WHERE ` + kind.usesSQL("r.name") + `
+		) c
+	`
+}
+
+func scanLanding(row interface{ Scan(...interface{}) error }) (Landing, error) {
+	var l Landing
+	err := row.Scan(&l.Slug, &l.Name, &l.NameRu, &l.NameEn, &l.Title, &l.Description, &l.HeroImage, &l.Retail, &l.Wholesale)
+	return l, err
+}
+
+// handleListBrands lists the brands that have perfumes, for the brand index
+// page.
+func (s *Server) handleListBrands(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.Query(landingSQL(referenceKinds["brands"]) + `
+		WHERE c.retail + c.wholesale > 0
+		ORDER BY reference_key(r.name)
+	`)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load brands")
+		return
+	}
+	defer rows.Close()
+	list := []Landing{}
+	for rows.Next() {
+		l, err := scanLanding(rows)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot parse brands")
+			return
+		}
+		list = append(list, l)
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleBrandLanding returns the brand page with a page of its perfumes. The
+// perfumes take the same parameters as GET /api/perfumes.
+func (s *Server) handleBrandLanding(w http.ResponseWriter, r *http.Request) {
+	s.writeLanding(w, r, referenceKinds["brands"], func(query url.Values, name string) string {
+		query.Set("brands", name)
+		return ""
+	})
+}
+
+// handleNoteLanding returns the note page with a page of perfumes having the
+// note. The note is a hard condition rather than a mustNote, which the match
+// sort only uses for ranking.
+func (s *Server) handleNoteLanding(w http.ResponseWriter, r *http.Request) {
+	s.writeLanding(w, r, referenceKinds["notes"], func(query url.Values, name string) string {
+		return name
+	})
+}
+
+// writeLanding writes the landing page of a dictionary entry. filter adjusts
+// the perfume query for the entry and returns the note to require, if any.
+func (s *Server) writeLanding(w http.ResponseWriter, r *http.Request, kind referenceKind, filter func(url.Values, string) string) {
+	slug := strings.TrimSpace(chi.URLParam(r, "slug"))
+	if slug == "" {
+		writeError(w, http.StatusBadRequest, "missing slug")
+		return
+	}
+	page, err := scanLanding(s.db.QueryRow(landingSQL(kind)+` WHERE r.slug = $1`, slug))
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load "+kind.label)
+		return
+	}
+	query := r.URL.Query()
+	note := filter(query, page.Name)
+	s.writePerfumeList(w, r, query, note, map[string]interface{}{kind.label: page})
+}
+
+type landingPayload struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	HeroImage   string `json:"heroImage"`
+}
+
+// handleUpdateLanding sets the landing page text of a dictionary entry.
+func (s *Server) handleUpdateLanding(w http.ResponseWriter, r *http.Request) {
+	kind, ok := referenceFromURL(w, r)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(r, "id")
+	var body landingPayload
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	res, err := s.db.Exec(`
+		UPDATE `+kind.table+` SET title=$1, description=$2, hero_image=$3, updated_at=now()
+		WHERE id=$4
+	`, strings.TrimSpace(body.Title), strings.TrimSpace(body.Description), strings.TrimSpace(body.HeroImage), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot save "+kind.label)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"id": id})
+}
@@ -0,0 +1,115 @@
+package httpapi
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"parfum-backend/internal/app"
+)
+
+func landingRequest(target, slug string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("slug", slug)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestHandleNoteLandingFiltersPerfumes(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passthroughConverter{}))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("(?s)FROM notes r\\s+CROSS JOIN LATERAL.*WHERE r.name = ANY\\(notes_top \\|\\| notes_heart \\|\\| notes_base\\).*WHERE r.slug = \\$1").
+		WithArgs("bergamot").
+		WillReturnRows(sqlmock.NewRows([]string{"slug", "name", "name_ru", "name_en", "title", "description", "hero", "retail", "wholesale"}).
+			AddRow("bergamot", "Бергамот", "Бергамот", "Bergamot", "Ароматы с бергамотом", "Цитрусовая нота.", "/uploads/b.jpg", 12, 3))
+	notes := pgtype.FlatArray[string]{"Роза"}
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM perfumes WHERE catalog_mode = \\$1 AND \\$2 = ANY\\(notes_top \\|\\| notes_heart \\|\\| notes_base\\) AND \\(notes_top \\|\\| notes_heart \\|\\| notes_base\\) @> \\$3").
+		WithArgs("retail", "Бергамот", notes).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery("(?s)SELECT id, catalog_mode.*LIMIT \\$4 OFFSET \\$5").
+		WithArgs("retail", "Бергамот", notes, 13, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	rec := httptest.NewRecorder()
+	s.handleNoteLanding(rec, landingRequest("/api/notes/bergamot?mustNotes=Роза", "bergamot"))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Note  Landing `json:"note"`
+		Total int     `json:"total"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Note.Name != "Бергамот" || resp.Note.Retail != 12 || resp.Note.HeroImage != "/uploads/b.jpg" {
+		t.Fatalf("unexpected note: %+v", resp.Note)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
+
+func TestHandleNoteLandingKeepsNoteUnderMatchSort(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passthroughConverter{}))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("(?s)FROM notes r.*WHERE r.slug = \\$1").
+		WithArgs("rose").
+		WillReturnRows(sqlmock.NewRows([]string{"slug", "name", "name_ru", "name_en", "title", "description", "hero", "retail", "wholesale"}).
+			AddRow("rose", "Роза", "Роза", "Rose", "", "", "", 4, 0))
+	// A season hit alone must not let perfumes without the note in.
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM perfumes WHERE catalog_mode = \\$1 AND \\$2 = ANY\\(notes_top \\|\\| notes_heart \\|\\| notes_base\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery("(?s)SELECT id, catalog_mode.*WHERE catalog_mode = \\$1 AND \\$2 = ANY\\(notes_top \\|\\| notes_heart \\|\\| notes_base\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	rec := httptest.NewRecorder()
+	s.handleNoteLanding(rec, landingRequest("/api/notes/rose?sort=match&seasons=Лето", "rose"))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
+
+func TestHandleBrandLandingNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("(?s)FROM brands r.*WHERE r.slug = \\$1").
+		WithArgs("nope").
+		WillReturnError(sql.ErrNoRows)
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	rec := httptest.NewRecorder()
+	s.handleBrandLanding(rec, landingRequest("/api/brands/nope", "nope"))
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
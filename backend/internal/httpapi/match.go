@@ -0,0 +1,116 @@
+package httpapi
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Weights of the match score, the same as scorePerfume in
+// src/lib/scoring.jsx so that server and client rank alike.
+const (
+	matchMustNoteWeight = 4
+	matchSeasonWeight   = 1.8
+	matchDayNightWeight = 1.4
+	matchCoverageWeight = 2
+	matchAvoidPenalty   = 2.8
+	matchScoreLimit     = 999
+)
+
+// matchPreferences are the notes, seasons and times of day a shopper asked
+// for in the catalog filters.
+type matchPreferences struct {
+	mustNotes  []string
+	avoidNotes []string
+	seasons    []string
+	dayNight   []string
+}
+
+func (m matchPreferences) empty() bool {
+	return len(m.mustNotes) == 0 && len(m.avoidNotes) == 0 && len(m.seasons) == 0 && len(m.dayNight) == 0
+}
+
+type matchResult struct {
+	score        float64
+	matchedNotes []string
+	avoidedNotes []string
+}
+
+func countIn(values []string, set map[string]bool) int {
+	n := 0
+	for _, v := range values {
+		if set[v] {
+			n++
+		}
+	}
+	return n
+}
+
+func stringSet(lists ...[]string) map[string]bool {
+	set := map[string]bool{}
+	for _, list := range lists {
+		for _, v := range list {
+			set[v] = true
+		}
+	}
+	return set
+}
+
+// scorePerfume ports scorePerfume from src/lib/scoring.jsx: must notes,
+// seasons and dayNight add to the score, avoided notes take from it.
+func scorePerfume(p perfumePayload, prefs matchPreferences) matchResult {
+	notes := stringSet(p.Notes.Top, p.Notes.Heart, p.Notes.Base)
+	res := matchResult{matchedNotes: []string{}, avoidedNotes: []string{}}
+	for _, n := range prefs.mustNotes {
+		if notes[n] {
+			res.matchedNotes = append(res.matchedNotes, n)
+		}
+	}
+	for _, n := range prefs.avoidNotes {
+		if notes[n] {
+			res.avoidedNotes = append(res.avoidedNotes, n)
+		}
+	}
+	mustHits := float64(len(res.matchedNotes))
+	seasonHits := float64(countIn(prefs.seasons, stringSet(p.Seasons)))
+	dayNightHits := float64(countIn(prefs.dayNight, stringSet(p.DayNight)))
+	coverage := 0.0
+	if len(prefs.mustNotes) > 0 {
+		coverage = mustHits / float64(len(prefs.mustNotes))
+	}
+	score := mustHits*matchMustNoteWeight + seasonHits*matchSeasonWeight + dayNightHits*matchDayNightWeight +
+		coverage*matchCoverageWeight - float64(len(res.avoidedNotes))*matchAvoidPenalty
+	res.score = math.Max(-matchScoreLimit, math.Min(matchScoreLimit, score))
+	return res
+}
+
+func sqlFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// scoreSQL is scorePerfume as a SQL expression over a perfumes row, used to
+// sort and page by match. Its arguments are appended to args.
+func (m matchPreferences) scoreSQL(args *[]interface{}) string {
+	hits := func(values []string, column string) string {
+		*args = append(*args, pgtype.FlatArray[string](values))
+		return "(SELECT COUNT(*) FROM unnest($" + itoa(len(*args)) + "::text[]) v WHERE v = ANY(" + column + "))"
+	}
+	const allNotes = "notes_top || notes_heart || notes_base"
+	expr := "0"
+	if len(m.mustNotes) > 0 {
+		weight := matchMustNoteWeight + matchCoverageWeight/float64(len(m.mustNotes))
+		expr += " + " + sqlFloat(weight) + " * " + hits(m.mustNotes, allNotes)
+	}
+	if len(m.seasons) > 0 {
+		expr += " + " + sqlFloat(matchSeasonWeight) + " * " + hits(m.seasons, "seasons")
+	}
+	if len(m.dayNight) > 0 {
+		expr += " + " + sqlFloat(matchDayNightWeight) + " * " + hits(m.dayNight, "day_night")
+	}
+	if len(m.avoidNotes) > 0 {
+		expr += " - " + sqlFloat(matchAvoidPenalty) + " * " + hits(m.avoidNotes, allNotes)
+	}
+	limit := itoa(matchScoreLimit)
+	return "LEAST(GREATEST((" + expr + ")::float8, -" + limit + "), " + limit + ")"
+}
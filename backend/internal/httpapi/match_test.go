@@ -0,0 +1,68 @@
+package httpapi
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgtype"
+	"parfum-backend/internal/app"
+)
+
+func TestScorePerfumeMatchesFrontend(t *testing.T) {
+	var p perfumePayload
+	p.Notes.Top = []string{"Бергамот", "Лимон"}
+	p.Notes.Heart = []string{"Роза"}
+	p.Notes.Base = []string{"Мускус", "Уд"}
+	p.Seasons = []string{"Весна", "Лето"}
+	p.DayNight = []string{"День"}
+
+	prefs := matchPreferences{
+		mustNotes:  []string{"Роза", "Ваниль"},
+		avoidNotes: []string{"Уд"},
+		seasons:    []string{"Лето", "Зима"},
+		dayNight:   []string{"День", "Ночь"},
+	}
+	// 1 must hit * 4 + 1 season * 1.8 + 1 dayNight * 1.4 + 0.5 coverage * 2 - 1 avoid * 2.8
+	got := scorePerfume(p, prefs)
+	if math.Abs(got.score-5.4) > 1e-9 {
+		t.Fatalf("score = %v, want 5.4", got.score)
+	}
+	if !reflect.DeepEqual(got.matchedNotes, []string{"Роза"}) || !reflect.DeepEqual(got.avoidedNotes, []string{"Уд"}) {
+		t.Fatalf("notes = %v / %v", got.matchedNotes, got.avoidedNotes)
+	}
+}
+
+func TestHandleListPerfumesMatchSortRanksInSQL(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passthroughConverter{}))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	must := pgtype.FlatArray[string]{"Роза", "Ваниль"}
+	seasons := pgtype.FlatArray[string]{"Лето"}
+	score := "LEAST\\(GREATEST\\(\\(0 \\+ 5 \\* \\(SELECT COUNT\\(\\*\\) FROM unnest\\(\\$2::text\\[\\]\\) v WHERE v = ANY\\(notes_top \\|\\| notes_heart \\|\\| notes_base\\)\\)" +
+		" \\+ 1.8 \\* \\(SELECT COUNT\\(\\*\\) FROM unnest\\(\\$3::text\\[\\]\\) v WHERE v = ANY\\(seasons\\)\\)\\)::float8, -999\\), 999\\)"
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM perfumes WHERE catalog_mode = \\$1 AND "+score+" >= 1").
+		WithArgs("retail", must, seasons).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery("(?s)ORDER BY "+score+" DESC, COALESCE\\(updated_at, '-infinity'\\) DESC, id ASC LIMIT \\$4 OFFSET \\$5").
+		WithArgs("retail", must, seasons, 13, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	req := httptest.NewRequest(http.MethodGet, "/api/perfumes?sort=match&mustNotes=Роза,Ваниль&seasons=Лето", nil)
+	rec := httptest.NewRecorder()
+	s.handleListPerfumes(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
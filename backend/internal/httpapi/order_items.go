@@ -0,0 +1,62 @@
+package httpapi
+
+// orderItemsJSON rebuilds the items array of an order from order_items in
+// the shape the API has always returned. It expects the outer query to read
+// from orders without an alias.
+const orderItemsJSON = `COALESCE((
+		SELECT json_agg(json_build_object(
+			'id', oi.perfume_id, 'variantId', oi.variant_id, 'sku', oi.sku, 'brand', oi.brand, 'name', oi.name,
+			'volume', oi.volume, 'mix', oi.mix, 'qty', oi.qty, 'price', oi.unit_price
+		) ORDER BY oi.position)
+		FROM order_items oi WHERE oi.order_id = orders.id
+	), '[]')`
+
+func insertOrderItems(q dbQueryer, orderID string, items []OrderItem) error {
+	for i, item := range items {
+		if _, err := q.Exec(`
+			INSERT INTO order_items (order_id, position, perfume_id, brand, name, volume, mix, qty, unit_price, catalog_mode, variant_id, sku)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9,
+				COALESCE((SELECT catalog_mode FROM perfumes WHERE id = $3), 'retail'), $10, $11)
+		`, orderID, i, item.ID, item.Brand, item.Name, item.Volume, item.Mix, item.Qty, item.Price,
+			nullIfEmpty(item.VariantID), item.SKU); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// incrementOrderCounts adds the quantities of an order to perfumes.order_count.
+func incrementOrderCounts(q dbQueryer, orderID string) error {
+	_, err := q.Exec(`
+		UPDATE perfumes p
+		SET order_count = COALESCE(p.order_count, 0) + s.qty
+		FROM (
+			SELECT perfume_id, SUM(qty) AS qty FROM order_items WHERE order_id = $1 GROUP BY perfume_id
+		) s
+		WHERE p.id = s.perfume_id
+	`, orderID)
+	return err
+}
+
+// decrementOrderStock takes the quantities of a fulfilled order off tracked
+// variant stock and refreshes the stock summary of the perfumes, which
+// marks them out of stock when they run out.
+func decrementOrderStock(q dbQueryer, orderID string) error {
+	if _, err := q.Exec(`
+		UPDATE perfume_variants v
+		SET stock_qty = GREATEST(v.stock_qty - s.qty, 0), updated_at = now()
+		FROM (
+			SELECT variant_id, SUM(qty) AS qty FROM order_items
+			WHERE order_id = $1 AND variant_id IS NOT NULL
+			GROUP BY variant_id
+		) s
+		WHERE v.id = s.variant_id AND v.stock_qty IS NOT NULL
+	`, orderID); err != nil {
+		return err
+	}
+	_, err := q.Exec(`
+		SELECT sync_perfume_from_variants(perfume_id)
+		FROM (SELECT DISTINCT perfume_id FROM order_items WHERE order_id = $1) t
+	`, orderID)
+	return err
+}
@@ -0,0 +1,183 @@
+package httpapi
+
+import (
+	"encoding/csv"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var orderExportHeader = []string{
+	"Заказ", "Дата", "Канал", "Выполнен", "Покупатель", "Телефон", "Email",
+	"Доставка", "Адрес", "Промокод",
+	"Артикул", "Бренд", "Название", "Объём", "Вариант", "Кол-во", "Цена", "Сумма строки",
+	"Валюта", "Подытог заказа", "Скидка заказа", "Стоимость доставки", "Итого заказа",
+}
+
+type orderExportRow struct {
+	orderID     string
+	createdAt   time.Time
+	channel     string
+	fulfilled   bool
+	customer    string
+	phone       string
+	email       string
+	delivery    string
+	address     string
+	promoCode   string
+	perfumeID   string
+	brand       string
+	name        string
+	volume      float64
+	mix         string
+	qty         int
+	price       float64
+	currency    string
+	subtotal    float64
+	discount    float64
+	deliveryFee float64
+	total       float64
+}
+
+func (row orderExportRow) cells() []interface{} {
+	return []interface{}{
+		row.orderID, row.createdAt.Format("2006-01-02 15:04:05"), row.channel, row.fulfilled,
+		row.customer, row.phone, row.email, row.delivery, row.address, row.promoCode,
+		row.perfumeID, row.brand, row.name, row.volume, row.mix, row.qty, row.price,
+		roundMoney(row.price * float64(row.qty)),
+		row.currency, row.subtotal, row.discount, row.deliveryFee, row.total,
+	}
+}
+
+// csvSafe keeps spreadsheets from running customer text as a formula by
+// prefixing cells that start with a formula character with a quote.
+func csvSafe(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
+
+func (row orderExportRow) csvRecord() []string {
+	cells := row.cells()
+	out := make([]string, len(cells))
+	for i, c := range cells {
+		switch v := c.(type) {
+		case string:
+			out[i] = csvSafe(v)
+		case int:
+			out[i] = strconv.Itoa(v)
+		case float64:
+			out[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			if v {
+				out[i] = "да"
+			} else {
+				out[i] = "нет"
+			}
+		}
+	}
+	return out
+}
+
+// handleExportOrders streams one row per order item as CSV or XLSX. Rows are
+// written while the query is being read, so the export never holds the full
+// result in memory.
+func (s *Server) handleExportOrders(w http.ResponseWriter, r *http.Request) {
+	format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format")))
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "xlsx" {
+		writeError(w, http.StatusBadRequest, "format must be csv or xlsx")
+		return
+	}
+	where, args, err := parseOrderFilters(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rows, err := s.db.Query(`
+		SELECT o.id, o.created_at, o.channel, o.fulfilled,
+			o.display_name, o.phone, o.email, o.delivery_method, o.delivery_address, o.promo_code,
+			oi.perfume_id, oi.brand, oi.name, oi.volume, oi.mix, oi.qty, oi.unit_price,
+			o.currency, o.subtotal, o.discount_total, o.delivery_fee, o.total
+		FROM (SELECT * FROM orders `+whereClause(where)+`) o
+		JOIN order_items oi ON oi.order_id = o.id
+		ORDER BY o.created_at, o.id, oi.position
+	`, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load orders")
+		return
+	}
+	defer rows.Close()
+
+	// Large exports outlive the server-wide write timeout.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	filename := "orders-" + time.Now().Format("20060102-150405") + "." + format
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	var (
+		writeRow func(orderExportRow) error
+		finish   func() error
+	)
+	if format == "xlsx" {
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.WriteHeader(http.StatusOK)
+		xw, err := newXLSXWriter(w, "Заказы")
+		if err == nil {
+			err = xw.WriteHeader(orderExportHeader)
+		}
+		if err != nil {
+			log.Printf("export orders: %v", err)
+			return
+		}
+		writeRow = func(row orderExportRow) error { return xw.WriteRow(row.cells()) }
+		finish = xw.Close
+	} else {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		// BOM so that Excel detects UTF-8.
+		_, _ = w.Write([]byte("\xEF\xBB\xBF"))
+		cw := csv.NewWriter(w)
+		if err := cw.Write(orderExportHeader); err != nil {
+			log.Printf("export orders: %v", err)
+			return
+		}
+		writeRow = func(row orderExportRow) error { return cw.Write(row.csvRecord()) }
+		finish = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	}
+
+	for rows.Next() {
+		var row orderExportRow
+		if err := rows.Scan(
+			&row.orderID, &row.createdAt, &row.channel, &row.fulfilled,
+			&row.customer, &row.phone, &row.email,
+			&row.delivery, &row.address, &row.promoCode,
+			&row.perfumeID, &row.brand, &row.name,
+			&row.volume, &row.mix, &row.qty, &row.price,
+			&row.currency, &row.subtotal, &row.discount, &row.deliveryFee, &row.total,
+		); err != nil {
+			log.Printf("export orders: %v", err)
+			return
+		}
+		if err := writeRow(row); err != nil {
+			log.Printf("export orders: %v", err)
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("export orders: %v", err)
+		return
+	}
+	if err := finish(); err != nil {
+		log.Printf("export orders: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package httpapi
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"parfum-backend/internal/app"
+)
+
+func TestHandleExportOrdersXLSX(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	created := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	mock.ExpectQuery("(?s)JOIN order_items oi ON oi.order_id = o.id").
+		WithArgs("web").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "created_at", "channel", "fulfilled", "display_name", "phone", "email",
+			"delivery_method", "delivery_address", "promo_code",
+			"item_id", "brand", "name", "volume", "mix", "qty", "price",
+			"currency", "subtotal", "discount_total", "delivery_fee", "total",
+		}).AddRow(
+			"o1", created, "web", true, "Анна <VIP>", "+79990001122", "", "pickup", "", "",
+			"p1", "Chanel", "Шанс", 30.0, "классика", 2, 1500.0,
+			"₽", 3000.0, 0.0, 0.0, 3000.0,
+		))
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	req := httptest.NewRequest(http.MethodGet, "/api/orders/export?format=xlsx&channel=web", nil)
+	rec := httptest.NewRecorder()
+	s.handleExportOrders(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("not a zip: %v", err)
+	}
+	var sheet string
+	for _, f := range zr.File {
+		if f.Name != "xl/worksheets/sheet1.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("open sheet: %v", err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		sheet = string(data)
+	}
+	for _, want := range []string{"Chanel", "Анна &lt;VIP&gt;", "<v>3000</v>"} {
+		if !strings.Contains(sheet, want) {
+			t.Fatalf("sheet missing %q: %s", want, sheet)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
+
+func TestCSVRecordNeutralisesFormulas(t *testing.T) {
+	row := orderExportRow{customer: "=HYPERLINK(\"http://x\")", phone: "+79990001122", email: "a@b.c", address: "@SUM(A1)", name: "Chance", qty: 1, price: -1}
+	rec := row.csvRecord()
+	if rec[4] != "'=HYPERLINK(\"http://x\")" || rec[5] != "'+79990001122" || rec[6] != "a@b.c" || rec[8] != "'@SUM(A1)" || rec[12] != "Chance" {
+		t.Fatalf("unexpected record: %q", rec)
+	}
+	if rec[16] != "-1" {
+		t.Fatalf("numbers must stay numeric, got %q", rec[16])
+	}
+}
@@ -0,0 +1,133 @@
+package httpapi
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseOrderFilters builds the WHERE conditions shared by the order list and
+// the export. A date-only "to" is inclusive of that whole day; "phone" matches on
+// digits only, so "1600" finds "+7 (999) 123-16-00".
+func parseOrderFilters(q url.Values) ([]string, []interface{}, error) {
+	where := []string{}
+	args := []interface{}{}
+	channel := strings.TrimSpace(q.Get("channel"))
+	if channel != "" && channel != "all" {
+		args = append(args, channel)
+		where = append(where, "channel = $"+itoa(len(args)))
+	}
+	fulfilledParam := strings.TrimSpace(q.Get("fulfilled"))
+	if fulfilledParam == "true" || fulfilledParam == "false" {
+		args = append(args, fulfilledParam == "true")
+		where = append(where, "fulfilled = $"+itoa(len(args)))
+	}
+	anonymousParam := strings.TrimSpace(q.Get("anonymous"))
+	if anonymousParam == "true" || anonymousParam == "false" {
+		args = append(args, anonymousParam == "true")
+		where = append(where, "is_anonymous = $"+itoa(len(args)))
+	}
+	if delivery := strings.TrimSpace(q.Get("delivery")); delivery != "" && delivery != "all" {
+		args = append(args, delivery)
+		where = append(where, "delivery_method = $"+itoa(len(args)))
+	}
+	if raw := strings.TrimSpace(q.Get("from")); raw != "" {
+		from, _, err := parseOrderDate(raw)
+		if err != nil {
+			return nil, nil, errors.New("invalid from date")
+		}
+		args = append(args, from)
+		where = append(where, "created_at >= $"+itoa(len(args)))
+	}
+	if raw := strings.TrimSpace(q.Get("to")); raw != "" {
+		to, dateOnly, err := parseOrderDate(raw)
+		if err != nil {
+			return nil, nil, errors.New("invalid to date")
+		}
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+			args = append(args, to)
+			where = append(where, "created_at < $"+itoa(len(args)))
+		} else {
+			args = append(args, to)
+			where = append(where, "created_at <= $"+itoa(len(args)))
+		}
+	}
+	if raw := strings.TrimSpace(q.Get("minTotal")); raw != "" {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, nil, errors.New("invalid minTotal")
+		}
+		args = append(args, v)
+		where = append(where, "total >= $"+itoa(len(args)))
+	}
+	if raw := strings.TrimSpace(q.Get("maxTotal")); raw != "" {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, nil, errors.New("invalid maxTotal")
+		}
+		args = append(args, v)
+		where = append(where, "total <= $"+itoa(len(args)))
+	}
+	if search := strings.TrimSpace(q.Get("q")); search != "" {
+		args = append(args, "%"+search+"%")
+		idx := itoa(len(args))
+		where = append(where, "(display_name ILIKE $"+idx+" OR email ILIKE $"+idx+" OR phone ILIKE $"+idx+" OR id::text ILIKE $"+idx+")")
+	}
+	if name := strings.TrimSpace(q.Get("name")); name != "" {
+		args = append(args, "%"+name+"%")
+		where = append(where, "display_name ILIKE $"+itoa(len(args)))
+	}
+	if email := strings.TrimSpace(q.Get("email")); email != "" {
+		args = append(args, "%"+email+"%")
+		where = append(where, "email ILIKE $"+itoa(len(args)))
+	}
+	if phone := digitsOnly(q.Get("phone")); phone != "" {
+		args = append(args, "%"+phone+"%")
+		where = append(where, `regexp_replace(phone, '\D', '', 'g') LIKE $`+itoa(len(args)))
+	}
+	if perfumeID := strings.TrimSpace(q.Get("perfumeId")); perfumeID != "" {
+		args = append(args, perfumeID)
+		where = append(where, "EXISTS (SELECT 1 FROM order_items oi WHERE oi.order_id = orders.id AND oi.perfume_id = $"+itoa(len(args))+")")
+	}
+	return where, args, nil
+}
+
+func parseOrderDate(raw string) (time.Time, bool, error) {
+	if t, err := time.Parse("2006-01-02", raw); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	return t, false, err
+}
+
+func digitsOnly(raw string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, raw)
+}
+
+// orderSorts are the sort orders of the order list.
+var orderSorts = map[string]keysetOrder{
+	"created_desc": {name: "created_desc", idCast: "uuid", idDesc: true, keys: []keysetKey{{"created_at", "timestamptz", true}}},
+	"created_asc":  {name: "created_asc", idCast: "uuid", keys: []keysetKey{{"created_at", "timestamptz", false}}},
+	"total_desc":   {name: "total_desc", idCast: "uuid", idDesc: true, keys: []keysetKey{{"total", "numeric", true}}},
+	"total_asc":    {name: "total_asc", idCast: "uuid", keys: []keysetKey{{"total", "numeric", false}}},
+}
+
+func parseOrderSort(raw string) (keysetOrder, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		raw = "created_desc"
+	}
+	sort, ok := orderSorts[raw]
+	if !ok {
+		return keysetOrder{}, errors.New("invalid sort")
+	}
+	return sort, nil
+}
@@ -0,0 +1,97 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"parfum-backend/internal/app"
+)
+
+func TestParseOrderFilters(t *testing.T) {
+	q := url.Values{}
+	q.Set("channel", "web")
+	q.Set("fulfilled", "false")
+	q.Set("from", "2024-01-01")
+	q.Set("to", "2024-01-31")
+	q.Set("phone", "16-00")
+	q.Set("perfumeId", "p1")
+	where, args, err := parseOrderFilters(q)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := "WHERE channel = $1 AND fulfilled = $2 AND created_at >= $3 AND created_at < $4" +
+		` AND regexp_replace(phone, '\D', '', 'g') LIKE $5` +
+		" AND EXISTS (SELECT 1 FROM order_items oi WHERE oi.order_id = orders.id AND oi.perfume_id = $6)"
+	if got := whereClause(where); got != want {
+		t.Fatalf("where = %q, want %q", got, want)
+	}
+	if to := args[3].(time.Time); !to.Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("date-only to should include the whole day, got %v", to)
+	}
+	if args[4] != "%1600%" || args[5] != "p1" {
+		t.Fatalf("unexpected args: %v", args)
+	}
+	q.Set("from", "yesterday")
+	if _, _, err := parseOrderFilters(q); err == nil {
+		t.Fatalf("expected error for invalid date")
+	}
+}
+
+func TestHandleListOrdersCursor(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	cursor := encodePageCursor(pageCursor{Sort: "total_desc", Values: []string{"3000"}, ID: "00000000-0000-0000-0000-000000000003"})
+	columns := []string{"id", "user_id", "is_anonymous", "email", "display_name", "phone", "items", "total", "currency",
+		"channel", "delivery_method", "delivery_address", "fulfilled", "created_at",
+		"subtotal", "discount_total", "promo_code", "discounts", "delivery_fee", "cursor"}
+	created := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	rows := sqlmock.NewRows(columns)
+	for _, id := range []string{"00000000-0000-0000-0000-000000000002", "00000000-0000-0000-0000-000000000001"} {
+		rows.AddRow(id, nil, true, "", "Анна", "+79990001600", []byte(`[]`), 2000.0, "₽",
+			"web", "pickup", "", false, created, 2000.0, 0.0, "", []byte(`[]`), 0.0, []byte(`["2000"]`))
+	}
+
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM orders WHERE channel = \\$1").
+		WithArgs("web").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+	after := "\\(\\(total < \\$3::numeric\\) OR \\(total = \\$3::numeric AND id < \\$2::uuid\\)\\)"
+	mock.ExpectQuery("(?s)WHERE channel = \\$1 AND "+after+"\\s+ORDER BY total DESC, id DESC LIMIT \\$4 OFFSET \\$5").
+		WithArgs("web", "00000000-0000-0000-0000-000000000003", "3000", 2, 0).
+		WillReturnRows(rows)
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	req := httptest.NewRequest(http.MethodGet, "/api/orders?channel=web&sort=total_desc&pageSize=1&total=true&cursor="+cursor, nil)
+	rec := httptest.NewRecorder()
+	s.handleListOrders(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Items      []Order `json:"items"`
+		Total      int     `json:"total"`
+		NextCursor string  `json:"nextCursor"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp.Items) != 1 || resp.Items[0].ID != "00000000-0000-0000-0000-000000000002" || resp.Total != 5 {
+		t.Fatalf("unexpected page: %+v", resp)
+	}
+	next, err := decodePageCursor(resp.NextCursor, orderSorts["total_desc"])
+	if err != nil || next.ID != "00000000-0000-0000-0000-000000000002" || next.Values[0] != "2000" {
+		t.Fatalf("unexpected next cursor %q: %+v", resp.NextCursor, next)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
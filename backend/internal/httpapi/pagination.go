@@ -0,0 +1,228 @@
+package httpapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// keysetKey is one ORDER BY key of a list. expr must never be NULL, so
+// nullable columns are wrapped in COALESCE; that also gives NULLS LAST
+// semantics without NULLS LAST in the ORDER BY.
+type keysetKey struct {
+	expr string
+	cast string
+	desc bool
+}
+
+// keysetOrder is a sort order of a list: its keys followed by the primary
+// key as a tiebreaker, so that every row has a unique position and a cursor
+// can continue right after it.
+type keysetOrder struct {
+	name   string
+	idCast string
+	idDesc bool
+	keys   []keysetKey
+}
+
+func (o keysetOrder) orderBy() string {
+	parts := make([]string, 0, len(o.keys)+1)
+	for _, k := range o.keys {
+		parts = append(parts, k.expr+direction(k.desc))
+	}
+	return strings.Join(append(parts, "id"+direction(o.idDesc)), ", ")
+}
+
+func direction(desc bool) string {
+	if desc {
+		return " DESC"
+	}
+	return " ASC"
+}
+
+// cursorColumn selects the sort keys of a row as a JSON text array, the
+// values a cursor pointing at that row carries.
+func (o keysetOrder) cursorColumn() string {
+	if len(o.keys) == 0 {
+		return "to_json(ARRAY[]::text[])"
+	}
+	parts := make([]string, 0, len(o.keys))
+	for _, k := range o.keys {
+		parts = append(parts, "("+k.expr+")::text")
+	}
+	return "to_json(ARRAY[" + strings.Join(parts, ", ") + "])"
+}
+
+// after returns the condition for rows that follow the cursor and the args
+// with the cursor values appended. The values are compared as carried: they
+// were written with ::text and are cast back to the key's type, so they are
+// exact, and the boundary does not move when the cursor row changes between
+// page requests.
+func (o keysetOrder) after(c pageCursor, args []interface{}) (string, []interface{}) {
+	args = append(args, c.ID)
+	id := "$" + itoa(len(args)) + "::" + o.idCast
+	values := make([]string, len(o.keys))
+	for i, k := range o.keys {
+		args = append(args, c.Values[i])
+		values[i] = "$" + itoa(len(args)) + "::" + k.cast
+	}
+
+	var (
+		ors    []string
+		equals []string
+	)
+	for i, k := range o.keys {
+		ors = append(ors, "("+strings.Join(append(equals, k.expr+comparison(k.desc)+values[i]), " AND ")+")")
+		equals = append(equals, k.expr+" = "+values[i])
+	}
+	ors = append(ors, "("+strings.Join(append(equals, "id"+comparison(o.idDesc)+id), " AND ")+")")
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+func comparison(desc bool) string {
+	if desc {
+		return " < "
+	}
+	return " > "
+}
+
+// pageCursor is the opaque position after the last row of a page.
+type pageCursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+	ID     string   `json:"id"`
+}
+
+func encodePageCursor(c pageCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodePageCursor(raw string, order keysetOrder) (pageCursor, error) {
+	var c pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return c, errors.New("invalid cursor")
+	}
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == "" || len(c.Values) != len(order.keys) {
+		return c, errors.New("invalid cursor")
+	}
+	if c.Sort != order.name {
+		return c, errors.New("cursor does not match sort")
+	}
+	// The id is cast in SQL; a malformed one must fail here, not there.
+	if order.idCast == "uuid" {
+		var id pgtype.UUID
+		if err := id.Scan(c.ID); err != nil {
+			return c, errors.New("invalid cursor")
+		}
+	}
+	return c, nil
+}
+
+// pageRequest is the paging part of a list request. Clients either walk
+// pages with ?cursor=, or use page/pageSize with OFFSET as before. The total
+// is counted for page requests unless ?total=false, and for cursor requests
+// only with ?total=true.
+type pageRequest struct {
+	page      int
+	pageSize  int
+	cursor    *pageCursor
+	withTotal bool
+}
+
+func parsePageRequest(q url.Values, defaultSize, maxSize int, order keysetOrder) (pageRequest, error) {
+	p := pageRequest{
+		page:     parsePositiveInt(strings.TrimSpace(q.Get("page")), 1),
+		pageSize: parsePositiveInt(strings.TrimSpace(q.Get("pageSize")), defaultSize),
+	}
+	if p.pageSize < 1 {
+		p.pageSize = defaultSize
+	}
+	if p.pageSize > maxSize {
+		p.pageSize = maxSize
+	}
+	if raw := strings.TrimSpace(q.Get("cursor")); raw != "" {
+		c, err := decodePageCursor(raw, order)
+		if err != nil {
+			return p, err
+		}
+		p.cursor = &c
+	}
+	p.withTotal = p.cursor == nil
+	if raw := strings.TrimSpace(q.Get("total")); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return p, errors.New("invalid total")
+		}
+		p.withTotal = v
+	}
+	return p, nil
+}
+
+// apply adds the cursor condition to where and returns the ORDER BY, LIMIT
+// and OFFSET tail of the page query. It asks for one row more than the page
+// so that nextCursor knows whether another page follows.
+func (p pageRequest) apply(order keysetOrder, where []string, args []interface{}) ([]string, []interface{}, string) {
+	where = append([]string{}, where...)
+	args = append([]interface{}{}, args...)
+	offset := (p.page - 1) * p.pageSize
+	if p.cursor != nil {
+		var cond string
+		cond, args = order.after(*p.cursor, args)
+		where = append(where, cond)
+		offset = 0
+	}
+	args = append(args, p.pageSize+1, offset)
+	tail := "ORDER BY " + order.orderBy() + " LIMIT $" + itoa(len(args)-1) + " OFFSET $" + itoa(len(args))
+	return where, args, tail
+}
+
+// nextCursor returns the cursor after the last row of the page, or "" when
+// the query returned no more than a page. ids and keys are per fetched row.
+func (p pageRequest) nextCursor(order keysetOrder, ids []string, keys [][]string) string {
+	if len(ids) <= p.pageSize {
+		return ""
+	}
+	last := p.pageSize - 1
+	return encodePageCursor(pageCursor{Sort: order.name, Values: keys[last], ID: ids[last]})
+}
+
+// response is the JSON body of a page; total is left out when it was not
+// counted.
+func (p pageRequest) response(items interface{}, total int, next string) map[string]interface{} {
+	resp := map[string]interface{}{
+		"items":      items,
+		"page":       p.page,
+		"pageSize":   p.pageSize,
+		"nextCursor": next,
+	}
+	if p.withTotal {
+		resp["total"] = total
+	}
+	return resp
+}
+
+func whereClause(where []string) string {
+	if len(where) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(where, " AND ")
+}
+
+// extraScanner scans the usual columns of a row into dest and the columns
+// selected after them into extra, so row scanners like scanOrder can be
+// reused for page queries that also select cursor keys.
+type extraScanner struct {
+	row   interface{ Scan(...interface{}) error }
+	extra []interface{}
+}
+
+func (s extraScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
@@ -0,0 +1,159 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"parfum-backend/internal/app"
+)
+
+func TestKeysetOrderAfter(t *testing.T) {
+	order := keysetOrder{name: "popular", idCast: "text", keys: []keysetKey{
+		{expr: "order_count", cast: "int", desc: true},
+		{expr: "base_price", cast: "numeric"},
+	}}
+	cond, args := order.after(pageCursor{Sort: "popular", Values: []string{"7", "1500"}, ID: "p-1"}, []interface{}{"retail"})
+
+	want := "((order_count < $3::int) OR " +
+		"(order_count = $3::int AND base_price > $4::numeric) OR " +
+		"(order_count = $3::int AND base_price = $4::numeric AND id > $2::text))"
+	if cond != want {
+		t.Fatalf("after() =\n%s\nwant\n%s", cond, want)
+	}
+	if len(args) != 4 || args[1] != "p-1" || args[2] != "7" || args[3] != "1500" {
+		t.Fatalf("unexpected args: %v", args)
+	}
+}
+
+func TestParsePageRequestCursor(t *testing.T) {
+	order := keysetOrder{name: "priceAsc", keys: []keysetKey{{expr: "base_price", cast: "numeric"}}}
+	raw := encodePageCursor(pageCursor{Sort: "priceAsc", Values: []string{"100"}, ID: "p-1"})
+
+	p, err := parsePageRequest(url.Values{"cursor": {raw}, "pageSize": {"500"}}, 12, 60, order)
+	if err != nil {
+		t.Fatalf("parsePageRequest: %v", err)
+	}
+	if p.cursor == nil || p.cursor.ID != "p-1" || p.pageSize != 60 || p.withTotal {
+		t.Fatalf("unexpected page request: %+v", p)
+	}
+
+	other := keysetOrder{name: "priceDesc", keys: order.keys}
+	if _, err := parsePageRequest(url.Values{"cursor": {raw}}, 12, 60, other); err == nil || err.Error() != "cursor does not match sort" {
+		t.Fatalf("expected sort mismatch, got %v", err)
+	}
+	if _, err := parsePageRequest(url.Values{"cursor": {"%%%"}}, 12, 60, order); err == nil || err.Error() != "invalid cursor" {
+		t.Fatalf("expected invalid cursor, got %v", err)
+	}
+
+	users := keysetOrder{name: "created_desc", idCast: "uuid", keys: []keysetKey{{expr: "created_at", cast: "timestamptz"}}}
+	tampered := encodePageCursor(pageCursor{Sort: "created_desc", Values: []string{"2024-03-01 10:00:00+00"}, ID: "not-a-uuid"})
+	if _, err := parsePageRequest(url.Values{"cursor": {tampered}}, 20, 100, users); err == nil || err.Error() != "invalid cursor" {
+		t.Fatalf("expected invalid cursor for a non-uuid id, got %v", err)
+	}
+	valid := encodePageCursor(pageCursor{Sort: "created_desc", Values: []string{"2024-03-01 10:00:00+00"}, ID: "6f1c2a4e-8b3d-4c5e-9f70-1a2b3c4d5e6f"})
+	if _, err := parsePageRequest(url.Values{"cursor": {valid}}, 20, 100, users); err != nil {
+		t.Fatalf("parsePageRequest: %v", err)
+	}
+}
+
+func TestHandleListPerfumesCursorSkipsCount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	cursor := encodePageCursor(pageCursor{Sort: "priceAsc", Values: []string{"100"}, ID: "p-1"})
+	price := "current_price\\(id, base_price\\)"
+	mock.ExpectQuery("(?s)SELECT id, catalog_mode.*WHERE catalog_mode = \\$1 AND \\(\\("+price+" > \\$3::numeric\\).*"+
+		"ORDER BY "+price+" ASC, id ASC LIMIT \\$4 OFFSET \\$5").
+		WithArgs("retail", "p-1", "100", 2, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	req := httptest.NewRequest(http.MethodGet, "/api/perfumes?sort=priceAsc&pageSize=1&cursor="+cursor, nil)
+	rec := httptest.NewRecorder()
+	s.handleListPerfumes(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if _, ok := resp["total"]; ok || resp["nextCursor"] != "" {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
+
+func TestHandleStockReportCursorKeepsBoundaryWhenRowChanges(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	summary := []string{"total", "unlimited", "zero", "low"}
+	columns := []string{"id", "brand", "name", "image_url", "in_stock", "stock_qty", "updated_at", "cursor"}
+	updated := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+
+	mock.ExpectQuery("(?s)SELECT\\s+COUNT\\(\\*\\) AS total").
+		WillReturnRows(sqlmock.NewRows(summary).AddRow(3, 0, 0, 2))
+	mock.ExpectQuery("(?s)SELECT id, brand, name.*ORDER BY COALESCE\\(stock_qty, 2147483647\\) ASC").
+		WithArgs(2, 0).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("p-1", "Chanel", "Chance", "", true, 2, updated, []byte(`["2", "2024-03-01 10:00:00+00"]`)).
+			AddRow("p-2", "Dior", "Sauvage", "", true, 3, updated, []byte(`["3", "2024-03-01 10:00:00+00"]`)))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/stock?pageSize=1", nil)
+	rec := httptest.NewRecorder()
+	s.handleStockReport(rec, req)
+	var first struct {
+		NextCursor string `json:"nextCursor"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &first); err != nil || first.NextCursor == "" {
+		t.Fatalf("first page: %v %s", err, rec.Body.String())
+	}
+
+	// p-1 sells out before the second page is asked for, which changes both
+	// of its keys. The boundary is still where the first page ended.
+	qty := "COALESCE\\(stock_qty, 2147483647\\)"
+	upd := "COALESCE\\(updated_at, '-infinity'\\)"
+	after := "\\(\\(" + qty + " > \\$2::int\\) OR " +
+		"\\(" + qty + " = \\$2::int AND " + upd + " < \\$3::timestamptz\\) OR " +
+		"\\(" + qty + " = \\$2::int AND " + upd + " = \\$3::timestamptz AND id > \\$1::text\\)\\)"
+	mock.ExpectQuery("(?s)SELECT\\s+COUNT\\(\\*\\) AS total").
+		WillReturnRows(sqlmock.NewRows(summary).AddRow(3, 0, 1, 1))
+	mock.ExpectQuery("(?s)WHERE stock_qty IS NOT NULL AND "+after+"\\s+ORDER BY").
+		WithArgs("p-1", "2", "2024-03-01 10:00:00+00", 2, 0).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("p-2", "Dior", "Sauvage", "", true, 3, updated, []byte(`["3", "2024-03-01 10:00:00+00"]`)))
+
+	req = httptest.NewRequest(http.MethodGet, "/api/admin/stock?pageSize=1&cursor="+first.NextCursor, nil)
+	rec = httptest.NewRecorder()
+	s.handleStockReport(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var second struct {
+		Items []struct {
+			ID string `json:"id"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &second); err != nil || len(second.Items) != 1 || second.Items[0].ID != "p-2" {
+		t.Fatalf("unexpected second page: %v %s", err, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
@@ -0,0 +1,369 @@
+package httpapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var errPerfumeModified = errors.New("perfume was modified by someone else")
+
+// perfumeVersion is the opaque version of a perfume derived from updated_at.
+// It is returned as "version" in perfume JSON and, quoted, as the ETag.
+func perfumeVersion(updatedAt time.Time) string {
+	return strconv.FormatInt(updatedAt.UnixMicro(), 36)
+}
+
+func perfumeETag(updatedAt time.Time) string {
+	return `"` + perfumeVersion(updatedAt) + `"`
+}
+
+// parseIfMatch returns the updated_at the client expects. A missing header
+// or "*" disables the check.
+func parseIfMatch(r *http.Request) (sql.NullTime, error) {
+	raw := strings.TrimSpace(r.Header.Get("If-Match"))
+	if raw == "" || raw == "*" {
+		return sql.NullTime{}, nil
+	}
+	raw = strings.TrimPrefix(raw, "W/")
+	raw = strings.Trim(raw, `"`)
+	micros, err := strconv.ParseInt(raw, 36, 64)
+	if err != nil {
+		return sql.NullTime{}, errors.New("invalid If-Match")
+	}
+	return sql.NullTime{Time: time.UnixMicro(micros).UTC(), Valid: true}, nil
+}
+
+// perfumeReadOnlyFields are maintained by the server: order_count by order
+// creation, reviews by the review handlers and popularity by the
+// popularity job.
+var perfumeReadOnlyFields = map[string]bool{
+	"id":                 true,
+	"orderCount":         true,
+	"popularity":         true,
+	"popularityMonth":    true,
+	"popularityMonthKey": true,
+	"reviewAvg":          true,
+	"reviewCount":        true,
+	"createdAt":          true,
+	"updatedAt":          true,
+	"version":            true,
+}
+
+type perfumeField struct {
+	column string
+	decode func(json.RawMessage) (interface{}, error)
+}
+
+// perfumeEditableFields maps field mask paths to columns.
+var perfumeEditableFields = map[string]perfumeField{
+	"catalogMode":  {"catalog_mode", decodeCatalogModeField},
+	"brand":        {"brand", decodeStringField},
+	"name":         {"name", decodeStringField},
+	"family":       {"family", decodeStringField},
+	"description":  {"description", decodeStringField},
+	"tags":         {"tags", decodeStringListField},
+	"notes.top":    {"notes_top", decodeStringListField},
+	"notes.heart":  {"notes_heart", decodeStringListField},
+	"notes.base":   {"notes_base", decodeStringListField},
+	"seasons":      {"seasons", decodeStringListField},
+	"dayNight":     {"day_night", decodeStringListField},
+	"basePrice":    {"base_price", decodeNumberField},
+	"baseVolume":   {"base_volume", decodePositiveIntField},
+	"sillage":      {"sillage", decodeIntField},
+	"longevity":    {"longevity", decodeIntField},
+	"image":        {"image_url", decodeStringField},
+	"searchNameRu": {"search_name_ru", decodeStringField},
+	"isHit":        {"is_hit", decodeBoolField},
+	"inStock":      {"in_stock", decodeBoolField},
+	"stockQty":     {"stock_qty", decodeNullableIntField},
+	"currency":     {"currency", decodeStringField},
+}
+
+var perfumeFieldAliases = map[string]string{
+	"price":  "basePrice",
+	"volume": "baseVolume",
+}
+
+func decodeStringField(raw json.RawMessage) (interface{}, error) {
+	var v string
+	err := json.Unmarshal(raw, &v)
+	return strings.TrimSpace(v), err
+}
+
+func decodeCatalogModeField(raw json.RawMessage) (interface{}, error) {
+	var v string
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	if v != "retail" && v != "wholesale" {
+		return nil, errors.New("invalid catalog mode")
+	}
+	return v, nil
+}
+
+func decodeStringListField(raw json.RawMessage) (interface{}, error) {
+	var v []string
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	if v == nil {
+		v = []string{}
+	}
+	return pgtype.FlatArray[string](v), nil
+}
+
+func decodeNumberField(raw json.RawMessage) (interface{}, error) {
+	var v float64
+	err := json.Unmarshal(raw, &v)
+	return v, err
+}
+
+func decodeIntField(raw json.RawMessage) (interface{}, error) {
+	var v int
+	err := json.Unmarshal(raw, &v)
+	return v, err
+}
+
+func decodePositiveIntField(raw json.RawMessage) (interface{}, error) {
+	var v int
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	if v <= 0 {
+		return nil, errors.New("must be positive")
+	}
+	return v, nil
+}
+
+func decodeBoolField(raw json.RawMessage) (interface{}, error) {
+	var v bool
+	err := json.Unmarshal(raw, &v)
+	return v, err
+}
+
+func decodeNullableIntField(raw json.RawMessage) (interface{}, error) {
+	var v *int
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, nil
+	}
+	return *v, nil
+}
+
+// flattenPerfumePatch turns {"notes": {"top": [...]}} into "notes.top" paths
+// and resolves aliases.
+func flattenPerfumePatch(body map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	out := make(map[string]json.RawMessage, len(body))
+	for key, raw := range body {
+		if key == "notes" {
+			var notes map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &notes); err != nil {
+				return nil, errors.New("invalid notes")
+			}
+			for sub, v := range notes {
+				out["notes."+sub] = v
+			}
+			continue
+		}
+		if alias, ok := perfumeFieldAliases[key]; ok {
+			key = alias
+		}
+		out[key] = raw
+	}
+	return out, nil
+}
+
+// perfumePatchPaths picks the fields to update: the ?fields= mask when given,
+// otherwise every field present in the body. Server-maintained fields are
+// rejected in a mask and skipped in the body, so a perfume fetched from the
+// API can be sent back unchanged.
+func perfumePatchPaths(mask string, body map[string]json.RawMessage) ([]string, error) {
+	var paths []string
+	if strings.TrimSpace(mask) != "" {
+		for _, path := range strings.Split(mask, ",") {
+			path = strings.TrimSpace(path)
+			if alias, ok := perfumeFieldAliases[path]; ok {
+				path = alias
+			}
+			if path == "notes" {
+				paths = append(paths, "notes.top", "notes.heart", "notes.base")
+				continue
+			}
+			if perfumeReadOnlyFields[path] {
+				return nil, errors.New("field " + path + " is server-maintained")
+			}
+			if _, ok := perfumeEditableFields[path]; !ok {
+				return nil, errors.New("unknown field " + path)
+			}
+			paths = append(paths, path)
+		}
+	} else {
+		for path := range body {
+			if perfumeReadOnlyFields[path] {
+				continue
+			}
+			if _, ok := perfumeEditableFields[path]; !ok {
+				return nil, errors.New("unknown field " + path)
+			}
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// handlePatchPerfume updates only the requested catalog fields. With
+// If-Match the update only applies to the version the client has seen.
+func (s *Server) handlePatchPerfume(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "missing id")
+		return
+	}
+	expected, err := parseIfMatch(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var rawBody map[string]json.RawMessage
+	if err := readJSON(r, &rawBody); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	body, err := flattenPerfumePatch(rawBody)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	paths, err := perfumePatchPaths(r.URL.Query().Get("fields"), body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(paths) == 0 {
+		writeError(w, http.StatusBadRequest, "no fields to update")
+		return
+	}
+
+	updatedAt, ok := s.savePerfumeFields(w, r, id, body, paths, expected, 0)
+	if !ok {
+		return
+	}
+	s.catalogChanged(r.Context())
+	w.Header().Set("ETag", perfumeETag(updatedAt))
+	writeJSON(w, http.StatusOK, map[string]string{"id": id, "version": perfumeVersion(updatedAt)})
+}
+
+// perfumeFieldError is a field value that cannot be written.
+type perfumeFieldError string
+
+func (e perfumeFieldError) Error() string { return string(e) }
+
+// updatePerfumeFields writes the paths of body to a perfume, canonicalizing
+// dictionary values. With expected set the update only applies to that
+// version; otherwise sql.ErrNoRows is returned.
+func updatePerfumeFields(q dbQueryer, id string, body map[string]json.RawMessage, paths []string, expected sql.NullTime) (time.Time, error) {
+	sets := make([]string, 0, len(paths)+1)
+	args := []interface{}{id}
+	for _, path := range paths {
+		field := perfumeEditableFields[path]
+		raw, ok := body[path]
+		if !ok {
+			return time.Time{}, perfumeFieldError("missing value for " + path)
+		}
+		value, err := field.decode(raw)
+		if err != nil {
+			return time.Time{}, perfumeFieldError("invalid " + path)
+		}
+		if kind, ok := perfumeReferenceFields[path]; ok {
+			if value, err = canonicalizeField(q, referenceKinds[kind], value); err != nil {
+				return time.Time{}, err
+			}
+		}
+		args = append(args, value)
+		sets = append(sets, field.column+" = $"+itoa(len(args)))
+	}
+	sets = append(sets, "updated_at = now()")
+	args = append(args, expected)
+
+	var updatedAt time.Time
+	err := q.QueryRow(`
+		UPDATE perfumes SET `+strings.Join(sets, ", ")+`
+		WHERE id = $1 AND ($`+itoa(len(args))+`::timestamptz IS NULL OR updated_at = $`+itoa(len(args))+`)
+		RETURNING updated_at
+	`, args...).Scan(&updatedAt)
+	return updatedAt, err
+}
+
+// savePerfumeFields runs updatePerfumeFields, syncs the default variant and
+// records a version in one transaction, writing the error response when any
+// of them fails.
+func (s *Server) savePerfumeFields(w http.ResponseWriter, r *http.Request, id string, body map[string]json.RawMessage, paths []string, expected sql.NullTime, restoredFrom int) (time.Time, bool) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot start transaction")
+		return time.Time{}, false
+	}
+	defer tx.Rollback()
+	updatedAt, err := updatePerfumeFields(tx, id, body, paths, expected)
+	if err != nil {
+		s.writePerfumeUpdateError(w, id, err)
+		return time.Time{}, false
+	}
+	if err := syncDefaultVariant(tx, id); err != nil {
+		writeDefaultVariantError(w, err)
+		return time.Time{}, false
+	}
+	user, _ := authUserFrom(r.Context())
+	if err := recordPerfumeVersion(tx, id, user.ID, restoredFrom); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot record perfume version")
+		return time.Time{}, false
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot save perfume")
+		return time.Time{}, false
+	}
+	return updatedAt, true
+}
+
+func (s *Server) writePerfumeUpdateError(w http.ResponseWriter, id string, err error) {
+	var (
+		fieldErr perfumeFieldError
+		unknown  unknownReferenceError
+	)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		s.writePerfumeWriteMiss(w, id)
+	case errors.As(err, &fieldErr):
+		writeError(w, http.StatusBadRequest, fieldErr.Error())
+	case errors.As(err, &unknown):
+		writeError(w, http.StatusBadRequest, unknown.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "cannot save perfume")
+	}
+}
+
+// writePerfumeWriteMiss explains a conditional write that matched no row.
+func (s *Server) writePerfumeWriteMiss(w http.ResponseWriter, id string) {
+	var exists bool
+	if err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM perfumes WHERE id = $1)`, id).Scan(&exists); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot save perfume")
+		return
+	}
+	if !exists {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeError(w, http.StatusConflict, errPerfumeModified.Error())
+}
@@ -0,0 +1,149 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"parfum-backend/internal/app"
+)
+
+func TestPerfumePatchPaths(t *testing.T) {
+	body, err := flattenPerfumePatch(map[string]json.RawMessage{
+		"brand":      json.RawMessage(`"Chanel"`),
+		"price":      json.RawMessage(`1200`),
+		"notes":      json.RawMessage(`{"top":["Бергамот"]}`),
+		"orderCount": json.RawMessage(`0`),
+	})
+	if err != nil {
+		t.Fatalf("flatten: %v", err)
+	}
+	paths, err := perfumePatchPaths("", body)
+	if err != nil {
+		t.Fatalf("paths: %v", err)
+	}
+	if want := []string{"basePrice", "brand", "notes.top"}; !reflect.DeepEqual(paths, want) {
+		t.Fatalf("paths = %v, want %v", paths, want)
+	}
+	if _, err := perfumePatchPaths("brand,orderCount", body); err == nil {
+		t.Fatalf("expected server-maintained field in mask to be rejected")
+	}
+	if _, err := perfumePatchPaths("", map[string]json.RawMessage{"bogus": json.RawMessage(`1`)}); err == nil {
+		t.Fatalf("expected unknown field to be rejected")
+	}
+}
+
+func TestParseIfMatchRoundTrip(t *testing.T) {
+	updatedAt := time.Date(2024, 5, 1, 12, 30, 15, 123456000, time.UTC)
+	req := httptest.NewRequest(http.MethodPatch, "/", nil)
+	req.Header.Set("If-Match", perfumeETag(updatedAt))
+	got, err := parseIfMatch(req)
+	if err != nil || !got.Valid || !got.Time.Equal(updatedAt) {
+		t.Fatalf("parseIfMatch = %v, %v", got, err)
+	}
+}
+
+func TestHandlePatchPerfumeConflict(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passthroughConverter{}))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	seen := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery("(?s)SELECT DISTINCT ON \\(t.i\\) t.v, r.name.*LEFT JOIN brands r").
+		WithArgs(pgtype.FlatArray[string]{"dior"}).
+		WillReturnRows(sqlmock.NewRows([]string{"v", "name"}).AddRow("dior", "Dior"))
+	mock.ExpectQuery("(?s)UPDATE perfumes SET brand = \\$2, updated_at = now\\(\\)\\s+WHERE id = \\$1 AND \\(\\$3::timestamptz IS NULL OR updated_at = \\$3\\)").
+		WithArgs("p1", "Dior", seen).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs("p1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	req := httptest.NewRequest(http.MethodPatch, "/api/perfumes/p1?fields=brand", bytes.NewReader([]byte(`{"brand":"dior","name":"ignored"}`)))
+	req.Header.Set("If-Match", perfumeETag(seen))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "p1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	rec := httptest.NewRecorder()
+	s.handlePatchPerfume(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
+
+func TestHandlePatchPerfumeRollsBackOnVariantConflict(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passthroughConverter{}))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("(?s)UPDATE perfumes SET base_volume = \\$2, updated_at = now\\(\\)").
+		WithArgs("p1", 100, sql.NullTime{}).
+		WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(time.Now()))
+	mock.ExpectExec("SELECT sync_default_variant\\(\\$1\\)").
+		WithArgs("p1").
+		WillReturnError(&pgconn.PgError{Code: "23505", ConstraintName: "perfume_variants_perfume_id_volume_mix_key"})
+	mock.ExpectRollback()
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	req := httptest.NewRequest(http.MethodPatch, "/api/perfumes/p1", bytes.NewReader([]byte(`{"baseVolume":100}`)))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "p1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	rec := httptest.NewRecorder()
+	s.handlePatchPerfume(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
+
+func TestHandlePatchPerfumeRejectsZeroVolume(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	req := httptest.NewRequest(http.MethodPatch, "/api/perfumes/p1", bytes.NewReader([]byte(`{"baseVolume":0}`)))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "p1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	rec := httptest.NewRecorder()
+	s.handlePatchPerfume(rec, req)
+
+	if rec.Code != http.StatusBadRequest || !bytes.Contains(rec.Body.Bytes(), []byte("invalid baseVolume")) {
+		t.Fatalf("expected invalid baseVolume, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package httpapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// perfumeSnapshotFields are the perfumeEditableFields paths stored in a
+// perfume_versions snapshot, in the order changes are listed. Stock is not
+// part of a version.
+var perfumeSnapshotFields = []string{
+	"catalogMode", "brand", "name", "family", "description",
+	"tags", "notes.top", "notes.heart", "notes.base", "seasons", "dayNight",
+	"basePrice", "baseVolume", "sillage", "longevity", "image", "searchNameRu",
+	"isHit", "currency",
+}
+
+// recordPerfumeVersion stores the current state of a perfume as a new
+// version unless nothing changed since the latest one. restoredFrom is 0
+// for regular edits.
+func recordPerfumeVersion(q dbQueryer, perfumeID, userID string, restoredFrom int) error {
+	var restored interface{}
+	if restoredFrom > 0 {
+		restored = restoredFrom
+	}
+	_, err := q.Exec(`SELECT record_perfume_version($1, $2::uuid, $3)`, perfumeID, nullIfEmpty(userID), restored)
+	return err
+}
+
+// snapshotChanges lists the fields that differ between two snapshots.
+func snapshotChanges(before, after map[string]interface{}) []PerfumeFieldChange {
+	changes := []PerfumeFieldChange{}
+	for _, field := range perfumeSnapshotFields {
+		if !reflect.DeepEqual(before[field], after[field]) {
+			changes = append(changes, PerfumeFieldChange{Field: field, Old: before[field], New: after[field]})
+		}
+	}
+	return changes
+}
+
+// handlePerfumeHistory lists the versions of a perfume, newest first, each
+// with its changes against the version before. The first version has no
+// changes; it is the perfume as created or as it was when history started.
+func (s *Server) handlePerfumeHistory(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+	rows, err := s.db.Query(`
+		SELECT v.version, v.snapshot, COALESCE(v.changed_by::text, ''), COALESCE(u.email::text, ''),
+		       COALESCE(v.restored_from, 0), v.created_at
+		FROM perfume_versions v
+		LEFT JOIN users u ON u.id = v.changed_by
+		WHERE v.perfume_id = $1
+		ORDER BY v.version
+	`, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load history")
+		return
+	}
+	defer rows.Close()
+	var (
+		list []PerfumeVersion
+		prev map[string]interface{}
+	)
+	for rows.Next() {
+		var (
+			v         PerfumeVersion
+			snapshot  []byte
+			createdAt time.Time
+		)
+		if err := rows.Scan(&v.Version, &snapshot, &v.ChangedBy, &v.ChangedByEmail, &v.RestoredFrom, &createdAt); err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot parse history")
+			return
+		}
+		if err := json.Unmarshal(snapshot, &v.Snapshot); err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot parse history")
+			return
+		}
+		v.Changes = []PerfumeFieldChange{}
+		if prev != nil {
+			v.Changes = snapshotChanges(prev, v.Snapshot)
+		}
+		v.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		prev = v.Snapshot
+		list = append(list, v)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load history")
+		return
+	}
+	if len(list) == 0 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleRestorePerfumeVersion writes the fields of an earlier version back
+// to the perfume, which records a new version pointing at the restored one.
+// Like PATCH it honours If-Match.
+func (s *Server) handleRestorePerfumeVersion(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+	version, err := strconv.Atoi(chi.URLParam(r, "version"))
+	if err != nil || version <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid version")
+		return
+	}
+	expected, err := parseIfMatch(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var snapshot []byte
+	err = s.db.QueryRow(`SELECT snapshot FROM perfume_versions WHERE perfume_id = $1 AND version = $2`, id, version).Scan(&snapshot)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load history")
+		return
+	}
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(snapshot, &body); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot parse history")
+		return
+	}
+	paths, err := perfumePatchPaths("", body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot parse history")
+		return
+	}
+
+	updatedAt, ok := s.savePerfumeFields(w, r, id, body, paths, expected, version)
+	if !ok {
+		return
+	}
+	s.catalogChanged(r.Context())
+	w.Header().Set("ETag", perfumeETag(updatedAt))
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": id, "version": perfumeVersion(updatedAt), "restoredFrom": version})
+}
@@ -0,0 +1,99 @@
+package httpapi
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"parfum-backend/internal/app"
+)
+
+func historyRequest(method, target string, params map[string]string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	rctx := chi.NewRouteContext()
+	for k, v := range params {
+		rctx.URLParams.Add(k, v)
+	}
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestHandlePerfumeHistoryDiffsVersions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	at := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("(?s)FROM perfume_versions v\\s+LEFT JOIN users u.*WHERE v.perfume_id = \\$1\\s+ORDER BY v.version").
+		WithArgs("p1").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "snapshot", "changed_by", "email", "restored_from", "created_at"}).
+			AddRow(1, []byte(`{"name":"Chance","basePrice":100,"tags":["женский"]}`), "", "", 0, at).
+			AddRow(2, []byte(`{"name":"Chance","basePrice":120,"tags":["женский","цветочный"]}`), "u1", "admin@example.com", 0, at.Add(time.Hour)))
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	rec := httptest.NewRecorder()
+	s.handlePerfumeHistory(rec, historyRequest(http.MethodGet, "/api/perfumes/p1/history", map[string]string{"id": "p1"}))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var list []PerfumeVersion
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(list) != 2 || list[0].Version != 2 || list[0].ChangedByEmail != "admin@example.com" || len(list[1].Changes) != 0 {
+		t.Fatalf("unexpected history: %+v", list)
+	}
+	changes := list[0].Changes
+	if len(changes) != 2 || changes[0].Field != "tags" || changes[1].Field != "basePrice" || changes[1].Old != 100.0 || changes[1].New != 120.0 {
+		t.Fatalf("unexpected changes: %+v", changes)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
+
+func TestHandleRestorePerfumeVersion(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passthroughConverter{}))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	updatedAt := time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT snapshot FROM perfume_versions WHERE perfume_id = \\$1 AND version = \\$2").
+		WithArgs("p1", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"snapshot"}).AddRow([]byte(`{"basePrice":100,"description":"Старое описание"}`)))
+	mock.ExpectBegin()
+	mock.ExpectQuery("(?s)UPDATE perfumes SET base_price = \\$2, description = \\$3, updated_at = now\\(\\)").
+		WithArgs("p1", 100.0, "Старое описание", sql.NullTime{}).
+		WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(updatedAt))
+	mock.ExpectExec("SELECT sync_default_variant\\(\\$1\\)").
+		WithArgs("p1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("SELECT record_perfume_version\\(\\$1, \\$2::uuid, \\$3\\)").
+		WithArgs("p1", nil, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	rec := httptest.NewRecorder()
+	s.handleRestorePerfumeVersion(rec, historyRequest(http.MethodPost, "/api/perfumes/p1/history/1/restore", map[string]string{"id": "p1", "version": "1"}))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("ETag") != perfumeETag(updatedAt) {
+		t.Fatalf("unexpected ETag %q", rec.Header().Get("ETag"))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
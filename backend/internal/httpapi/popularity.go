@@ -0,0 +1,110 @@
+package httpapi
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Popularity weights per event. Purchases come from order_items rather than
+// "buy" stat events, so that each sold unit counts once.
+const (
+	popularityViewWeight     = 1
+	popularityCartWeight     = 5
+	popularityPurchaseWeight = 20
+
+	// popularity decays slowly and looks a year back; trending reacts to the
+	// last two weeks.
+	popularityHalfLifeDays = 30
+	popularityWindowDays   = 365
+	trendingHalfLifeDays   = 3
+	trendingWindowDays     = 14
+)
+
+// RunPopularityJob recomputes popularity scores every interval until ctx is
+// cancelled.
+func (s *Server) RunPopularityJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.RecomputePopularity(ctx, time.Now().UTC()); err != nil {
+			log.Printf("recompute popularity: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RecomputePopularity rewrites popularity, popularity_month and
+// trending_score for every perfume from stat_events_daily and order_items.
+// popularity_month only counts the calendar month of now, so it starts over
+// as soon as popularity_month_key changes. Rows whose scores did not move are
+// left alone, so a run neither rewrites nor locks the idle part of the
+// catalog; popularity_updated_at is when the scores last changed.
+func (s *Server) RecomputePopularity(ctx context.Context, now time.Time) error {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	since := today.AddDate(0, 0, -popularityWindowDays)
+	if monthStart.Before(since) {
+		since = monthStart
+	}
+	trendingSince := today.AddDate(0, 0, -trendingWindowDays)
+
+	_, err := s.db.ExecContext(ctx, `
+		WITH events AS (
+			SELECT perfume_id, day,
+				CASE type WHEN 'view' THEN $1::float8 ELSE $2::float8 END * count AS weight
+			FROM stat_events_daily
+			WHERE day >= $4::date AND type IN ('view', 'add_to_cart')
+			UNION ALL
+			SELECT oi.perfume_id, o.created_at::date, $3::float8 * oi.qty
+			FROM order_items oi
+			JOIN orders o ON o.id = oi.order_id
+			WHERE o.created_at >= $4::date
+		), scores AS (
+			SELECT perfume_id,
+				SUM(weight * exp(-ln(2) * ($5::date - day) / $6::float8))
+					FILTER (WHERE day >= $5::date - $7::int) AS popularity,
+				SUM(weight * exp(-ln(2) * ($5::date - day) / $8::float8))
+					FILTER (WHERE day >= $9::date) AS trending,
+				SUM(weight) FILTER (WHERE day >= $10::date) AS month
+			FROM events
+			GROUP BY perfume_id
+		), fresh AS (
+			SELECT p.id,
+				COALESCE(round(s.popularity), 0)::int AS popularity,
+				COALESCE(round(s.month), 0)::int AS month,
+				$11::text AS month_key,
+				COALESCE(s.trending, 0)::float8 AS trending
+			FROM perfumes p
+			LEFT JOIN scores s ON s.perfume_id = p.id
+		)
+		UPDATE perfumes p SET
+			popularity = f.popularity,
+			popularity_month = f.month,
+			popularity_month_key = f.month_key,
+			trending_score = f.trending,
+			popularity_updated_at = now()
+		FROM fresh f
+		WHERE p.id = f.id
+			AND (p.popularity, p.popularity_month, p.popularity_month_key, p.trending_score)
+				IS DISTINCT FROM (f.popularity, f.month, f.month_key, f.trending)
+	`,
+		popularityViewWeight, popularityCartWeight, popularityPurchaseWeight,
+		since, today, popularityHalfLifeDays, popularityWindowDays,
+		trendingHalfLifeDays, trendingSince, monthStart, monthStart.Format("2006-01"),
+	)
+	return err
+}
+
+func (s *Server) handleRecomputePopularity(w http.ResponseWriter, r *http.Request) {
+	if err := s.RecomputePopularity(r.Context(), time.Now().UTC()); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot recompute popularity")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,37 @@
+package httpapi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"parfum-backend/internal/app"
+)
+
+func TestRecomputePopularityRollsMonthKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Date(2024, 4, 2, 3, 0, 0, 0, time.UTC)
+	today := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectExec("(?s)WITH events AS .*UPDATE perfumes p SET.*IS DISTINCT FROM").
+		WithArgs(
+			popularityViewWeight, popularityCartWeight, popularityPurchaseWeight,
+			today.AddDate(0, 0, -popularityWindowDays), today, popularityHalfLifeDays, popularityWindowDays,
+			trendingHalfLifeDays, today.AddDate(0, 0, -trendingWindowDays), monthStart, "2024-04",
+		).
+		WillReturnResult(sqlmock.NewResult(0, 12))
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	if err := s.RecomputePopularity(context.Background(), now); err != nil {
+		t.Fatalf("recompute: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package httpapi
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// lowestPriceWindow is how far back LowestPrice30d looks.
+const lowestPriceWindow = 30 * 24 * time.Hour
+
+// handlePriceHistory returns the recorded prices of a perfume, newest first,
+// with the lowest price of the last 30 days. Entries are written by
+// triggers on perfumes.base_price and price_rules.
+func (s *Server) handlePriceHistory(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+	since := time.Now().Add(-lowestPriceWindow)
+	res := PriceHistory{PerfumeID: id, Entries: []PriceHistoryEntry{}}
+	err := s.db.QueryRow(`
+		SELECT currency, `+currentPriceSQL+`, base_price, COALESCE(lowest_price(id, $2), `+currentPriceSQL+`)
+		FROM perfumes
+		WHERE id = $1
+	`, id, since).Scan(&res.Currency, &res.CurrentPrice, &res.RegularPrice, &res.LowestPrice30d)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load price history")
+		return
+	}
+
+	rows, err := s.db.Query(`
+		SELECT source, price, COALESCE(rule_id::text, ''), starts_at, ends_at, created_at
+		FROM price_history
+		WHERE perfume_id = $1
+		ORDER BY id DESC
+	`, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load price history")
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			e                   PriceHistoryEntry
+			startsAt, createdAt time.Time
+			endsAt              sql.NullTime
+		)
+		if err := rows.Scan(&e.Source, &e.Price, &e.RuleID, &startsAt, &endsAt, &createdAt); err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot parse price history")
+			return
+		}
+		e.StartsAt = startsAt.UTC().Format(time.RFC3339)
+		if endsAt.Valid {
+			e.EndsAt = endsAt.Time.UTC().Format(time.RFC3339)
+		}
+		e.RecordedAt = createdAt.UTC().Format(time.RFC3339)
+		res.Entries = append(res.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load price history")
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,72 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"parfum-backend/internal/app"
+)
+
+func TestHandlePriceHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	at := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("(?s)SELECT currency, current_price\\(id, base_price\\), base_price, COALESCE\\(lowest_price\\(id, \\$2\\).*FROM perfumes\\s+WHERE id = \\$1").
+		WithArgs("p1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"currency", "current", "base", "lowest"}).AddRow("₽", 90.0, 120.0, 80.0))
+	mock.ExpectQuery("(?s)FROM price_history\\s+WHERE perfume_id = \\$1\\s+ORDER BY id DESC").
+		WithArgs("p1").
+		WillReturnRows(sqlmock.NewRows([]string{"source", "price", "rule_id", "starts_at", "ends_at", "created_at"}).
+			AddRow("sale", 90.0, "r1", at.Add(48*time.Hour), nil, at.Add(48*time.Hour)).
+			AddRow("base", 120.0, "", at.Add(24*time.Hour), nil, at.Add(24*time.Hour)).
+			AddRow("base", 80.0, "", at, nil, at))
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	rec := httptest.NewRecorder()
+	s.handlePriceHistory(rec, historyRequest(http.MethodGet, "/api/perfumes/p1/price-history", map[string]string{"id": "p1"}))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var res PriceHistory
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if res.CurrentPrice != 90 || res.RegularPrice != 120 || res.LowestPrice30d != 80 || len(res.Entries) != 3 {
+		t.Fatalf("unexpected history: %+v", res)
+	}
+	if e := res.Entries[0]; e.Source != "sale" || e.RuleID != "r1" || e.EndsAt != "" || e.StartsAt != "2024-03-03T10:00:00Z" {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
+
+func TestHandlePriceHistoryNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("(?s)FROM perfumes\\s+WHERE id = \\$1").
+		WithArgs("missing", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"currency", "current", "base", "lowest"}))
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	rec := httptest.NewRecorder()
+	s.handlePriceHistory(rec, historyRequest(http.MethodGet, "/api/perfumes/missing/price-history", map[string]string{"id": "missing"}))
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,159 @@
+package httpapi
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// currentPriceSQL is the price a perfume sells for now, with an active sale
+// of its default variant applied. Catalog filters and price sorting use it
+// instead of base_price. It expects perfumes without an alias.
+const currentPriceSQL = "current_price(id, base_price)"
+
+const priceRuleColumns = `id, perfume_id, COALESCE(variant_id::text, ''), sale_price, starts_at, ends_at, note,
+	starts_at <= now() AND (ends_at IS NULL OR ends_at > now()), created_at, updated_at`
+
+func scanPriceRule(row interface{ Scan(...interface{}) error }) (PriceRule, error) {
+	var (
+		rule                 PriceRule
+		startsAt             time.Time
+		endsAt               sql.NullTime
+		createdAt, updatedAt time.Time
+	)
+	if err := row.Scan(&rule.ID, &rule.PerfumeID, &rule.VariantID, &rule.SalePrice, &startsAt, &endsAt, &rule.Note,
+		&rule.Active, &createdAt, &updatedAt); err != nil {
+		return rule, err
+	}
+	rule.StartsAt = startsAt.UTC().Format(time.RFC3339)
+	if endsAt.Valid {
+		rule.EndsAt = endsAt.Time.UTC().Format(time.RFC3339)
+	}
+	rule.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+	rule.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
+	return rule, nil
+}
+
+func (s *Server) handleListPriceRules(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+	rows, err := s.db.Query(`SELECT `+priceRuleColumns+` FROM price_rules WHERE perfume_id = $1 ORDER BY starts_at DESC, id`, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load price rules")
+		return
+	}
+	defer rows.Close()
+	list := []PriceRule{}
+	for rows.Next() {
+		rule, err := scanPriceRule(rows)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot parse price rules")
+			return
+		}
+		list = append(list, rule)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+type priceRulePayload struct {
+	VariantID string  `json:"variantId"`
+	SalePrice float64 `json:"salePrice"`
+	StartsAt  string  `json:"startsAt"`
+	EndsAt    string  `json:"endsAt"`
+	Note      string  `json:"note"`
+}
+
+// handleUpsertPriceRule creates or updates a sale of a perfume. A missing
+// startsAt starts the sale right away.
+func (s *Server) handleUpsertPriceRule(w http.ResponseWriter, r *http.Request) {
+	perfumeID := strings.TrimSpace(chi.URLParam(r, "id"))
+	ruleID := chi.URLParam(r, "ruleId")
+	var body priceRulePayload
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if body.SalePrice < 0 {
+		writeError(w, http.StatusBadRequest, "invalid sale price")
+		return
+	}
+	startsAt, err := parseOptionalTime(body.StartsAt)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid startsAt")
+		return
+	}
+	endsAt, err := parseOptionalTime(body.EndsAt)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid endsAt")
+		return
+	}
+	if startsAt.Valid && endsAt.Valid && !endsAt.Time.After(startsAt.Time) {
+		writeError(w, http.StatusBadRequest, "endsAt must be after startsAt")
+		return
+	}
+	variantID := strings.TrimSpace(body.VariantID)
+	if variantID != "" {
+		var ok bool
+		err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM perfume_variants WHERE id::text = $1 AND perfume_id = $2)`, variantID, perfumeID).Scan(&ok)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot save price rule")
+			return
+		}
+		if !ok {
+			writeError(w, http.StatusBadRequest, "invalid variant id")
+			return
+		}
+	}
+
+	args := []interface{}{perfumeID, nullIfEmpty(variantID), body.SalePrice, startsAt, endsAt, strings.TrimSpace(body.Note)}
+	var rule PriceRule
+	if ruleID == "" {
+		rule, err = scanPriceRule(s.db.QueryRow(`
+			INSERT INTO price_rules (perfume_id, variant_id, sale_price, starts_at, ends_at, note, created_at, updated_at)
+			VALUES ($1, $2::uuid, $3, COALESCE($4, now()), $5, $6, now(), now())
+			RETURNING `+priceRuleColumns, args...))
+	} else {
+		rule, err = scanPriceRule(s.db.QueryRow(`
+			UPDATE price_rules SET
+				variant_id=$2::uuid, sale_price=$3, starts_at=COALESCE($4, starts_at), ends_at=$5, note=$6, updated_at=now()
+			WHERE id::text=$7 AND perfume_id=$1
+			RETURNING `+priceRuleColumns, append(args, ruleID)...))
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		if errors.As(err, &pgErr) && pgErr.Code == "23514" {
+			writeError(w, http.StatusBadRequest, "endsAt must be after startsAt")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "cannot save price rule")
+		return
+	}
+	writeJSON(w, http.StatusOK, rule)
+}
+
+func (s *Server) handleDeletePriceRule(w http.ResponseWriter, r *http.Request) {
+	perfumeID := strings.TrimSpace(chi.URLParam(r, "id"))
+	ruleID := chi.URLParam(r, "ruleId")
+	res, err := s.db.Exec(`DELETE FROM price_rules WHERE id::text = $1 AND perfume_id = $2`, ruleID, perfumeID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot delete price rule")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,92 @@
+package httpapi
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"parfum-backend/internal/app"
+)
+
+func priceRuleRequest(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "p1")
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestPerfumeToResponseAppliesSale(t *testing.T) {
+	sale := 80.0
+	p := perfumePayload{
+		ID:        "p1",
+		BasePrice: 100,
+		Variants: []PerfumeVariant{
+			{ID: "v1", Volume: 50, Mix: "60/40", Price: 100, IsDefault: true, SalePrice: &sale, SaleEndsAt: "2024-03-10T00:00:00Z"},
+		},
+	}
+	res := perfumeToResponse(p, time.Now(), sql.NullTime{})
+	if res["price"] != 80.0 || res["compareAtPrice"] != 100.0 || res["saleEndsAt"] != "2024-03-10T00:00:00Z" {
+		t.Fatalf("unexpected sale fields: price=%v compareAtPrice=%v saleEndsAt=%v", res["price"], res["compareAtPrice"], res["saleEndsAt"])
+	}
+
+	p.Variants[0].SalePrice = nil
+	res = perfumeToResponse(p, time.Now(), sql.NullTime{})
+	if res["price"] != 100.0 || res["compareAtPrice"] != nil || res["saleEndsAt"] != nil {
+		t.Fatalf("unexpected regular fields: price=%v compareAtPrice=%v saleEndsAt=%v", res["price"], res["compareAtPrice"], res["saleEndsAt"])
+	}
+}
+
+func TestHandleUpsertPriceRuleRejectsEndBeforeStart(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	req := priceRuleRequest(http.MethodPost, "/api/perfumes/p1/price-rules", `{"salePrice":80,"startsAt":"2024-03-10T00:00:00Z","endsAt":"2024-03-01T00:00:00Z"}`)
+	rec := httptest.NewRecorder()
+	s.handleUpsertPriceRule(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
+
+func TestHandleUpsertPriceRuleCreatesRule(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passthroughConverter{}))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	at := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("(?s)INSERT INTO price_rules .*RETURNING id, perfume_id").
+		WithArgs("p1", nil, 80.0, sql.NullTime{}, sql.NullTime{Time: at.Add(7 * 24 * time.Hour), Valid: true}, "весенняя акция").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "perfume_id", "variant_id", "sale_price", "starts_at", "ends_at", "note", "active", "created_at", "updated_at"}).
+			AddRow("r1", "p1", "", 80.0, at, at.Add(7*24*time.Hour), "весенняя акция", true, at, at))
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	req := priceRuleRequest(http.MethodPost, "/api/perfumes/p1/price-rules", `{"salePrice":80,"endsAt":"2024-03-08T00:00:00Z","note":" весенняя акция "}`)
+	rec := httptest.NewRecorder()
+	s.handleUpsertPriceRule(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `"endsAt":"2024-03-08T00:00:00Z"`) || !strings.Contains(rec.Body.String(), `"active":true`) {
+		t.Fatalf("unexpected body: %s", rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
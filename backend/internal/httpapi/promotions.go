@@ -0,0 +1,548 @@
+package httpapi
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var (
+	errPromoNotFound      = errors.New("promo not found")
+	errPromoInactive      = errors.New("promo inactive")
+	errPromoNotStarted    = errors.New("promo not started")
+	errPromoExpired       = errors.New("promo expired")
+	errPromoExhausted     = errors.New("promo usage limit reached")
+	errPromoUserLimit     = errors.New("promo already used")
+	errPromoMinTotal      = errors.New("order total below promo minimum")
+	errPromoNotApplicable = errors.New("promo not applicable to cart")
+)
+
+type promoPayload struct {
+	Code           string   `json:"code"`
+	Description    string   `json:"description"`
+	Kind           string   `json:"kind"`
+	Amount         float64  `json:"amount"`
+	MinTotal       float64  `json:"minTotal"`
+	MaxUses        *int     `json:"maxUses"`
+	MaxUsesPerUser *int     `json:"maxUsesPerUser"`
+	StartsAt       string   `json:"startsAt"`
+	EndsAt         string   `json:"endsAt"`
+	CatalogMode    string   `json:"catalogMode"`
+	Brands         []string `json:"brands"`
+	PerfumeIDs     []string `json:"perfumeIds"`
+	IsActive       *bool    `json:"isActive"`
+}
+
+type validatePromoRequest struct {
+	Code    string      `json:"code"`
+	Items   []OrderItem `json:"items"`
+	Contact struct {
+		Phone string `json:"phone"`
+	} `json:"contact"`
+}
+
+type promoCode struct {
+	ID             string
+	Code           string
+	Description    string
+	Kind           string
+	Amount         float64
+	MinTotal       float64
+	MaxUses        *int
+	MaxUsesPerUser *int
+	UsedCount      int
+	StartsAt       sql.NullTime
+	EndsAt         sql.NullTime
+	CatalogMode    string
+	Brands         []string
+	PerfumeIDs     []string
+	IsActive       bool
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// cartLine is one cart position enriched with the catalog data that promo
+// scoping and delivery availability depend on.
+type cartLine struct {
+	ID          string
+	Brand       string
+	Name        string
+	CatalogMode string
+	Price       float64
+	Qty         int
+}
+
+const promoColumns = `id, code, description, kind, amount, min_total, max_uses, max_uses_per_user, used_count,
+	starts_at, ends_at, catalog_mode, to_json(brands), to_json(perfume_ids), is_active, created_at, updated_at`
+
+func scanPromo(row interface{ Scan(...interface{}) error }) (promoCode, error) {
+	var (
+		p          promoCode
+		brandsJSON []byte
+		idsJSON    []byte
+	)
+	if err := row.Scan(
+		&p.ID, &p.Code, &p.Description, &p.Kind, &p.Amount, &p.MinTotal, &p.MaxUses, &p.MaxUsesPerUser, &p.UsedCount,
+		&p.StartsAt, &p.EndsAt, &p.CatalogMode, &brandsJSON, &idsJSON, &p.IsActive, &p.CreatedAt, &p.UpdatedAt,
+	); err != nil {
+		return promoCode{}, err
+	}
+	p.Brands = parseTextArrayJSON(brandsJSON)
+	p.PerfumeIDs = parseTextArrayJSON(idsJSON)
+	return p, nil
+}
+
+func promoToResponse(p promoCode) PromoCode {
+	out := PromoCode{
+		ID:             p.ID,
+		Code:           p.Code,
+		Description:    p.Description,
+		Kind:           p.Kind,
+		Amount:         p.Amount,
+		MinTotal:       p.MinTotal,
+		MaxUses:        p.MaxUses,
+		MaxUsesPerUser: p.MaxUsesPerUser,
+		UsedCount:      p.UsedCount,
+		CatalogMode:    p.CatalogMode,
+		Brands:         p.Brands,
+		PerfumeIDs:     p.PerfumeIDs,
+		IsActive:       p.IsActive,
+		CreatedAt:      p.CreatedAt.UTC().Format(time.RFC3339),
+		UpdatedAt:      p.UpdatedAt.UTC().Format(time.RFC3339),
+	}
+	if p.StartsAt.Valid {
+		out.StartsAt = p.StartsAt.Time.UTC().Format(time.RFC3339)
+	}
+	if p.EndsAt.Valid {
+		out.EndsAt = p.EndsAt.Time.UTC().Format(time.RFC3339)
+	}
+	return out
+}
+
+// appliesTo reports whether the promo scoping (catalog mode, brands,
+// perfume ids) covers the line. Empty scopes match everything.
+func (p promoCode) appliesTo(line cartLine) bool {
+	if p.CatalogMode != "" && p.CatalogMode != line.CatalogMode {
+		return false
+	}
+	if len(p.Brands) > 0 {
+		matched := false
+		for _, b := range p.Brands {
+			if strings.EqualFold(strings.TrimSpace(b), strings.TrimSpace(line.Brand)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(p.PerfumeIDs) > 0 {
+		matched := false
+		for _, id := range p.PerfumeIDs {
+			if id == line.ID {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// evaluate checks validity windows, global limits and the minimum total and
+// returns the discount spread over the eligible lines. Per-user limits need
+// the database and are checked by the caller.
+func (p promoCode) evaluate(lines []cartLine, now time.Time) (OrderDiscount, error) {
+	if !p.IsActive {
+		return OrderDiscount{}, errPromoInactive
+	}
+	if p.StartsAt.Valid && now.Before(p.StartsAt.Time) {
+		return OrderDiscount{}, errPromoNotStarted
+	}
+	if p.EndsAt.Valid && !now.Before(p.EndsAt.Time) {
+		return OrderDiscount{}, errPromoExpired
+	}
+	if p.MaxUses != nil && p.UsedCount >= *p.MaxUses {
+		return OrderDiscount{}, errPromoExhausted
+	}
+
+	var subtotal, eligibleTotal float64
+	eligible := make([]cartLine, 0, len(lines))
+	for _, line := range lines {
+		lineTotal := line.Price * float64(line.Qty)
+		subtotal += lineTotal
+		if p.appliesTo(line) && lineTotal > 0 {
+			eligible = append(eligible, line)
+			eligibleTotal += lineTotal
+		}
+	}
+	if subtotal < p.MinTotal {
+		return OrderDiscount{}, errPromoMinTotal
+	}
+	if eligibleTotal <= 0 {
+		return OrderDiscount{}, errPromoNotApplicable
+	}
+
+	var amount float64
+	switch p.Kind {
+	case "percent":
+		amount = eligibleTotal * p.Amount / 100
+	case "fixed":
+		amount = p.Amount
+	}
+	amount = roundMoney(math.Min(amount, eligibleTotal))
+	if amount <= 0 {
+		return OrderDiscount{}, errPromoNotApplicable
+	}
+
+	discount := OrderDiscount{
+		Code:   p.Code,
+		Kind:   p.Kind,
+		Value:  p.Amount,
+		Amount: amount,
+		Items:  make([]OrderItemDiscount, 0, len(eligible)),
+	}
+	// Spread proportionally; the last line absorbs rounding so the parts
+	// always add up to the total.
+	remaining := amount
+	for i, line := range eligible {
+		part := remaining
+		if i < len(eligible)-1 {
+			part = roundMoney(amount * line.Price * float64(line.Qty) / eligibleTotal)
+			remaining = roundMoney(remaining - part)
+		}
+		discount.Items = append(discount.Items, OrderItemDiscount{ID: line.ID, Amount: part})
+	}
+	return discount, nil
+}
+
+func roundMoney(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// promoUserKey identifies the customer for per-user limits. Guests get a new
+// id with every session, so their phone number is used instead.
+func promoUserKey(user authUser, phone string) string {
+	if !user.IsAnonymous {
+		return "user:" + user.ID
+	}
+	digits := make([]rune, 0, len(phone))
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits = append(digits, r)
+		}
+	}
+	if len(digits) > 0 {
+		return "phone:" + string(digits)
+	}
+	return "guest:" + user.ID
+}
+
+func loadPromo(q dbQueryer, code string, forUpdate bool) (promoCode, error) {
+	query := `SELECT ` + promoColumns + ` FROM promo_codes WHERE code = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+	p, err := scanPromo(q.QueryRow(query, code))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return promoCode{}, errPromoNotFound
+		}
+		return promoCode{}, err
+	}
+	return p, nil
+}
+
+// loadCartLines prices the items at their variant, the same way orders are
+// priced. Unknown perfumes and variants are left out.
+func loadCartLines(q dbQueryer, items []OrderItem) ([]cartLine, error) {
+	lines := make([]cartLine, 0, len(items))
+	for _, item := range items {
+		if item.ID == "" || item.Qty <= 0 {
+			continue
+		}
+		v, err := resolveOrderVariant(q, item)
+		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errInvalidVariant) || errors.Is(err, errUnknownVariant) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, cartLine{
+			ID:          item.ID,
+			Brand:       v.brand,
+			Name:        v.name,
+			CatalogMode: v.catalogMode,
+			Price:       v.Price,
+			Qty:         item.Qty,
+		})
+	}
+	return lines, nil
+}
+
+// applyPromo resolves a code against the priced items for a customer. With
+// lock set the promo row is locked until the surrounding transaction ends so
+// concurrent orders cannot exceed the usage limits.
+func applyPromo(q dbQueryer, code, userKey string, lines []cartLine, now time.Time, lock bool) (promoCode, OrderDiscount, error) {
+	promo, err := loadPromo(q, code, lock)
+	if err != nil {
+		return promoCode{}, OrderDiscount{}, err
+	}
+	discount, err := promo.evaluate(lines, now)
+	if err != nil {
+		return promoCode{}, OrderDiscount{}, err
+	}
+	if promo.MaxUsesPerUser != nil {
+		var used int
+		if err := q.QueryRow(`
+			SELECT COUNT(*) FROM promo_redemptions WHERE promo_id = $1 AND user_key = $2
+		`, promo.ID, userKey).Scan(&used); err != nil {
+			return promoCode{}, OrderDiscount{}, err
+		}
+		if used >= *promo.MaxUsesPerUser {
+			return promoCode{}, OrderDiscount{}, errPromoUserLimit
+		}
+	}
+	return promo, discount, nil
+}
+
+func redeemPromo(tx *sql.Tx, promo promoCode, orderID, userKey string, amount float64) error {
+	if _, err := tx.Exec(`
+		INSERT INTO promo_redemptions (promo_id, order_id, user_key, discount, created_at)
+		VALUES ($1,$2,$3,$4,now())
+	`, promo.ID, orderID, userKey, amount); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`UPDATE promo_codes SET used_count = used_count + 1 WHERE id = $1`, promo.ID)
+	return err
+}
+
+func isPromoError(err error) bool {
+	switch {
+	case errors.Is(err, errPromoNotFound),
+		errors.Is(err, errPromoInactive),
+		errors.Is(err, errPromoNotStarted),
+		errors.Is(err, errPromoExpired),
+		errors.Is(err, errPromoExhausted),
+		errors.Is(err, errPromoUserLimit),
+		errors.Is(err, errPromoMinTotal),
+		errors.Is(err, errPromoNotApplicable):
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) handleValidatePromo(w http.ResponseWriter, r *http.Request) {
+	userCtx, ok := authUserFrom(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	var req validatePromoRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	code := strings.TrimSpace(req.Code)
+	if code == "" {
+		writeError(w, http.StatusBadRequest, "missing code")
+		return
+	}
+
+	lines, err := loadCartLines(s.db, req.Items)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load perfumes")
+		return
+	}
+	var subtotal float64
+	for _, line := range lines {
+		subtotal += line.Price * float64(line.Qty)
+	}
+	subtotal = roundMoney(subtotal)
+
+	userKey := promoUserKey(userCtx, strings.TrimSpace(req.Contact.Phone))
+	_, discount, err := applyPromo(s.db, code, userKey, lines, time.Now(), false)
+	if err != nil {
+		if isPromoError(err) {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"valid":    false,
+				"code":     code,
+				"error":    err.Error(),
+				"subtotal": subtotal,
+				"discount": 0,
+				"total":    subtotal,
+			})
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "cannot validate promo")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"valid":     true,
+		"code":      discount.Code,
+		"subtotal":  subtotal,
+		"discount":  discount.Amount,
+		"total":     roundMoney(subtotal - discount.Amount),
+		"discounts": []OrderDiscount{discount},
+	})
+}
+
+func (s *Server) handleListPromos(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.Query(`SELECT ` + promoColumns + ` FROM promo_codes ORDER BY created_at DESC`)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load promos")
+		return
+	}
+	defer rows.Close()
+	list := []PromoCode{}
+	for rows.Next() {
+		p, err := scanPromo(rows)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot parse promos")
+			return
+		}
+		list = append(list, promoToResponse(p))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleUpsertPromo(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var body promoPayload
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	code := strings.TrimSpace(body.Code)
+	if code == "" {
+		writeError(w, http.StatusBadRequest, "missing code")
+		return
+	}
+	kind := strings.TrimSpace(body.Kind)
+	if kind == "" {
+		kind = "percent"
+	}
+	if kind != "percent" && kind != "fixed" {
+		writeError(w, http.StatusBadRequest, "invalid kind")
+		return
+	}
+	if body.Amount <= 0 || (kind == "percent" && body.Amount > 100) {
+		writeError(w, http.StatusBadRequest, "invalid amount")
+		return
+	}
+	if body.MinTotal < 0 {
+		writeError(w, http.StatusBadRequest, "invalid min total")
+		return
+	}
+	if (body.MaxUses != nil && *body.MaxUses < 0) || (body.MaxUsesPerUser != nil && *body.MaxUsesPerUser < 0) {
+		writeError(w, http.StatusBadRequest, "invalid usage limit")
+		return
+	}
+	mode := strings.TrimSpace(body.CatalogMode)
+	if mode != "" && mode != "retail" && mode != "wholesale" {
+		writeError(w, http.StatusBadRequest, "invalid catalog mode")
+		return
+	}
+	startsAt, err := parseOptionalTime(body.StartsAt)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid startsAt")
+		return
+	}
+	endsAt, err := parseOptionalTime(body.EndsAt)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid endsAt")
+		return
+	}
+	if startsAt.Valid && endsAt.Valid && !endsAt.Time.After(startsAt.Time) {
+		writeError(w, http.StatusBadRequest, "endsAt must be after startsAt")
+		return
+	}
+	isActive := true
+	if body.IsActive != nil {
+		isActive = *body.IsActive
+	}
+	brands := splitQueryList(strings.Join(body.Brands, ","))
+	perfumeIDs := splitQueryList(strings.Join(body.PerfumeIDs, ","))
+
+	args := []interface{}{
+		code, strings.TrimSpace(body.Description), kind, body.Amount, body.MinTotal, body.MaxUses, body.MaxUsesPerUser,
+		startsAt, endsAt, mode, pgtype.FlatArray[string](brands), pgtype.FlatArray[string](perfumeIDs), isActive,
+	}
+	if id == "" {
+		err = s.db.QueryRow(`
+			INSERT INTO promo_codes (
+				code, description, kind, amount, min_total, max_uses, max_uses_per_user,
+				starts_at, ends_at, catalog_mode, brands, perfume_ids, is_active, created_at, updated_at
+			) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,now(),now())
+			RETURNING id
+		`, args...).Scan(&id)
+	} else {
+		var res sql.Result
+		res, err = s.db.Exec(`
+			UPDATE promo_codes SET
+				code=$1, description=$2, kind=$3, amount=$4, min_total=$5, max_uses=$6, max_uses_per_user=$7,
+				starts_at=$8, ends_at=$9, catalog_mode=$10, brands=$11, perfume_ids=$12, is_active=$13,
+				updated_at=now()
+			WHERE id=$14
+		`, append(args, id)...)
+		if err == nil {
+			if n, _ := res.RowsAffected(); n == 0 {
+				writeError(w, http.StatusNotFound, "not found")
+				return
+			}
+		}
+	}
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			writeError(w, http.StatusConflict, "code already exists")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "cannot save promo")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"id": id})
+}
+
+func (s *Server) handleDeletePromo(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "missing id")
+		return
+	}
+	res, err := s.db.Exec(`DELETE FROM promo_codes WHERE id=$1`, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot delete promo")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func parseOptionalTime(raw string) (sql.NullTime, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return sql.NullTime{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	return sql.NullTime{Time: t, Valid: true}, nil
+}
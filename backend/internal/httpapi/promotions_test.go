@@ -0,0 +1,64 @@
+package httpapi
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPromoEvaluateScopesAndSplitsDiscount(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	promo := promoCode{
+		Code:        "SPRING",
+		Kind:        "percent",
+		Amount:      10,
+		MinTotal:    100,
+		CatalogMode: "retail",
+		Brands:      []string{"Tom Ford"},
+		IsActive:    true,
+		StartsAt:    sql.NullTime{Time: now.Add(-time.Hour), Valid: true},
+		EndsAt:      sql.NullTime{Time: now.Add(time.Hour), Valid: true},
+	}
+	lines := []cartLine{
+		{ID: "p1", Brand: "tom ford", CatalogMode: "retail", Price: 33.33, Qty: 1},
+		{ID: "p2", Brand: "Tom Ford", CatalogMode: "retail", Price: 66.67, Qty: 2},
+		{ID: "p3", Brand: "Chanel", CatalogMode: "retail", Price: 50, Qty: 1},
+	}
+
+	discount, err := promo.evaluate(lines, now)
+	if err != nil {
+		t.Fatalf("evaluate: %v", err)
+	}
+	if discount.Amount != 16.67 {
+		t.Fatalf("expected discount 16.67, got %v", discount.Amount)
+	}
+	if len(discount.Items) != 2 {
+		t.Fatalf("expected 2 discounted items, got %#v", discount.Items)
+	}
+	if sum := roundMoney(discount.Items[0].Amount + discount.Items[1].Amount); sum != discount.Amount {
+		t.Fatalf("item discounts %v do not add up to %v", sum, discount.Amount)
+	}
+}
+
+func TestPromoEvaluateRejections(t *testing.T) {
+	now := time.Now()
+	maxUses := 1
+	lines := []cartLine{{ID: "p1", Brand: "Chanel", CatalogMode: "retail", Price: 50, Qty: 1}}
+	cases := []struct {
+		name  string
+		promo promoCode
+		want  error
+	}{
+		{"inactive", promoCode{Kind: "fixed", Amount: 5}, errPromoInactive},
+		{"expired", promoCode{Kind: "fixed", Amount: 5, IsActive: true, EndsAt: sql.NullTime{Time: now.Add(-time.Minute), Valid: true}}, errPromoExpired},
+		{"exhausted", promoCode{Kind: "fixed", Amount: 5, IsActive: true, MaxUses: &maxUses, UsedCount: 1}, errPromoExhausted},
+		{"min total", promoCode{Kind: "fixed", Amount: 5, IsActive: true, MinTotal: 100}, errPromoMinTotal},
+		{"wholesale only", promoCode{Kind: "fixed", Amount: 5, IsActive: true, CatalogMode: "wholesale"}, errPromoNotApplicable},
+	}
+	for _, tc := range cases {
+		if _, err := tc.promo.evaluate(lines, now); !errors.Is(err, tc.want) {
+			t.Fatalf("%s: expected %v, got %v", tc.name, tc.want, err)
+		}
+	}
+}
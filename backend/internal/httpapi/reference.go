@@ -0,0 +1,542 @@
+package httpapi
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// referenceKind is one of the brands, families and notes dictionaries and
+// the perfumes columns holding its canonical names.
+type referenceKind struct {
+	table   string
+	label   string
+	columns []string
+}
+
+var referenceKinds = map[string]referenceKind{
+	"brands":   {table: "brands", label: "brand", columns: []string{"brand"}},
+	"families": {table: "families", label: "family", columns: []string{"family"}},
+	"notes":    {table: "notes", label: "note", columns: []string{"notes_top", "notes_heart", "notes_base"}},
+}
+
+func (k referenceKind) isList() bool {
+	return len(k.columns) > 1
+}
+
+// usesSQL is the condition for a perfume using the entry whose name is
+// nameExpr.
+func (k referenceKind) usesSQL(nameExpr string) string {
+	if k.isList() {
+		return nameExpr + " = ANY(" + strings.Join(k.columns, " || ") + ")"
+	}
+	return k.columns[0] + " = " + nameExpr
+}
+
+// usageSQL counts the perfumes using the entry whose name is nameExpr.
+func (k referenceKind) usageSQL(nameExpr string) string {
+	return "(SELECT COUNT(*) FROM perfumes WHERE " + k.usesSQL(nameExpr) + ")"
+}
+
+// canonicalizeSQL rewrites perfumes that spell entry $1 by one of its
+// aliases to its canonical name.
+func (k referenceKind) canonicalizeSQL() string {
+	if k.isList() {
+		sets := make([]string, 0, len(k.columns))
+		for _, c := range k.columns {
+			sets = append(sets, c+" = canonical_notes("+c+")")
+		}
+		return `
+			UPDATE perfumes SET ` + strings.Join(sets, ", ") + `, updated_at = now()
+			WHERE EXISTS (
+				SELECT 1 FROM ` + k.table + ` r, unnest(` + strings.Join(k.columns, " || ") + `) v
+				WHERE r.id = $1 AND reference_key(v) = ANY(r.match_keys) AND v <> r.name
+			)`
+	}
+	c := k.columns[0]
+	return `
+		UPDATE perfumes p SET ` + c + ` = r.name, updated_at = now()
+		FROM ` + k.table + ` r
+		WHERE r.id = $1 AND reference_key(p.` + c + `) = ANY(r.match_keys) AND p.` + c + ` <> r.name`
+}
+
+type referencePayload struct {
+	Slug    string   `json:"slug"`
+	Name    string   `json:"name"`
+	NameRu  string   `json:"nameRu"`
+	NameEn  string   `json:"nameEn"`
+	Aliases []string `json:"aliases"`
+}
+
+var referenceSlugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+var referenceSlugSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+
+// referenceKey mirrors the reference_key SQL function.
+func referenceKey(s string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(s)), "ё", "е")
+}
+
+// referenceSlug mirrors the reference_slug SQL function.
+func referenceSlug(s string) string {
+	return strings.Trim(referenceSlugSeparators.ReplaceAllString(translitRuToLat(referenceKey(s)), "-"), "-")
+}
+
+func referenceFromURL(w http.ResponseWriter, r *http.Request) (referenceKind, bool) {
+	kind, ok := referenceKinds[chi.URLParam(r, "kind")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown reference kind")
+	}
+	return kind, ok
+}
+
+// unknownReferenceError reports a perfume value missing from a dictionary.
+type unknownReferenceError struct {
+	label string
+	value string
+}
+
+func (e unknownReferenceError) Error() string {
+	return "unknown " + e.label + " " + strconv.Quote(e.value)
+}
+
+// resolveReferences maps each value to the canonical name of its dictionary
+// entry, matching names and aliases case- and ё-insensitively. Blank values
+// are ignored; a value without an entry is an unknownReferenceError.
+func resolveReferences(q dbQueryer, kind referenceKind, values []string) (map[string]string, error) {
+	names := map[string]string{}
+	var lookup []string
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			lookup = append(lookup, v)
+		}
+	}
+	if len(lookup) == 0 {
+		return names, nil
+	}
+	rows, err := q.Query(`
+		SELECT DISTINCT ON (t.i) t.v, r.name
+		FROM unnest($1::text[]) WITH ORDINALITY AS t(v, i)
+		LEFT JOIN `+kind.table+` r ON reference_key(t.v) = ANY(r.match_keys)
+		ORDER BY t.i, r.name
+	`, pgtype.FlatArray[string](lookup))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			value string
+			name  sql.NullString
+		)
+		if err := rows.Scan(&value, &name); err != nil {
+			return nil, err
+		}
+		if !name.Valid {
+			return nil, unknownReferenceError{label: kind.label, value: strings.TrimSpace(value)}
+		}
+		names[value] = name.String
+	}
+	return names, rows.Err()
+}
+
+// canonicalList replaces values by their canonical names, dropping blanks
+// and duplicates.
+func canonicalList(values []string, names map[string]string) []string {
+	out := make([]string, 0, len(values))
+	seen := map[string]bool{}
+	for _, v := range values {
+		name, ok := names[v]
+		if !ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		out = append(out, name)
+	}
+	return out
+}
+
+// canonicalizePerfume checks brand, family and notes of p against the
+// dictionaries and replaces them by their canonical names.
+func canonicalizePerfume(q dbQueryer, p *perfumePayload) error {
+	brands, err := resolveReferences(q, referenceKinds["brands"], []string{p.Brand})
+	if err != nil {
+		return err
+	}
+	families, err := resolveReferences(q, referenceKinds["families"], []string{p.Family})
+	if err != nil {
+		return err
+	}
+	var all []string
+	all = append(append(append(all, p.Notes.Top...), p.Notes.Heart...), p.Notes.Base...)
+	notes, err := resolveReferences(q, referenceKinds["notes"], all)
+	if err != nil {
+		return err
+	}
+	p.Brand = brands[p.Brand]
+	p.Family = families[p.Family]
+	p.Notes.Top = canonicalList(p.Notes.Top, notes)
+	p.Notes.Heart = canonicalList(p.Notes.Heart, notes)
+	p.Notes.Base = canonicalList(p.Notes.Base, notes)
+	return nil
+}
+
+// createReferences adds a dictionary entry named after each value no entry
+// matches yet. A slug already taken gets a numeric suffix, as the entries
+// seeded by migration 024 did.
+func createReferences(q dbQueryer, kind referenceKind, values []string) error {
+	for _, v := range values {
+		name := strings.TrimSpace(v)
+		if name == "" {
+			continue
+		}
+		slug := referenceSlug(name)
+		if slug == "" {
+			slug = kind.label
+		}
+		_, err := q.Exec(`
+			INSERT INTO `+kind.table+` (slug, name, created_at, updated_at)
+			SELECT CASE WHEN EXISTS (SELECT 1 FROM `+kind.table+` WHERE slug = $2)
+			            THEN $2 || '-' || ((SELECT count(*) FROM `+kind.table+` WHERE slug ~ ('^' || $2 || '-[0-9]+$')) + 2)
+			            ELSE $2 END,
+			       $1, now(), now()
+			WHERE NOT EXISTS (SELECT 1 FROM `+kind.table+` WHERE reference_key($1) = ANY(match_keys))
+		`, name, slug)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createPerfumeReferences adds the brand, family and notes of p missing from
+// the dictionaries, so canonicalizePerfume accepts them.
+func createPerfumeReferences(q dbQueryer, p perfumePayload) error {
+	if err := createReferences(q, referenceKinds["brands"], []string{p.Brand}); err != nil {
+		return err
+	}
+	if err := createReferences(q, referenceKinds["families"], []string{p.Family}); err != nil {
+		return err
+	}
+	var all []string
+	all = append(append(append(all, p.Notes.Top...), p.Notes.Heart...), p.Notes.Base...)
+	return createReferences(q, referenceKinds["notes"], all)
+}
+
+// perfumeReferenceFields are the patchable perfume fields holding
+// dictionary names.
+var perfumeReferenceFields = map[string]string{
+	"brand":       "brands",
+	"family":      "families",
+	"notes.top":   "notes",
+	"notes.heart": "notes",
+	"notes.base":  "notes",
+}
+
+// canonicalizeField is canonicalizePerfume for a decoded patch value.
+func canonicalizeField(q dbQueryer, kind referenceKind, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		names, err := resolveReferences(q, kind, []string{v})
+		if err != nil {
+			return nil, err
+		}
+		return names[v], nil
+	case pgtype.FlatArray[string]:
+		names, err := resolveReferences(q, kind, v)
+		if err != nil {
+			return nil, err
+		}
+		return pgtype.FlatArray[string](canonicalList(v, names)), nil
+	}
+	return value, nil
+}
+
+// writeReferenceError answers a failed dictionary check.
+func writeReferenceError(w http.ResponseWriter, err error) {
+	var unknown unknownReferenceError
+	if errors.As(err, &unknown) {
+		writeError(w, http.StatusBadRequest, unknown.Error())
+		return
+	}
+	writeError(w, http.StatusInternalServerError, "cannot check reference data")
+}
+
+const referenceColumns = `id, slug, name, name_ru, name_en, to_json(aliases), title, description, hero_image, created_at, updated_at`
+
+func scanReferenceEntry(row interface{ Scan(...interface{}) error }, extra ...interface{}) (ReferenceEntry, error) {
+	var (
+		e                    ReferenceEntry
+		aliases              []byte
+		createdAt, updatedAt time.Time
+	)
+	dest := append([]interface{}{&e.ID, &e.Slug, &e.Name, &e.NameRu, &e.NameEn, &aliases, &e.Title, &e.Description, &e.HeroImage, &createdAt, &updatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return e, err
+	}
+	e.Aliases = parseTextArrayJSON(aliases)
+	e.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+	e.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
+	return e, nil
+}
+
+// handleListReference lists a dictionary with the number of perfumes using
+// each entry; ?q= filters by name, alias or slug.
+func (s *Server) handleListReference(w http.ResponseWriter, r *http.Request) {
+	kind, ok := referenceFromURL(w, r)
+	if !ok {
+		return
+	}
+	q := referenceKey(r.URL.Query().Get("q"))
+	rows, err := s.db.Query(`
+		SELECT `+referenceColumns+`, `+kind.usageSQL("name")+`
+		FROM `+kind.table+`
+		WHERE $1 = '' OR slug LIKE $2 OR EXISTS (SELECT 1 FROM unnest(match_keys) k WHERE k LIKE $2)
+		ORDER BY reference_key(name)
+	`, q, "%"+likePrefix(q))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load "+kind.table)
+		return
+	}
+	defer rows.Close()
+	list := []ReferenceEntry{}
+	for rows.Next() {
+		var count int
+		e, err := scanReferenceEntry(rows, &count)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot parse "+kind.table)
+			return
+		}
+		e.PerfumeCount = count
+		list = append(list, e)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleUpsertReference creates or updates a dictionary entry and rewrites
+// perfumes spelling it by one of its aliases. A renamed entry keeps its old
+// name as an alias, so perfumes and links using it follow the rename.
+func (s *Server) handleUpsertReference(w http.ResponseWriter, r *http.Request) {
+	kind, ok := referenceFromURL(w, r)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(r, "id")
+	var body referencePayload
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "missing name")
+		return
+	}
+	slug := strings.TrimSpace(body.Slug)
+	if slug == "" {
+		slug = referenceSlug(name)
+	}
+	if !referenceSlugPattern.MatchString(slug) {
+		writeError(w, http.StatusBadRequest, "invalid slug")
+		return
+	}
+	aliases := splitQueryList(strings.Join(body.Aliases, ","))
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot save "+kind.label)
+		return
+	}
+	defer tx.Rollback()
+
+	if id != "" {
+		var oldName string
+		err := tx.QueryRow(`SELECT name FROM `+kind.table+` WHERE id = $1 FOR UPDATE`, id).Scan(&oldName)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot save "+kind.label)
+			return
+		}
+		if referenceKey(oldName) != referenceKey(name) {
+			aliases = append(aliases, oldName)
+		}
+	}
+
+	var conflict string
+	err = tx.QueryRow(`
+		SELECT name FROM `+kind.table+`
+		WHERE id IS DISTINCT FROM $1::uuid AND match_keys && reference_keys($2, $3, $4, $5)
+		LIMIT 1
+	`, nullIfEmpty(id), name, strings.TrimSpace(body.NameRu), strings.TrimSpace(body.NameEn), pgtype.FlatArray[string](aliases)).Scan(&conflict)
+	if err == nil {
+		writeError(w, http.StatusConflict, "name or alias already used by "+kind.label+" "+strconv.Quote(conflict))
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusInternalServerError, "cannot save "+kind.label)
+		return
+	}
+
+	args := []interface{}{slug, name, strings.TrimSpace(body.NameRu), strings.TrimSpace(body.NameEn), pgtype.FlatArray[string](aliases)}
+	if id == "" {
+		err = tx.QueryRow(`
+			INSERT INTO `+kind.table+` (slug, name, name_ru, name_en, aliases, created_at, updated_at)
+			VALUES ($1,$2,$3,$4,$5,now(),now())
+			RETURNING id
+		`, args...).Scan(&id)
+	} else {
+		_, err = tx.Exec(`
+			UPDATE `+kind.table+` SET slug=$1, name=$2, name_ru=$3, name_en=$4, aliases=$5, updated_at=now()
+			WHERE id=$6
+		`, append(args, id)...)
+	}
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			writeError(w, http.StatusConflict, kind.label+" already exists")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "cannot save "+kind.label)
+		return
+	}
+
+	res, err := tx.Exec(kind.canonicalizeSQL(), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot update perfumes")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot save "+kind.label)
+		return
+	}
+	updated, _ := res.RowsAffected()
+	if updated > 0 {
+		s.catalogChanged(r.Context())
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": id, "slug": slug, "perfumesUpdated": updated})
+}
+
+// handleDeleteReference removes an unused dictionary entry; entries still
+// used by perfumes have to be merged into another one instead.
+func (s *Server) handleDeleteReference(w http.ResponseWriter, r *http.Request) {
+	kind, ok := referenceFromURL(w, r)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(r, "id")
+	var used int
+	err := s.db.QueryRow(`SELECT `+kind.usageSQL("name")+` FROM `+kind.table+` WHERE id = $1`, id).Scan(&used)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot delete "+kind.label)
+		return
+	}
+	if used > 0 {
+		writeError(w, http.StatusConflict, kind.label+" is used by "+itoa(used)+" perfumes, merge it instead")
+		return
+	}
+	if _, err := s.db.Exec(`DELETE FROM `+kind.table+` WHERE id = $1`, id); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot delete "+kind.label)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleMergeReference folds entry {id} into the entry given as "into": its
+// names and aliases become aliases of the target, perfumes are rewritten to
+// the target name and the entry is deleted.
+func (s *Server) handleMergeReference(w http.ResponseWriter, r *http.Request) {
+	kind, ok := referenceFromURL(w, r)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(r, "id")
+	var body struct {
+		Into string `json:"into"`
+	}
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	into := strings.TrimSpace(body.Into)
+	if into == "" {
+		writeError(w, http.StatusBadRequest, "missing into")
+		return
+	}
+	if into == id {
+		writeError(w, http.StatusBadRequest, "cannot merge "+kind.label+" into itself")
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot merge "+kind.label)
+		return
+	}
+	defer tx.Rollback()
+
+	var names []byte
+	err = tx.QueryRow(`
+		DELETE FROM `+kind.table+` WHERE id = $1
+		RETURNING to_json(ARRAY[name, name_ru, name_en] || aliases)
+	`, id).Scan(&names)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot merge "+kind.label)
+		return
+	}
+	res, err := tx.Exec(`
+		UPDATE `+kind.table+` SET
+			aliases = ARRAY(
+				SELECT DISTINCT ON (reference_key(v)) btrim(v)
+				FROM unnest(aliases || $2::text[]) v
+				WHERE reference_key(v) NOT IN ('', reference_key(name))
+				ORDER BY reference_key(v), v
+			),
+			updated_at = now()
+		WHERE id = $1
+	`, into, pgtype.FlatArray[string](parseTextArrayJSON(names)))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot merge "+kind.label)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, "merge target not found")
+		return
+	}
+	res, err = tx.Exec(kind.canonicalizeSQL(), into)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot update perfumes")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot merge "+kind.label)
+		return
+	}
+	updated, _ := res.RowsAffected()
+	s.catalogChanged(r.Context())
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": into, "perfumesUpdated": updated})
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
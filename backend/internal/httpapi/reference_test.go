@@ -0,0 +1,95 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"parfum-backend/internal/app"
+)
+
+func TestReferenceSlug(t *testing.T) {
+	cases := map[string]string{
+		"Tom Ford":           "tom-ford",
+		"  Maison Margiela ": "maison-margiela",
+		"Бергамот":           "bergamot",
+		"Ёлка":               "elka",
+		"Jo Malone / London": "jo-malone-london",
+	}
+	for in, want := range cases {
+		if got := referenceSlug(in); got != want {
+			t.Fatalf("referenceSlug(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestHandleUpsertPerfumeRejectsUnknownNote(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passthroughConverter{}))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("(?s)LEFT JOIN brands r").
+		WithArgs(pgtype.FlatArray[string]{"tom ford"}).
+		WillReturnRows(sqlmock.NewRows([]string{"v", "name"}).AddRow("tom ford", "Tom Ford"))
+	mock.ExpectQuery("(?s)LEFT JOIN notes r").
+		WithArgs(pgtype.FlatArray[string]{"бергамот", "Кожа"}).
+		WillReturnRows(sqlmock.NewRows([]string{"v", "name"}).
+			AddRow("бергамот", "Бергамот").
+			AddRow("Кожа", nil))
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	body := `{"id":"p1","brand":"tom ford","name":"Oud Wood","baseVolume":50,"notes":{"top":["бергамот"],"base":["Кожа"]}}`
+	req := httptest.NewRequest(http.MethodPost, "/api/perfumes", bytes.NewReader([]byte(body)))
+	rec := httptest.NewRecorder()
+	s.handleUpsertPerfume(rec, req)
+
+	if rec.Code != http.StatusBadRequest || rec.Body.String() != "{\"error\":\"unknown note \\\"Кожа\\\"\"}\n" {
+		t.Fatalf("expected unknown note error, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
+
+func TestHandleMergeReferenceRewritesPerfumes(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passthroughConverter{}))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE FROM brands WHERE id = \\$1").
+		WithArgs("b2").
+		WillReturnRows(sqlmock.NewRows([]string{"names"}).AddRow([]byte(`["Tom ford","","","TF"]`)))
+	mock.ExpectExec("(?s)UPDATE brands SET\\s+aliases = ARRAY").
+		WithArgs("b1", pgtype.FlatArray[string]{"Tom ford", "", "", "TF"}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("(?s)UPDATE perfumes p SET brand = r.name, updated_at = now\\(\\)\\s+FROM brands r").
+		WithArgs("b1").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/reference/brands/b2/merge", bytes.NewReader([]byte(`{"into":"b1"}`)))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("kind", "brands")
+	rctx.URLParams.Add("id", "b2")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	rec := httptest.NewRecorder()
+	s.handleMergeReference(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "{\"id\":\"b1\",\"perfumesUpdated\":3}\n" {
+		t.Fatalf("unexpected response %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
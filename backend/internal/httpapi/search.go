@@ -0,0 +1,172 @@
+package httpapi
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"unicode"
+)
+
+// ruToLat mirrors the perfume_translit SQL function.
+var ruToLat = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "sch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya",
+}
+
+// latToRu lists multi-letter combinations before single letters.
+var latToRu = []struct{ lat, ru string }{
+	{"sch", "щ"}, {"sh", "ш"}, {"ch", "ш"}, {"zh", "ж"}, {"kh", "х"}, {"ts", "ц"},
+	{"yu", "ю"}, {"ya", "я"}, {"yo", "ё"},
+	{"a", "а"}, {"b", "б"}, {"c", "к"}, {"d", "д"}, {"e", "е"}, {"f", "ф"}, {"g", "г"},
+	{"h", "х"}, {"i", "и"}, {"j", "ж"}, {"k", "к"}, {"l", "л"}, {"m", "м"}, {"n", "н"},
+	{"o", "о"}, {"p", "п"}, {"q", "к"}, {"r", "р"}, {"s", "с"}, {"t", "т"}, {"u", "у"},
+	{"v", "в"}, {"w", "в"}, {"x", "кс"}, {"y", "и"}, {"z", "з"},
+}
+
+func translitRuToLat(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if lat, ok := ruToLat[r]; ok {
+			b.WriteString(lat)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// translitLatToRu is a rough reverse mapping; brand names are often French,
+// so "ch" becomes "ш" as in "Chanel" → "шанел".
+func translitLatToRu(s string) string {
+	s = strings.ToLower(s)
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		matched := false
+		for _, m := range latToRu {
+			if strings.HasPrefix(s[i:], m.lat) {
+				b.WriteString(m.ru)
+				i += len(m.lat)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			b.WriteByte(s[i])
+			i++
+		}
+	}
+	return b.String()
+}
+
+// searchVariants returns the query as typed plus its transliterations,
+// without duplicates.
+func searchVariants(q string) []string {
+	q = strings.ToLower(strings.Join(strings.Fields(q), " "))
+	out := []string{q}
+	for _, v := range []string{translitRuToLat(q), translitLatToRu(q)} {
+		seen := false
+		for _, o := range out {
+			if o == v {
+				seen = true
+				break
+			}
+		}
+		if !seen && v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// prefixTSQuery turns free text into a to_tsquery expression where every
+// word is a prefix match ("шан дио" → "шан:* & дио:*"). Everything except
+// letters and digits is dropped, so the result is always valid syntax.
+func prefixTSQuery(q string) string {
+	words := strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		words[i] = w + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// searchWordSimilarityThreshold is the pg_trgm.word_similarity_threshold a
+// search runs with. 0.4 lets "шанел" find "шанель" and one wrong letter in a
+// brand name; the server default of 0.6 does not.
+const searchWordSimilarityThreshold = "0.4"
+
+// beginPerfumeSearch starts the read-only transaction the queries of a
+// search run in. The threshold of "<%" is a setting rather than an operand,
+// so it is set for this transaction only; a database-level default would
+// need the owner role and would miss connections opened before it was set.
+func beginPerfumeSearch(ctx context.Context, db *sql.DB) (*sql.Tx, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)`, searchWordSimilarityThreshold); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+// perfumeSearch is the SQL for a catalog text search. match goes into the
+// WHERE clause, rank is used by the "relevance" sort and snippet highlights
+// the matched words with <b>…</b>.
+type perfumeSearch struct {
+	match   string
+	rank    string
+	snippet string
+	args    []interface{}
+}
+
+// buildPerfumeSearch builds the search for q with placeholders numbered
+// after the first argOffset arguments.
+func buildPerfumeSearch(q string, argOffset int) perfumeSearch {
+	variants := searchVariants(q)
+	var (
+		search   perfumeSearch
+		tsParts  []string
+		likes    []string
+		simParts []string
+	)
+	next := func(v interface{}) string {
+		search.args = append(search.args, v)
+		return "$" + itoa(argOffset+len(search.args))
+	}
+	for i, v := range variants {
+		if tsq := prefixTSQuery(v); tsq != "" {
+			p := next(tsq)
+			if i == 0 {
+				tsParts = append(tsParts, "to_tsquery('russian', "+p+")")
+			}
+			tsParts = append(tsParts, "to_tsquery('simple', "+p+")")
+		}
+		p := next(v)
+		likes = append(likes, "search_text ILIKE "+next("%"+likePrefix(v)), p+" <% search_text")
+		simParts = append(simParts, "word_similarity("+p+", search_text)")
+	}
+
+	tsq := "to_tsquery('simple', '')"
+	if len(tsParts) > 0 {
+		tsq = "(" + strings.Join(tsParts, " || ") + ")"
+	}
+	similarity := simParts[0]
+	if len(simParts) > 1 {
+		similarity = "GREATEST(" + strings.Join(simParts, ", ") + ")"
+	}
+	// Every branch can use an index: search_vector for the full-text match,
+	// the trigram index on search_text for ILIKE and for <%, whose threshold
+	// beginPerfumeSearch sets, so match must run in its transaction.
+	search.match = "(search_vector @@ " + tsq + " OR " + strings.Join(likes, " OR ") + ")"
+	search.rank = "(ts_rank_cd(search_vector, " + tsq + ", 1) + " + similarity + ")"
+	search.snippet = "ts_headline('russian', concat_ws(' · ', brand || ' ' || name, NULLIF(description, ''), " +
+		"NULLIF(array_to_string(notes_top || notes_heart || notes_base, ', '), '')), " + tsq + ", " +
+		"'StartSel=<b>, StopSel=</b>, MaxWords=18, MinWords=6, MaxFragments=2, FragmentDelimiter=\" … \"')"
+	return search
+}
@@ -0,0 +1,93 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"parfum-backend/internal/app"
+)
+
+func TestSearchVariantsTransliterate(t *testing.T) {
+	cases := map[string][]string{
+		"Шанель":            {"шанель", "shanel"},
+		"chanel":            {"chanel", "шанел"},
+		"  Dior   Sauvage ": {"dior sauvage", "диор сауваге"},
+		"212":               {"212"},
+	}
+	for in, want := range cases {
+		if got := searchVariants(in); !reflect.DeepEqual(got, want) {
+			t.Errorf("searchVariants(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestPrefixTSQuery(t *testing.T) {
+	cases := map[string]string{
+		"шан дио":           "шан:* & дио:*",
+		"tom ford's & (oud": "tom:* & ford:* & s:* & oud:*",
+		" !? ":              "",
+	}
+	for in, want := range cases {
+		if got := prefixTSQuery(in); got != want {
+			t.Errorf("prefixTSQuery(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestBuildPerfumeSearchNumbersArgsAfterOffset(t *testing.T) {
+	search := buildPerfumeSearch("chanel", 2)
+	// tsquery, text and LIKE pattern for "chanel", the same for "шанел".
+	want := []interface{}{"chanel:*", "chanel", "%chanel%", "шанел:*", "шанел", "%шанел%"}
+	if !reflect.DeepEqual(search.args, want) {
+		t.Fatalf("args = %v, want %v", search.args, want)
+	}
+	if !strings.Contains(search.match, "to_tsquery('russian', $3)") || !strings.Contains(search.match, "search_text ILIKE $5") ||
+		!strings.Contains(search.match, "$7 <% search_text") {
+		t.Fatalf("unexpected placeholders in %s", search.match)
+	}
+	if strings.Contains(search.match, "word_similarity") {
+		t.Fatalf("similarity function in the match cannot use the index: %s", search.match)
+	}
+	if strings.Contains(search.match, "$1") || strings.Contains(search.match, "$9") {
+		t.Fatalf("placeholder outside range in %s", search.match)
+	}
+	if !strings.Contains(search.snippet, "StartSel=<b>") {
+		t.Fatalf("snippet does not highlight: %s", search.snippet)
+	}
+}
+
+func TestHandleListPerfumesSearchSetsSimilarityThreshold(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// The threshold is set for the search transaction only, before the
+	// queries that use <%.
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT set_config\\('pg_trgm.word_similarity_threshold', \\$1, true\\)").
+		WithArgs(searchWordSimilarityThreshold).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("(?s)SELECT COUNT\\(\\*\\) FROM perfumes WHERE catalog_mode = \\$1 AND .*<% search_text").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery("(?s)SELECT id, catalog_mode.*<% search_text").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	req := httptest.NewRequest(http.MethodGet, "/api/perfumes?q=шанел", nil)
+	rec := httptest.NewRecorder()
+	s.handleListPerfumes(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
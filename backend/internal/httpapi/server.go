@@ -2,6 +2,7 @@ package httpapi
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -33,6 +34,21 @@ type Server struct {
 	statsEventHits map[string]time.Time
 	authMu    sync.Mutex
 	authHits  map[string]statsWindow
+	carriers  map[string]CarrierTracker
+	similarMu    sync.Mutex
+	similarCache map[string]similarCacheEntry
+	suggestMu      sync.Mutex
+	suggestPending bool
+	suggestDelay   time.Duration
+}
+
+// dbQueryer is satisfied by both *sql.DB and *sql.Tx so helpers can run
+// inside or outside a transaction.
+type dbQueryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
 }
 
 type ctxKey int
@@ -68,6 +84,9 @@ func NewServer(cfg app.Config, db *sql.DB) *Server {
 		statsHits: make(map[string]statsWindow),
 		statsEventHits: make(map[string]time.Time),
 		authHits:  make(map[string]statsWindow),
+		carriers:  make(map[string]CarrierTracker),
+		similarCache: make(map[string]similarCacheEntry),
+		suggestDelay: suggestRefreshDelay,
 	}
 }
 
@@ -94,12 +113,33 @@ func (s *Server) Routes() http.Handler {
 
 	r.Route("/api/perfumes", func(r chi.Router) {
 		r.Get("/", s.handleListPerfumes)
+		r.Get("/suggest", s.handleSuggestPerfumes)
 		r.Get("/{id}", s.handleGetPerfume)
+		r.Get("/{id}/similar", s.handleSimilarPerfumes)
+		r.Get("/{id}/bought-together", s.handleBoughtTogether)
+		r.Get("/{id}/variants", s.handleListVariants)
+		r.Get("/{id}/price-history", s.handlePriceHistory)
 		r.With(s.requireAdmin).Post("/", s.handleUpsertPerfume)
 		r.With(s.requireAdmin).Put("/{id}", s.handleUpsertPerfume)
+		r.With(s.requireAdmin).Patch("/{id}", s.handlePatchPerfume)
 		r.With(s.requireAdmin).Delete("/{id}", s.handleDeletePerfume)
+		r.With(s.requireAdmin).Post("/{id}/variants", s.handleUpsertVariant)
+		r.With(s.requireAdmin).Put("/{id}/variants/{variantId}", s.handleUpsertVariant)
+		r.With(s.requireAdmin).Delete("/{id}/variants/{variantId}", s.handleDeleteVariant)
+		r.With(s.requireAdmin).Get("/{id}/history", s.handlePerfumeHistory)
+		r.With(s.requireAdmin).Get("/{id}/price-rules", s.handleListPriceRules)
+		r.With(s.requireAdmin).Post("/{id}/price-rules", s.handleUpsertPriceRule)
+		r.With(s.requireAdmin).Put("/{id}/price-rules/{ruleId}", s.handleUpsertPriceRule)
+		r.With(s.requireAdmin).Delete("/{id}/price-rules/{ruleId}", s.handleDeletePriceRule)
+		r.With(s.requireAdmin).Post("/{id}/history/{version}/restore", s.handleRestorePerfumeVersion)
 	})
 
+	r.Route("/api/brands", func(r chi.Router) {
+		r.Get("/", s.handleListBrands)
+		r.Get("/{slug}", s.handleBrandLanding)
+	})
+	r.Get("/api/notes/{slug}", s.handleNoteLanding)
+
 	r.Route("/api/uploads", func(r chi.Router) {
 		r.With(s.requireAdmin).Post("/perfumes/{id}", s.handleUploadPerfumeImage)
 	})
@@ -107,8 +147,20 @@ func (s *Server) Routes() http.Handler {
 	r.Route("/api/orders", func(r chi.Router) {
 		r.With(s.requireAuth).Post("/", s.handleCreateOrder)
 		r.With(s.requireAdmin).Get("/", s.handleListOrders)
+		r.With(s.requireAdmin).Get("/export", s.handleExportOrders)
+		r.Get("/{id}", s.handleGetOrder)
+		r.Get("/{id}/invoice.pdf", s.handleOrderInvoice)
+		r.With(s.requireAdmin).Post("/{id}/invoice", s.handleIssueInvoice)
+		r.With(s.requireAdmin).Get("/{id}/packing-slip.pdf", s.handleOrderPackingSlip)
 		r.With(s.requireAdmin).Put("/{id}", s.handleUpdateOrder)
 		r.With(s.requireAdmin).Delete("/{id}", s.handleDeleteOrder)
+		r.With(s.requireAdmin).Post("/{id}/shipments", s.handleCreateShipment)
+	})
+
+	r.Route("/api/shipments", func(r chi.Router) {
+		r.With(s.requireAdmin).Put("/{id}", s.handleUpdateShipment)
+		r.With(s.requireAdmin).Delete("/{id}", s.handleDeleteShipment)
+		r.With(s.requireAdmin).Post("/{id}/events", s.handleAddShipmentEvent)
 	})
 
 	r.Route("/api/users", func(r chi.Router) {
@@ -125,6 +177,7 @@ func (s *Server) Routes() http.Handler {
 	r.Route("/api/cart", func(r chi.Router) {
 		r.With(s.requireAuth).Get("/", s.handleGetCart)
 		r.With(s.requireAuth).Put("/", s.handleSaveCart)
+		r.Get("/recommendations", s.handleCartRecommendations)
 	})
 
 	r.Route("/api/favorites", func(r chi.Router) {
@@ -147,6 +200,45 @@ func (s *Server) Routes() http.Handler {
 		r.With(s.requireAdmin).Delete("/{id}", s.handleDeletePreset)
 	})
 
+	r.Route("/api/promotions", func(r chi.Router) {
+		r.With(s.requireAuth).Post("/validate", s.handleValidatePromo)
+		r.With(s.requireAdmin).Get("/", s.handleListPromos)
+		r.With(s.requireAdmin).Post("/", s.handleUpsertPromo)
+		r.With(s.requireAdmin).Put("/{id}", s.handleUpsertPromo)
+		r.With(s.requireAdmin).Delete("/{id}", s.handleDeletePromo)
+	})
+
+	r.Route("/api/delivery", func(r chi.Router) {
+		r.Get("/methods", s.handleListDeliveryMethods)
+		r.Post("/quote", s.handleDeliveryQuote)
+		r.With(s.requireAdmin).Post("/methods", s.handleUpsertDeliveryMethod)
+		r.With(s.requireAdmin).Put("/methods/{id}", s.handleUpsertDeliveryMethod)
+		r.With(s.requireAdmin).Delete("/methods/{id}", s.handleDeleteDeliveryMethod)
+	})
+
+	r.Route("/api/admin/analytics", func(r chi.Router) {
+		r.With(s.requireAdmin).Get("/revenue", s.handleRevenueAnalytics)
+		r.With(s.requireAdmin).Get("/top-perfumes", s.handleTopPerfumesAnalytics)
+		r.With(s.requireAdmin).Get("/funnel", s.handleFunnelAnalytics)
+	})
+
+	r.Route("/api/admin/reference/{kind}", func(r chi.Router) {
+		r.With(s.requireAdmin).Get("/", s.handleListReference)
+		r.With(s.requireAdmin).Post("/", s.handleUpsertReference)
+		r.With(s.requireAdmin).Put("/{id}", s.handleUpsertReference)
+		r.With(s.requireAdmin).Delete("/{id}", s.handleDeleteReference)
+		r.With(s.requireAdmin).Post("/{id}/merge", s.handleMergeReference)
+		r.With(s.requireAdmin).Put("/{id}/landing", s.handleUpdateLanding)
+	})
+
+	r.Route("/api/admin/catalog", func(r chi.Router) {
+		r.With(s.requireAdmin).Get("/export", s.handleExportCatalog)
+		r.With(s.requireAdmin).Post("/import", s.handleImportCatalog)
+	})
+
+	r.With(s.requireAdmin).Post("/api/admin/popularity/recompute", s.handleRecomputePopularity)
+	r.With(s.requireAdmin).Post("/api/admin/affinity/recompute", s.handleRecomputeAffinity)
+
 	r.Post("/api/stats", s.handleLogStat)
 
 	uploadsPath := http.Dir(s.cfg.UploadDir)
@@ -162,10 +254,11 @@ func (s *Server) cors(next http.Handler) http.Handler {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 			w.Header().Set("Vary", "Origin")
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Set("Access-Control-Expose-Headers", "ETag")
 		}
 		if r.Method == http.MethodOptions {
-			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,DELETE,OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Authorization,Content-Type")
+			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization,Content-Type,Idempotency-Key,If-Match")
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
@@ -0,0 +1,529 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// CarrierTracker fetches the tracking history of a parcel from a carrier.
+// Implementations are registered with Server.RegisterCarrier and queried by
+// the shipment poller for every undelivered shipment with that carrier code.
+type CarrierTracker interface {
+	Code() string
+	Track(ctx context.Context, trackingNumber string) ([]TrackingEvent, error)
+}
+
+type TrackingEvent struct {
+	Status      string
+	Description string
+	Location    string
+	OccurredAt  time.Time
+}
+
+type shipmentPayload struct {
+	Carrier        string `json:"carrier"`
+	TrackingNumber string `json:"trackingNumber"`
+	Status         string `json:"status"`
+	Description    string `json:"description"`
+	Location       string `json:"location"`
+}
+
+type shipmentEventPayload struct {
+	Status      string `json:"status"`
+	Description string `json:"description"`
+	Location    string `json:"location"`
+	OccurredAt  string `json:"occurredAt"`
+}
+
+func isAllowedShipmentStatus(v string) bool {
+	switch v {
+	case "created", "shipped", "in_transit", "out_for_delivery", "delivered", "returned", "lost", "cancelled":
+		return true
+	default:
+		return false
+	}
+}
+
+// RegisterCarrier makes a carrier available to the poller. It must be called
+// before RunShipmentPoller starts.
+func (s *Server) RegisterCarrier(c CarrierTracker) {
+	s.carriers[c.Code()] = c
+}
+
+// RunShipmentPoller polls carriers for undelivered shipments every interval
+// until ctx is cancelled.
+func (s *Server) RunShipmentPoller(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.PollShipments(ctx); err != nil {
+			log.Printf("poll shipments: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PollShipments runs one polling pass over the least recently polled
+// undelivered shipments of registered carriers.
+func (s *Server) PollShipments(ctx context.Context) error {
+	if len(s.carriers) == 0 {
+		return nil
+	}
+	codes := make([]string, 0, len(s.carriers))
+	for code := range s.carriers {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	rows, err := s.db.Query(`
+		SELECT id, carrier, tracking_number
+		FROM shipments
+		WHERE delivered_at IS NULL AND tracking_number <> '' AND carrier = ANY($1)
+		ORDER BY last_polled_at ASC NULLS FIRST
+		LIMIT 100
+	`, pgtype.FlatArray[string](codes))
+	if err != nil {
+		return err
+	}
+	type pending struct {
+		id, carrier, tracking string
+	}
+	var list []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.carrier, &p.tracking); err != nil {
+			rows.Close()
+			return err
+		}
+		list = append(list, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range list {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		events, err := s.carriers[p.carrier].Track(ctx, p.tracking)
+		if err != nil {
+			log.Printf("track %s %s: %v", p.carrier, p.tracking, err)
+			continue
+		}
+		if err := applyShipmentEvents(s.db, p.id, events); err != nil {
+			return err
+		}
+		if _, err := s.db.Exec(`UPDATE shipments SET last_polled_at = now() WHERE id = $1`, p.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyShipmentEvents stores new events (duplicates are ignored) and moves
+// the shipment to the status of the most recent one.
+func applyShipmentEvents(q dbQueryer, shipmentID string, events []TrackingEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	sorted := append([]TrackingEvent{}, events...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].OccurredAt.Before(sorted[j].OccurredAt) })
+
+	for _, ev := range sorted {
+		if _, err := q.Exec(`
+			INSERT INTO shipment_events (shipment_id, status, description, location, occurred_at, created_at)
+			VALUES ($1,$2,$3,$4,$5,now())
+			ON CONFLICT (shipment_id, status, occurred_at) DO NOTHING
+		`, shipmentID, ev.Status, ev.Description, ev.Location, ev.OccurredAt); err != nil {
+			return err
+		}
+	}
+
+	var shippedAt sql.NullTime
+	for _, ev := range sorted {
+		if ev.Status != "created" {
+			shippedAt = sql.NullTime{Time: ev.OccurredAt, Valid: true}
+			break
+		}
+	}
+	latest := sorted[len(sorted)-1]
+	_, err := q.Exec(`
+		UPDATE shipments SET
+			status = $1,
+			shipped_at = COALESCE(shipped_at, $2),
+			delivered_at = CASE WHEN $1 = 'delivered' THEN COALESCE(delivered_at, $3) ELSE delivered_at END,
+			updated_at = now()
+		WHERE id = $4
+	`, latest.Status, shippedAt, latest.OccurredAt, shipmentID)
+	return err
+}
+
+// loadShipments returns the shipments of an order with their events, oldest
+// first.
+func loadShipments(q dbQueryer, orderID string) ([]Shipment, error) {
+	rows, err := q.Query(`
+		SELECT id, order_id, carrier, tracking_number, status, shipped_at, delivered_at, created_at, updated_at
+		FROM shipments
+		WHERE order_id = $1
+		ORDER BY created_at ASC
+	`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	list := []Shipment{}
+	index := make(map[string]int)
+	for rows.Next() {
+		var (
+			sh          Shipment
+			shippedAt   sql.NullTime
+			deliveredAt sql.NullTime
+			createdAt   time.Time
+			updatedAt   time.Time
+		)
+		if err := rows.Scan(&sh.ID, &sh.OrderID, &sh.Carrier, &sh.TrackingNumber, &sh.Status, &shippedAt, &deliveredAt, &createdAt, &updatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if shippedAt.Valid {
+			sh.ShippedAt = shippedAt.Time.UTC().Format(time.RFC3339)
+		}
+		if deliveredAt.Valid {
+			sh.DeliveredAt = deliveredAt.Time.UTC().Format(time.RFC3339)
+		}
+		sh.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		sh.UpdatedAt = updatedAt.UTC().Format(time.RFC3339)
+		sh.Events = []ShipmentEvent{}
+		index[sh.ID] = len(list)
+		list = append(list, sh)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return list, nil
+	}
+
+	eventRows, err := q.Query(`
+		SELECT e.shipment_id, e.status, e.description, e.location, e.occurred_at
+		FROM shipment_events e
+		JOIN shipments s ON s.id = e.shipment_id
+		WHERE s.order_id = $1
+		ORDER BY e.occurred_at ASC
+	`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer eventRows.Close()
+	for eventRows.Next() {
+		var (
+			shipmentID string
+			ev         ShipmentEvent
+			occurredAt time.Time
+		)
+		if err := eventRows.Scan(&shipmentID, &ev.Status, &ev.Description, &ev.Location, &occurredAt); err != nil {
+			return nil, err
+		}
+		ev.OccurredAt = occurredAt.UTC().Format(time.RFC3339)
+		if i, ok := index[shipmentID]; ok {
+			list[i].Events = append(list[i].Events, ev)
+		}
+	}
+	return list, eventRows.Err()
+}
+
+// orderAccessToken lets whoever placed an order open it without an account,
+// which is the only way in for guest orders. It is returned when the order
+// is created and passed back as ?token=.
+func (s *Server) orderAccessToken(orderID string) string {
+	mac := hmac.New(sha256.New, s.jwtSecret)
+	mac.Write([]byte("order:" + orderID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// loadAccessibleOrder loads the order from the {id} URL param and writes an
+// error response unless the caller is an admin, the registered owner or
+// holds the order's access token.
+func (s *Server) loadAccessibleOrder(w http.ResponseWriter, r *http.Request) (Order, bool) {
+	token := strings.TrimSpace(r.URL.Query().Get("token"))
+	userCtx, authed := authUserFrom(r.Context())
+	if !authed {
+		if user, err := s.parseAuth(r); err == nil {
+			userCtx, authed = user, true
+		}
+	}
+	if !authed && token == "" {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return Order{}, false
+	}
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "missing id")
+		return Order{}, false
+	}
+	order, err := scanOrder(s.db.QueryRow(`SELECT `+orderColumns+` FROM orders WHERE id = $1`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "not found")
+			return Order{}, false
+		}
+		writeError(w, http.StatusInternalServerError, "cannot load order")
+		return Order{}, false
+	}
+	if token != "" && hmac.Equal([]byte(token), []byte(s.orderAccessToken(order.ID))) {
+		return order, true
+	}
+	if !authed || (!userCtx.IsAdmin && (order.UserID == "" || order.UserID != userCtx.ID)) {
+		writeError(w, http.StatusNotFound, "not found")
+		return Order{}, false
+	}
+	return order, true
+}
+
+func (s *Server) handleGetOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := s.loadAccessibleOrder(w, r)
+	if !ok {
+		return
+	}
+	var err error
+	order.Shipments, err = loadShipments(s.db, order.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load shipments")
+		return
+	}
+	writeJSON(w, http.StatusOK, order)
+}
+
+func (s *Server) handleCreateShipment(w http.ResponseWriter, r *http.Request) {
+	orderID := chi.URLParam(r, "id")
+	if orderID == "" {
+		writeError(w, http.StatusBadRequest, "missing id")
+		return
+	}
+	var body shipmentPayload
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	status := strings.TrimSpace(body.Status)
+	if status == "" {
+		status = "created"
+	}
+	if !isAllowedShipmentStatus(status) {
+		writeError(w, http.StatusBadRequest, "invalid status")
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	var shipmentID string
+	if err := tx.QueryRow(`
+		INSERT INTO shipments (order_id, carrier, tracking_number, status, created_at, updated_at)
+		SELECT id, $2, $3, 'created', now(), now() FROM orders WHERE id = $1
+		RETURNING id
+	`, orderID, strings.TrimSpace(body.Carrier), strings.TrimSpace(body.TrackingNumber)).Scan(&shipmentID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "cannot create shipment")
+		return
+	}
+	event := TrackingEvent{
+		Status:      status,
+		Description: strings.TrimSpace(body.Description),
+		Location:    strings.TrimSpace(body.Location),
+		OccurredAt:  time.Now().UTC(),
+	}
+	if err := applyShipmentEvents(tx, shipmentID, []TrackingEvent{event}); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot create shipment")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot create shipment")
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"id": shipmentID})
+}
+
+func (s *Server) handleUpdateShipment(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "missing id")
+		return
+	}
+	var body shipmentPayload
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	status := strings.TrimSpace(body.Status)
+	if status != "" && !isAllowedShipmentStatus(status) {
+		writeError(w, http.StatusBadRequest, "invalid status")
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	var currentStatus string
+	if err := tx.QueryRow(`
+		UPDATE shipments SET carrier = $1, tracking_number = $2, updated_at = now()
+		WHERE id = $3
+		RETURNING status
+	`, strings.TrimSpace(body.Carrier), strings.TrimSpace(body.TrackingNumber), id).Scan(&currentStatus); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "cannot update shipment")
+		return
+	}
+	if status != "" && status != currentStatus {
+		event := TrackingEvent{
+			Status:      status,
+			Description: strings.TrimSpace(body.Description),
+			Location:    strings.TrimSpace(body.Location),
+			OccurredAt:  time.Now().UTC(),
+		}
+		if err := applyShipmentEvents(tx, id, []TrackingEvent{event}); err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot update shipment")
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot update shipment")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleAddShipmentEvent(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "missing id")
+		return
+	}
+	var body shipmentEventPayload
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	status := strings.TrimSpace(body.Status)
+	if !isAllowedShipmentStatus(status) {
+		writeError(w, http.StatusBadRequest, "invalid status")
+		return
+	}
+	occurredAt := time.Now().UTC()
+	if v, err := parseOptionalTime(body.OccurredAt); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid occurredAt")
+		return
+	} else if v.Valid {
+		occurredAt = v.Time
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM shipments WHERE id = $1)`, id).Scan(&exists); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load shipment")
+		return
+	}
+	if !exists {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	// Re-apply the full history so an event entered out of order does not
+	// overwrite a newer status.
+	history, err := loadShipmentHistory(tx, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load shipment")
+		return
+	}
+	history = append(history, TrackingEvent{
+		Status:      status,
+		Description: strings.TrimSpace(body.Description),
+		Location:    strings.TrimSpace(body.Location),
+		OccurredAt:  occurredAt,
+	})
+	if err := applyShipmentEvents(tx, id, history); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot save event")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot save event")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func loadShipmentHistory(q dbQueryer, shipmentID string) ([]TrackingEvent, error) {
+	rows, err := q.Query(`
+		SELECT status, description, location, occurred_at
+		FROM shipment_events
+		WHERE shipment_id = $1
+		ORDER BY occurred_at ASC
+	`, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []TrackingEvent
+	for rows.Next() {
+		var ev TrackingEvent
+		if err := rows.Scan(&ev.Status, &ev.Description, &ev.Location, &ev.OccurredAt); err != nil {
+			return nil, err
+		}
+		list = append(list, ev)
+	}
+	return list, rows.Err()
+}
+
+func (s *Server) handleDeleteShipment(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "missing id")
+		return
+	}
+	res, err := s.db.Exec(`DELETE FROM shipments WHERE id=$1`, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot delete shipment")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
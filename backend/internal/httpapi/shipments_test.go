@@ -0,0 +1,118 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"parfum-backend/internal/app"
+)
+
+// fakeCarrier returns canned tracking histories keyed by tracking number.
+type fakeCarrier struct {
+	code    string
+	history map[string][]TrackingEvent
+	calls   []string
+}
+
+func (c *fakeCarrier) Code() string { return c.code }
+
+func (c *fakeCarrier) Track(ctx context.Context, trackingNumber string) ([]TrackingEvent, error) {
+	c.calls = append(c.calls, trackingNumber)
+	return c.history[trackingNumber], nil
+}
+
+func TestPollShipmentsAppliesCarrierEvents(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passthroughConverter{}))
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	shipped := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	delivered := shipped.Add(48 * time.Hour)
+	carrier := &fakeCarrier{
+		code: "fake",
+		history: map[string][]TrackingEvent{
+			"TRK1": {
+				{Status: "delivered", Description: "Вручено", OccurredAt: delivered},
+				{Status: "in_transit", Description: "Принято", OccurredAt: shipped},
+			},
+		},
+	}
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	s.RegisterCarrier(carrier)
+
+	mock.ExpectQuery("(?s)SELECT id, carrier, tracking_number\\s+FROM shipments").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "carrier", "tracking_number"}).AddRow("s1", "fake", "TRK1"))
+	mock.ExpectExec("INSERT INTO shipment_events").
+		WithArgs("s1", "in_transit", "Принято", "", shipped).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO shipment_events").
+		WithArgs("s1", "delivered", "Вручено", "", delivered).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("(?s)UPDATE shipments SET\\s+status = \\$1").
+		WithArgs("delivered", sqlmock.AnyArg(), delivered, "s1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE shipments SET last_polled_at = now\\(\\)").
+		WithArgs("s1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := s.PollShipments(context.Background()); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if len(carrier.calls) != 1 || carrier.calls[0] != "TRK1" {
+		t.Fatalf("unexpected carrier calls: %v", carrier.calls)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
+
+func TestHandleGetOrderGuestAccessToken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	guestOrder := func() {
+		mock.ExpectQuery("(?s)SELECT id, user_id.*FROM orders WHERE id = \\$1").
+			WithArgs("order1").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "is_anonymous", "email", "display_name", "phone", "items",
+				"total", "currency", "channel", "delivery_method", "delivery_address", "fulfilled", "created_at",
+				"subtotal", "discount_total", "promo_code", "discounts", "delivery_fee"}).
+				AddRow("order1", nil, true, "", "", "+79990001122", []byte(`[]`), 100.0, "₽", "whatsapp", "pickup", "", false,
+					time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), 100.0, 0.0, "", []byte(`[]`), 0.0))
+	}
+	get := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/orders/order1?token="+token, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "order1")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rec := httptest.NewRecorder()
+		s.handleGetOrder(rec, req)
+		return rec
+	}
+
+	guestOrder()
+	if rec := get("forged"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a wrong token, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	guestOrder()
+	mock.ExpectQuery("(?s)FROM shipments").
+		WithArgs("order1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	if rec := get(s.orderAccessToken("order1")); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
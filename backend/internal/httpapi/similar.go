@@ -0,0 +1,151 @@
+package httpapi
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Weights of the similarity score; they add up to 1. Notes are compared tier
+// by tier, with the base weighing most because it is what lingers.
+const (
+	similarNotesWeight     = 0.5
+	similarFamilyWeight    = 0.15
+	similarSeasonsWeight   = 0.1
+	similarDayNightWeight  = 0.08
+	similarSillageWeight   = 0.05
+	similarLongevityWeight = 0.05
+	similarPriceWeight     = 0.07
+
+	similarTopNoteWeight   = 1
+	similarHeartNoteWeight = 1.5
+	similarBaseNoteWeight  = 2
+
+	// Prices per ml further apart than this factor count as not similar.
+	similarPriceRange = 4
+
+	defaultSimilarLimit = 8
+	maxSimilarLimit     = 24
+	similarCacheTTL     = 10 * time.Minute
+)
+
+type similarCacheEntry struct {
+	items   []map[string]interface{}
+	expires time.Time
+}
+
+// catalogChanged runs after every catalog write: it schedules a rebuild of
+// the suggestion dictionary and drops cached similar perfumes. Sale prices
+// feed neither, so price rule changes do not call it.
+func (s *Server) catalogChanged(ctx context.Context) {
+	s.scheduleSuggestionsRefresh(ctx)
+	s.similarMu.Lock()
+	s.similarCache = make(map[string]similarCacheEntry)
+	s.similarMu.Unlock()
+}
+
+// handleSimilarPerfumes returns in-stock perfumes of the same catalog ranked
+// by shared notes, family, seasons, time of day, sillage, longevity and price
+// per ml. Results are cached until the catalog changes or the TTL passes, so
+// that stock changes from orders are picked up too.
+func (s *Server) handleSimilarPerfumes(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "missing id")
+		return
+	}
+	limit := parsePositiveInt(strings.TrimSpace(r.URL.Query().Get("limit")), defaultSimilarLimit)
+	if limit > maxSimilarLimit {
+		limit = maxSimilarLimit
+	}
+	key := id + "|" + itoa(limit)
+	now := time.Now()
+
+	s.similarMu.Lock()
+	entry, ok := s.similarCache[key]
+	s.similarMu.Unlock()
+	if ok && now.Before(entry.expires) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"items": entry.items})
+		return
+	}
+
+	var exists bool
+	if err := s.db.QueryRowContext(r.Context(), `SELECT EXISTS (SELECT 1 FROM perfumes WHERE id = $1)`, id).Scan(&exists); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load similar perfumes")
+		return
+	}
+	if !exists {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	rows, err := s.db.QueryContext(r.Context(), `
+		WITH src AS (
+			SELECT id, catalog_mode, family, notes_top, notes_heart, notes_base, seasons, day_night,
+				sillage, longevity, base_price / NULLIF(base_volume, 0) AS price_per_ml
+			FROM perfumes
+			WHERE id = $1
+		), scored AS (
+			SELECT p.id,
+				$3::float8 * COALESCE((
+					$10::float8 * text_array_overlap(p.notes_top, src.notes_top) +
+					$11::float8 * text_array_overlap(p.notes_heart, src.notes_heart) +
+					$12::float8 * text_array_overlap(p.notes_base, src.notes_base)
+				) / NULLIF(
+					$10::float8 * cardinality(src.notes_top) +
+					$11::float8 * cardinality(src.notes_heart) +
+					$12::float8 * cardinality(src.notes_base), 0), 0)
+				+ CASE WHEN src.family <> '' AND lower(p.family) = lower(src.family) THEN $4::float8 ELSE 0 END
+				+ $5::float8 * text_array_jaccard(p.seasons, src.seasons)
+				+ $6::float8 * text_array_jaccard(p.day_night, src.day_night)
+				+ $7::float8 * GREATEST(1 - abs(p.sillage - src.sillage) / 4.0, 0)
+				+ $8::float8 * GREATEST(1 - abs(p.longevity - src.longevity) / 4.0, 0)
+				+ $9::float8 * COALESCE(GREATEST(
+					1 - abs(ln(NULLIF(p.base_price, 0) / NULLIF(p.base_volume, 0) / NULLIF(src.price_per_ml, 0))) / ln($13::float8), 0), 0)
+				AS similarity
+			FROM perfumes p, src
+			WHERE p.id <> src.id AND p.catalog_mode = src.catalog_mode AND p.in_stock
+		)
+		SELECT id, catalog_mode, brand, name, family, description,
+		       to_json(tags), to_json(notes_top), to_json(notes_heart), to_json(notes_base),
+		       to_json(seasons), to_json(day_night), base_price, base_volume, sillage, longevity,
+		       image_url, search_name_ru, is_hit, order_count, in_stock, stock_qty, currency,
+		       popularity, popularity_month, popularity_month_key,
+		       COALESCE(review_avg, 0), COALESCE(review_count, 0), created_at, updated_at,
+		       `+perfumeVariantsJSON+`, similarity
+		FROM perfumes
+		JOIN scored USING (id)
+		ORDER BY similarity DESC, popularity DESC, id
+		LIMIT $2
+	`, id, limit,
+		similarNotesWeight, similarFamilyWeight, similarSeasonsWeight, similarDayNightWeight,
+		similarSillageWeight, similarLongevityWeight, similarPriceWeight,
+		similarTopNoteWeight, similarHeartNoteWeight, similarBaseNoteWeight, similarPriceRange,
+	)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load similar perfumes")
+		return
+	}
+	defer rows.Close()
+
+	var similarity float64
+	items, err := scanPerfumeRowsWith(rows, []interface{}{&similarity}, func(_ perfumePayload, item map[string]interface{}) {
+		item["similarity"] = math.Round(similarity*1000) / 1000
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot parse similar perfumes")
+		return
+	}
+	if items == nil {
+		items = []map[string]interface{}{}
+	}
+
+	s.similarMu.Lock()
+	s.similarCache[key] = similarCacheEntry{items: items, expires: now.Add(similarCacheTTL)}
+	s.similarMu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": items})
+}
@@ -0,0 +1,77 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"parfum-backend/internal/app"
+)
+
+func similarRequest(id string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/api/perfumes/"+id+"/similar", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestHandleSimilarPerfumesCachesUntilCatalogChanges(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	expectLoad := func() {
+		mock.ExpectQuery("SELECT EXISTS").
+			WithArgs("p1").
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery("(?s)WITH src AS .*JOIN scored USING \\(id\\)\\s+ORDER BY similarity DESC").
+			WithArgs("p1", defaultSimilarLimit,
+				similarNotesWeight, similarFamilyWeight, similarSeasonsWeight, similarDayNightWeight,
+				similarSillageWeight, similarLongevityWeight, similarPriceWeight,
+				similarTopNoteWeight, similarHeartNoteWeight, similarBaseNoteWeight, similarPriceRange).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	}
+	expectLoad()
+	expectLoad()
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		s.handleSimilarPerfumes(rec, similarRequest("p1"))
+		if rec.Code != http.StatusOK || rec.Body.String() != "{\"items\":[]}\n" {
+			t.Fatalf("request %d: %d %s", i, rec.Code, rec.Body.String())
+		}
+	}
+	s.catalogChanged(context.Background())
+	rec := httptest.NewRecorder()
+	s.handleSimilarPerfumes(rec, similarRequest("p1"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 after invalidation, got %d", rec.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
+
+func TestHandleSimilarPerfumesNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs("missing").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	rec := httptest.NewRecorder()
+	s.handleSimilarPerfumes(rec, similarRequest("missing"))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,133 @@
+package httpapi
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSuggestLimit = 5
+	maxSuggestLimit     = 20
+
+	suggestRefreshDelay   = 2 * time.Second
+	suggestRefreshTimeout = 5 * time.Minute
+)
+
+// likePrefix escapes LIKE wildcards so that the value only matches as a
+// literal prefix.
+func likePrefix(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+	return s + "%"
+}
+
+// RefreshSuggestions rebuilds the autocomplete dictionary now. Rebuilds run
+// one at a time (see migration 021).
+func (s *Server) RefreshSuggestions(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `SELECT refresh_perfume_suggestions()`)
+	return err
+}
+
+// scheduleSuggestionsRefresh rebuilds the dictionary in the background,
+// suggestDelay after the first of a burst of catalog writes, so that a run
+// of edits costs one rebuild. The rebuild is detached from the request: a
+// client that disconnects after its write committed must not cancel it. A
+// failure only leaves suggestions stale until the next write, so it is
+// logged.
+func (s *Server) scheduleSuggestionsRefresh(ctx context.Context) {
+	ctx = context.WithoutCancel(ctx)
+	s.suggestMu.Lock()
+	defer s.suggestMu.Unlock()
+	if s.suggestPending {
+		return
+	}
+	s.suggestPending = true
+	time.AfterFunc(s.suggestDelay, func() {
+		s.suggestMu.Lock()
+		s.suggestPending = false
+		s.suggestMu.Unlock()
+		ctx, cancel := context.WithTimeout(ctx, suggestRefreshTimeout)
+		defer cancel()
+		if err := s.RefreshSuggestions(ctx); err != nil {
+			log.Printf("refresh perfume suggestions: %v", err)
+		}
+	})
+}
+
+// handleSuggestPerfumes returns brands, perfume names and notes starting with
+// q, in Cyrillic or Latin spelling. It is called on every keystroke, so it
+// only reads the precomputed perfume_suggestions table.
+func (s *Server) handleSuggestPerfumes(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	resp := SuggestResponse{Query: q, Brands: []Suggestion{}, Perfumes: []Suggestion{}, Notes: []Suggestion{}}
+	if q == "" {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	mode := strings.TrimSpace(r.URL.Query().Get("mode"))
+	if mode != "" && mode != "retail" && mode != "wholesale" {
+		writeError(w, http.StatusBadRequest, "invalid catalog mode")
+		return
+	}
+	limit := parsePositiveInt(strings.TrimSpace(r.URL.Query().Get("limit")), defaultSuggestLimit)
+	if limit > maxSuggestLimit {
+		limit = maxSuggestLimit
+	}
+
+	args := []interface{}{limit, mode}
+	var prefixes []string
+	for _, v := range searchVariants(q) {
+		args = append(args, likePrefix(v))
+		prefixes = append(prefixes, "match_key LIKE $"+itoa(len(args)))
+	}
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT kind, term, brand, perfume_id, retail_count, wholesale_count
+		FROM (
+			SELECT kind, term, brand, perfume_id, retail_count, wholesale_count,
+				row_number() OVER (
+					PARTITION BY kind
+					ORDER BY retail_count + wholesale_count DESC, term, perfume_id
+				) AS rn
+			FROM (
+				SELECT DISTINCT kind, term, brand, perfume_id, retail_count, wholesale_count
+				FROM perfume_suggestions
+				WHERE (`+strings.Join(prefixes, " OR ")+`)
+					AND ($2 = '' OR ($2 = 'retail' AND retail_count > 0) OR ($2 = 'wholesale' AND wholesale_count > 0))
+			) matched
+		) ranked
+		WHERE rn <= $1
+		ORDER BY kind, rn
+	`, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load suggestions")
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			kind string
+			item Suggestion
+		)
+		if err := rows.Scan(&kind, &item.Value, &item.Brand, &item.ID, &item.Retail, &item.Wholesale); err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot parse suggestions")
+			return
+		}
+		switch kind {
+		case "brand":
+			resp.Brands = append(resp.Brands, item)
+		case "perfume":
+			resp.Perfumes = append(resp.Perfumes, item)
+		case "note":
+			resp.Notes = append(resp.Notes, item)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load suggestions")
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,93 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"parfum-backend/internal/app"
+)
+
+func TestLikePrefixEscapesWildcards(t *testing.T) {
+	if got := likePrefix(`10%_a\b`); got != `10\%\_a\\b%` {
+		t.Fatalf("likePrefix = %q", got)
+	}
+}
+
+func TestHandleSuggestPerfumesGroupsByKind(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("(?s)FROM perfume_suggestions\\s+WHERE \\(match_key LIKE \\$3 OR match_key LIKE \\$4\\)").
+		WithArgs(5, "retail", "chan%", "шан%").
+		WillReturnRows(sqlmock.NewRows([]string{"kind", "term", "brand", "perfume_id", "retail_count", "wholesale_count"}).
+			AddRow("brand", "Chanel", "", "", 12, 3).
+			AddRow("perfume", "Chance", "Chanel", "p1", 1, 0))
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	req := httptest.NewRequest(http.MethodGet, "/api/perfumes/suggest?q=Chan&mode=retail", nil)
+	rec := httptest.NewRecorder()
+	s.handleSuggestPerfumes(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp SuggestResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp.Brands) != 1 || resp.Brands[0].Retail != 12 || resp.Brands[0].Wholesale != 3 {
+		t.Fatalf("unexpected brands: %+v", resp.Brands)
+	}
+	if len(resp.Perfumes) != 1 || resp.Perfumes[0].ID != "p1" || resp.Perfumes[0].Brand != "Chanel" {
+		t.Fatalf("unexpected perfumes: %+v", resp.Perfumes)
+	}
+	if len(resp.Notes) != 0 {
+		t.Fatalf("unexpected notes: %+v", resp.Notes)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
+
+func TestCatalogChangedRefreshesSuggestionsOnceAfterBurst(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+	mock.ExpectExec("SELECT refresh_perfume_suggestions\\(\\)").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	s.suggestDelay = 20 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 0; i < 3; i++ {
+		s.catalogChanged(ctx)
+	}
+	// The request is over before the rebuild starts; it must still run.
+	cancel()
+	if err := mock.ExpectationsWereMet(); err == nil {
+		t.Fatal("expected the refresh to wait for the burst to end")
+	}
+	deadline := time.Now().Add(time.Second)
+	for mock.ExpectationsWereMet() != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("db expectations: %v", mock.ExpectationsWereMet())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	s.suggestMu.Lock()
+	pending := s.suggestPending
+	s.suggestMu.Unlock()
+	if pending {
+		t.Fatal("expected the next write to schedule a new refresh")
+	}
+}
@@ -49,28 +49,105 @@ type Perfume struct {
 }
 
 type OrderItem struct {
-	ID     string  `json:"id"`
-	Volume float64 `json:"volume"`
-	Mix    string  `json:"mix"`
-	Qty    int     `json:"qty"`
-	Price  float64 `json:"price"`
+	ID        string  `json:"id"`
+	VariantID string  `json:"variantId,omitempty"`
+	SKU       string  `json:"sku,omitempty"`
+	Brand     string  `json:"brand,omitempty"`
+	Name      string  `json:"name,omitempty"`
+	Volume    float64 `json:"volume"`
+	Mix       string  `json:"mix"`
+	Qty       int     `json:"qty"`
+	Price     float64 `json:"price"`
 }
 
 type Order struct {
-	ID              string      `json:"id"`
-	UserID          string      `json:"uid"`
-	IsAnonymous     bool        `json:"isAnonymous"`
-	Email           string      `json:"email"`
-	DisplayName     string      `json:"displayName"`
-	Phone           string      `json:"phone"`
-	Items           []OrderItem `json:"items"`
-	Total           float64     `json:"total"`
-	Currency        string      `json:"currency"`
-	Channel         string      `json:"channel"`
-	DeliveryMethod  string      `json:"deliveryMethod"`
-	DeliveryAddress string      `json:"deliveryAddress"`
-	Fulfilled       bool        `json:"fulfilled"`
-	CreatedAt       string      `json:"createdAt"`
+	ID              string          `json:"id"`
+	UserID          string          `json:"uid"`
+	IsAnonymous     bool            `json:"isAnonymous"`
+	Email           string          `json:"email"`
+	DisplayName     string          `json:"displayName"`
+	Phone           string          `json:"phone"`
+	Items           []OrderItem     `json:"items"`
+	Subtotal        float64         `json:"subtotal"`
+	Discount        float64         `json:"discount"`
+	PromoCode       string          `json:"promoCode,omitempty"`
+	Discounts       []OrderDiscount `json:"discounts"`
+	Total           float64         `json:"total"`
+	Currency        string          `json:"currency"`
+	Channel         string          `json:"channel"`
+	DeliveryMethod  string          `json:"deliveryMethod"`
+	DeliveryFee     float64         `json:"deliveryFee"`
+	DeliveryAddress string          `json:"deliveryAddress"`
+	Fulfilled       bool            `json:"fulfilled"`
+	CreatedAt       string          `json:"createdAt"`
+	Shipments       []Shipment      `json:"shipments,omitempty"`
+}
+
+type Shipment struct {
+	ID             string          `json:"id"`
+	OrderID        string          `json:"orderId"`
+	Carrier        string          `json:"carrier"`
+	TrackingNumber string          `json:"trackingNumber"`
+	Status         string          `json:"status"`
+	ShippedAt      string          `json:"shippedAt,omitempty"`
+	DeliveredAt    string          `json:"deliveredAt,omitempty"`
+	Events         []ShipmentEvent `json:"events"`
+	CreatedAt      string          `json:"createdAt"`
+	UpdatedAt      string          `json:"updatedAt"`
+}
+
+type ShipmentEvent struct {
+	Status      string `json:"status"`
+	Description string `json:"description"`
+	Location    string `json:"location"`
+	OccurredAt  string `json:"occurredAt"`
+}
+
+type OrderDiscount struct {
+	Code   string              `json:"code"`
+	Kind   string              `json:"kind"`
+	Value  float64             `json:"value"`
+	Amount float64             `json:"amount"`
+	Items  []OrderItemDiscount `json:"items"`
+}
+
+type OrderItemDiscount struct {
+	ID     string  `json:"id"`
+	Amount float64 `json:"amount"`
+}
+
+type PromoCode struct {
+	ID             string   `json:"id"`
+	Code           string   `json:"code"`
+	Description    string   `json:"description"`
+	Kind           string   `json:"kind"`
+	Amount         float64  `json:"amount"`
+	MinTotal       float64  `json:"minTotal"`
+	MaxUses        *int     `json:"maxUses"`
+	MaxUsesPerUser *int     `json:"maxUsesPerUser"`
+	UsedCount      int      `json:"usedCount"`
+	StartsAt       string   `json:"startsAt,omitempty"`
+	EndsAt         string   `json:"endsAt,omitempty"`
+	CatalogMode    string   `json:"catalogMode"`
+	Brands         []string `json:"brands"`
+	PerfumeIDs     []string `json:"perfumeIds"`
+	IsActive       bool     `json:"isActive"`
+	CreatedAt      string   `json:"createdAt"`
+	UpdatedAt      string   `json:"updatedAt"`
+}
+
+type DeliveryMethod struct {
+	ID              string   `json:"id"`
+	Kind            string   `json:"kind"`
+	Title           string   `json:"title"`
+	Description     string   `json:"description"`
+	Address         string   `json:"address"`
+	Price           float64  `json:"price"`
+	FreeFrom        *float64 `json:"freeFrom"`
+	CatalogModes    []string `json:"catalogModes"`
+	RequiresAddress bool     `json:"requiresAddress"`
+	IsActive        bool     `json:"isActive"`
+	Position        int      `json:"position"`
 }
 
 type Review struct {
@@ -99,3 +176,211 @@ type PresetGroup struct {
 	Notes      string   `json:"notes"`
 	PerfumeIDs []string `json:"perfumeIds"`
 }
+
+type RevenueBucket struct {
+	Period        string  `json:"period"`
+	Orders        int     `json:"orders"`
+	Items         int     `json:"items"`
+	Revenue       float64 `json:"revenue"`
+	AvgOrderValue float64 `json:"avgOrderValue"`
+}
+
+type RevenueReport struct {
+	From          string          `json:"from"`
+	To            string          `json:"to"`
+	Interval      string          `json:"interval"`
+	CatalogMode   string          `json:"catalogMode,omitempty"`
+	Fulfilled     string          `json:"fulfilled"`
+	Orders        int             `json:"orders"`
+	Items         int             `json:"items"`
+	Revenue       float64         `json:"revenue"`
+	AvgOrderValue float64         `json:"avgOrderValue"`
+	Buckets       []RevenueBucket `json:"buckets"`
+}
+
+type TopPerfume struct {
+	ID      string  `json:"id"`
+	Brand   string  `json:"brand"`
+	Name    string  `json:"name"`
+	Orders  int     `json:"orders"`
+	Qty     int     `json:"qty"`
+	Revenue float64 `json:"revenue"`
+}
+
+type FunnelReport struct {
+	From        string  `json:"from"`
+	To          string  `json:"to"`
+	CatalogMode string  `json:"catalogMode,omitempty"`
+	Views       int     `json:"views"`
+	AddToCart   int     `json:"addToCart"`
+	Buys        int     `json:"buys"`
+	ViewToCart  float64 `json:"viewToCart"`
+	CartToBuy   float64 `json:"cartToBuy"`
+	ViewToBuy   float64 `json:"viewToBuy"`
+}
+
+// Suggestion is an autocomplete entry with the number of perfumes it matches
+// in each catalog. Brand and ID are only set for perfume names.
+type Suggestion struct {
+	Value     string `json:"value"`
+	Brand     string `json:"brand,omitempty"`
+	ID        string `json:"id,omitempty"`
+	Retail    int    `json:"retail"`
+	Wholesale int    `json:"wholesale"`
+}
+
+type SuggestResponse struct {
+	Query    string       `json:"query"`
+	Brands   []Suggestion `json:"brands"`
+	Perfumes []Suggestion `json:"perfumes"`
+	Notes    []Suggestion `json:"notes"`
+}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// PriceFacetBucket covers base prices in [Min, Max); Max is null for the
+// last bucket.
+type PriceFacetBucket struct {
+	Min   float64  `json:"min"`
+	Max   *float64 `json:"max"`
+	Count int      `json:"count"`
+}
+
+// ReferenceEntry is a brand, family or note dictionary entry. Perfumes store
+// Name; NameRu, NameEn and Aliases are other spellings resolving to it.
+type ReferenceEntry struct {
+	ID           string   `json:"id"`
+	Slug         string   `json:"slug"`
+	Name         string   `json:"name"`
+	NameRu       string   `json:"nameRu"`
+	NameEn       string   `json:"nameEn"`
+	Aliases      []string `json:"aliases"`
+	Title        string   `json:"title"`
+	Description  string   `json:"description"`
+	HeroImage    string   `json:"heroImage"`
+	PerfumeCount int      `json:"perfumeCount"`
+	CreatedAt    string   `json:"createdAt"`
+	UpdatedAt    string   `json:"updatedAt"`
+}
+
+// Landing is the public page of a brand or note. HeroImage falls back to the
+// image of its most popular perfume; Retail and Wholesale count perfumes per
+// catalog.
+type Landing struct {
+	Slug        string `json:"slug"`
+	Name        string `json:"name"`
+	NameRu      string `json:"nameRu"`
+	NameEn      string `json:"nameEn"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	HeroImage   string `json:"heroImage"`
+	Retail      int    `json:"retail"`
+	Wholesale   int    `json:"wholesale"`
+}
+
+// PerfumeVariant is a volume and mix of a perfume as sold. StockQty is nil
+// when stock is not tracked. Price is the regular price; SalePrice and
+// SaleEndsAt are set while a price rule is active.
+type PerfumeVariant struct {
+	ID         string   `json:"id"`
+	PerfumeID  string   `json:"perfumeId"`
+	Volume     int      `json:"volume"`
+	Mix        string   `json:"mix"`
+	SKU        string   `json:"sku"`
+	Price      float64  `json:"price"`
+	StockQty   *int     `json:"stockQty"`
+	Barcode    string   `json:"barcode"`
+	IsDefault  bool     `json:"isDefault"`
+	Position   int      `json:"position"`
+	SalePrice  *float64 `json:"salePrice,omitempty"`
+	SaleEndsAt string   `json:"saleEndsAt,omitempty"`
+}
+
+// CatalogImportReport is the diff of a catalog import. Applied is false for
+// dry runs and imports with Errors; Removed is only filled with replace.
+type CatalogImportReport struct {
+	Mode      string               `json:"mode"`
+	DryRun    bool                 `json:"dryRun"`
+	Applied   bool                 `json:"applied"`
+	Created   []string             `json:"created"`
+	Changed   []CatalogChange      `json:"changed"`
+	Removed   []string             `json:"removed"`
+	Unchanged int                  `json:"unchanged"`
+	Errors    []CatalogImportError `json:"errors"`
+}
+
+// CatalogChange lists the fields of an existing perfume an import changes.
+type CatalogChange struct {
+	ID     string   `json:"id"`
+	Fields []string `json:"fields"`
+}
+
+// CatalogImportError is a rejected import row. Row is 1-based; in CSV the
+// header is row 1.
+type CatalogImportError struct {
+	Row   int    `json:"row"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
+
+// PerfumeVersion is a stored state of a perfume's catalog fields. Snapshot
+// is keyed by field path, as in PATCH /api/perfumes/{id}; Changes are
+// against the previous version. RestoredFrom is set when the version was
+// made by restoring an earlier one.
+type PerfumeVersion struct {
+	Version        int                    `json:"version"`
+	ChangedBy      string                 `json:"changedBy,omitempty"`
+	ChangedByEmail string                 `json:"changedByEmail,omitempty"`
+	RestoredFrom   int                    `json:"restoredFrom,omitempty"`
+	CreatedAt      string                 `json:"createdAt"`
+	Changes        []PerfumeFieldChange   `json:"changes"`
+	Snapshot       map[string]interface{} `json:"snapshot"`
+}
+
+// PerfumeFieldChange is one field changed between two perfume versions.
+type PerfumeFieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// PriceRule is a scheduled sale price of a perfume. Without VariantID it
+// applies to the default variant; without EndsAt it runs until removed.
+type PriceRule struct {
+	ID        string  `json:"id"`
+	PerfumeID string  `json:"perfumeId"`
+	VariantID string  `json:"variantId,omitempty"`
+	SalePrice float64 `json:"salePrice"`
+	StartsAt  string  `json:"startsAt"`
+	EndsAt    string  `json:"endsAt,omitempty"`
+	Note      string  `json:"note"`
+	Active    bool    `json:"active"`
+	CreatedAt string  `json:"createdAt"`
+	UpdatedAt string  `json:"updatedAt"`
+}
+
+// PriceHistory is the price record of a perfume. LowestPrice30d is the
+// lowest price it sold for in the last 30 days, sales included, and is the
+// reference for "old price" displays.
+type PriceHistory struct {
+	PerfumeID      string              `json:"perfumeId"`
+	Currency       string              `json:"currency"`
+	CurrentPrice   float64             `json:"currentPrice"`
+	RegularPrice   float64             `json:"regularPrice"`
+	LowestPrice30d float64             `json:"lowestPrice30d"`
+	Entries        []PriceHistoryEntry `json:"entries"`
+}
+
+// PriceHistoryEntry is one recorded price. Base entries last until the next
+// base entry; sale entries cover StartsAt..EndsAt.
+type PriceHistoryEntry struct {
+	Source     string  `json:"source"`
+	Price      float64 `json:"price"`
+	RuleID     string  `json:"ruleId,omitempty"`
+	StartsAt   string  `json:"startsAt"`
+	EndsAt     string  `json:"endsAt,omitempty"`
+	RecordedAt string  `json:"recordedAt"`
+}
@@ -0,0 +1,297 @@
+package httpapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// perfumeVariantsJSON selects the variants of a perfume, with their active
+// sale, as a JSON array. It expects the outer query to read from perfumes
+// without an alias.
+const perfumeVariantsJSON = `COALESCE((
+		SELECT json_agg(json_build_object(
+			'id', v.id, 'perfumeId', v.perfume_id, 'volume', v.volume, 'mix', v.mix, 'sku', v.sku,
+			'price', v.price, 'stockQty', v.stock_qty, 'barcode', v.barcode, 'isDefault', v.is_default,
+			'position', v.position, 'salePrice', s.sale_price,
+			'saleEndsAt', to_char(s.ends_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"')
+		) ORDER BY v.position, v.volume, v.mix)
+		FROM perfume_variants v
+		LEFT JOIN LATERAL variant_sale(v.id) s ON true
+		WHERE v.perfume_id = perfumes.id
+	), '[]')`
+
+func parseVariantsJSON(data []byte) []PerfumeVariant {
+	var out []PerfumeVariant
+	if err := json.Unmarshal(data, &out); err != nil || out == nil {
+		return []PerfumeVariant{}
+	}
+	return out
+}
+
+var (
+	errInvalidVariant = errors.New("invalid variant id")
+	errUnknownVariant = errors.New("no variant with this volume and mix")
+)
+
+// orderVariant is the variant an order or cart line resolves to, with the
+// perfume fields an order records. matched is false when the line named no
+// variant at all and got the default one.
+type orderVariant struct {
+	PerfumeVariant
+	currency    string
+	brand       string
+	name        string
+	catalogMode string
+	matched     bool
+}
+
+// resolveOrderVariant finds the variant of a line: by variantId, else by
+// volume and mix. Only a line without volume and mix, from a client that
+// does not know about variants, gets the default variant of the perfume; a
+// volume or mix no variant has is errUnknownVariant. The price is the sale
+// price when a sale is active. sql.ErrNoRows means the perfume does not
+// exist.
+func resolveOrderVariant(q dbQueryer, item OrderItem) (orderVariant, error) {
+	var v orderVariant
+	err := q.QueryRow(`
+		SELECT v.id, v.sku, v.volume, v.mix, COALESCE(s.sale_price, v.price), p.currency, p.brand, p.name, p.catalog_mode,
+		       v.id::text = $2 OR (v.volume = $3::numeric AND v.mix = $4)
+		FROM perfumes p
+		JOIN perfume_variants v ON v.perfume_id = p.id
+		LEFT JOIN LATERAL variant_sale(v.id) s ON true
+		WHERE p.id = $1
+		ORDER BY v.id::text = $2 DESC, (v.volume = $3::numeric AND v.mix = $4) DESC, v.is_default DESC
+		LIMIT 1
+	`, item.ID, item.VariantID, item.Volume, item.Mix).Scan(
+		&v.ID, &v.SKU, &v.Volume, &v.Mix, &v.Price, &v.currency, &v.brand, &v.name, &v.catalogMode, &v.matched,
+	)
+	if err != nil {
+		return v, err
+	}
+	if item.VariantID != "" && v.ID != item.VariantID {
+		return v, errInvalidVariant
+	}
+	if item.VariantID == "" && !v.matched && (item.Volume != 0 || item.Mix != "") {
+		return v, errUnknownVariant
+	}
+	v.PerfumeID = item.ID
+	return v, nil
+}
+
+// syncDefaultVariant carries perfume-level price, volume and stock edits
+// over to the default variant.
+func syncDefaultVariant(q dbQueryer, perfumeID string) error {
+	_, err := q.Exec(`SELECT sync_default_variant($1)`, perfumeID)
+	return err
+}
+
+// isVariantVolumeConflict reports a violation of UNIQUE (perfume_id, volume,
+// mix) of perfume_variants, under the name Postgres gives it.
+func isVariantVolumeConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "perfume_variants_perfume_id_volume_mix_key"
+}
+
+// writeDefaultVariantError reports a failed syncDefaultVariant after a
+// perfume write. A volume conflict means the new base volume is already
+// taken by another variant with the same mix.
+func writeDefaultVariantError(w http.ResponseWriter, err error) {
+	if isVariantVolumeConflict(err) {
+		writeError(w, http.StatusConflict, "volume already used by another variant")
+		return
+	}
+	writeError(w, http.StatusInternalServerError, "cannot save perfume")
+}
+
+type variantPayload struct {
+	Volume    int     `json:"volume"`
+	Mix       string  `json:"mix"`
+	SKU       string  `json:"sku"`
+	Price     float64 `json:"price"`
+	StockQty  *int    `json:"stockQty"`
+	Barcode   string  `json:"barcode"`
+	IsDefault bool    `json:"isDefault"`
+	Position  int     `json:"position"`
+}
+
+var barcodePattern = regexp.MustCompile(`^[0-9]{8,14}$`)
+
+func (s *Server) handleListVariants(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+	var data []byte
+	err := s.db.QueryRow(`SELECT `+perfumeVariantsJSON+` FROM perfumes WHERE id = $1`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot load variants")
+		return
+	}
+	writeJSON(w, http.StatusOK, parseVariantsJSON(data))
+}
+
+// handleUpsertVariant creates or updates a variant. Making a variant the
+// default takes the flag from the previous default; the perfume summary
+// columns are refreshed afterwards.
+func (s *Server) handleUpsertVariant(w http.ResponseWriter, r *http.Request) {
+	perfumeID := strings.TrimSpace(chi.URLParam(r, "id"))
+	variantID := chi.URLParam(r, "variantId")
+	var body variantPayload
+	if err := readJSON(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	mix := strings.TrimSpace(body.Mix)
+	if body.Volume <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid volume")
+		return
+	}
+	if body.Price < 0 {
+		writeError(w, http.StatusBadRequest, "invalid price")
+		return
+	}
+	if body.StockQty != nil && *body.StockQty < 0 {
+		writeError(w, http.StatusBadRequest, "invalid stock qty")
+		return
+	}
+	barcode := strings.TrimSpace(body.Barcode)
+	if barcode != "" && !barcodePattern.MatchString(barcode) {
+		writeError(w, http.StatusBadRequest, "invalid barcode")
+		return
+	}
+	sku := strings.TrimSpace(body.SKU)
+	if sku == "" {
+		sku = perfumeID + "-" + itoa(body.Volume)
+		if mix != "" {
+			sku += "-" + referenceSlug(mix)
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot save variant")
+		return
+	}
+	defer tx.Rollback()
+
+	var variants int
+	err = tx.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM perfume_variants WHERE perfume_id = p.id)
+		FROM perfumes p WHERE p.id = $1 FOR UPDATE
+	`, perfumeID).Scan(&variants)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot save variant")
+		return
+	}
+	isDefault := body.IsDefault || variants == 0
+	if variantID != "" {
+		var wasDefault bool
+		err := tx.QueryRow(`SELECT is_default FROM perfume_variants WHERE id = $1 AND perfume_id = $2`, variantID, perfumeID).Scan(&wasDefault)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot save variant")
+			return
+		}
+		if wasDefault && !isDefault {
+			writeError(w, http.StatusBadRequest, "make another variant the default instead")
+			return
+		}
+	}
+	if isDefault {
+		if _, err := tx.Exec(`
+			UPDATE perfume_variants SET is_default = false, updated_at = now()
+			WHERE perfume_id = $1 AND is_default AND id IS DISTINCT FROM $2::uuid
+		`, perfumeID, nullIfEmpty(variantID)); err != nil {
+			writeError(w, http.StatusInternalServerError, "cannot save variant")
+			return
+		}
+	}
+
+	args := []interface{}{perfumeID, body.Volume, mix, sku, body.Price, body.StockQty, barcode, isDefault, body.Position}
+	if variantID == "" {
+		err = tx.QueryRow(`
+			INSERT INTO perfume_variants (perfume_id, volume, mix, sku, price, stock_qty, barcode, is_default, position, created_at, updated_at)
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,now(),now())
+			RETURNING id
+		`, args...).Scan(&variantID)
+	} else {
+		_, err = tx.Exec(`
+			UPDATE perfume_variants SET
+				volume=$2, mix=$3, sku=$4, price=$5, stock_qty=$6, barcode=$7, is_default=$8, position=$9, updated_at=now()
+			WHERE id=$10 AND perfume_id=$1
+		`, append(args, variantID)...)
+	}
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			writeError(w, http.StatusConflict, "sku, barcode or volume and mix already used")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "cannot save variant")
+		return
+	}
+	if _, err := tx.Exec(`SELECT sync_perfume_from_variants($1)`, perfumeID); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot save variant")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot save variant")
+		return
+	}
+	s.catalogChanged(r.Context())
+	writeJSON(w, http.StatusOK, map[string]string{"id": variantID, "sku": sku})
+}
+
+func (s *Server) handleDeleteVariant(w http.ResponseWriter, r *http.Request) {
+	perfumeID := strings.TrimSpace(chi.URLParam(r, "id"))
+	variantID := chi.URLParam(r, "variantId")
+	tx, err := s.db.Begin()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot delete variant")
+		return
+	}
+	defer tx.Rollback()
+
+	var isDefault bool
+	err = tx.QueryRow(`
+		DELETE FROM perfume_variants WHERE id = $1 AND perfume_id = $2
+		RETURNING is_default
+	`, variantID, perfumeID).Scan(&isDefault)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot delete variant")
+		return
+	}
+	if isDefault {
+		writeError(w, http.StatusConflict, "cannot delete the default variant")
+		return
+	}
+	if _, err := tx.Exec(`SELECT sync_perfume_from_variants($1)`, perfumeID); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot delete variant")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, "cannot delete variant")
+		return
+	}
+	s.catalogChanged(r.Context())
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,96 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"parfum-backend/internal/app"
+)
+
+func TestResolveOrderVariantRejectsUnknownVariant(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// The query falls back to the default variant; a variantId that matched
+	// nothing must not be silently replaced by it.
+	mock.ExpectQuery("(?s)FROM perfumes p\\s+JOIN perfume_variants v").
+		WithArgs("p1", "v-gone", 30.0, "70/30").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "volume", "mix", "price", "currency", "brand", "name", "catalog_mode", "matched"}).
+			AddRow("v1", "p1-50", 50, "60/40", 50.0, "₽", "Chanel", "Chance", "retail", false))
+
+	_, err = resolveOrderVariant(db, OrderItem{ID: "p1", VariantID: "v-gone", Volume: 30, Mix: "70/30", Qty: 1})
+	if !errors.Is(err, errInvalidVariant) {
+		t.Fatalf("expected errInvalidVariant, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
+
+func TestResolveOrderVariantRejectsUnknownVolume(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	columns := []string{"id", "sku", "volume", "mix", "price", "currency", "brand", "name", "catalog_mode", "matched"}
+	// The perfume comes in 50 and 100 ml; 30 ml falls back to the default
+	// variant in the query, which must not be charged for it.
+	mock.ExpectQuery("(?s)FROM perfumes p\\s+JOIN perfume_variants v").
+		WithArgs("p1", "", 30.0, "").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("v1", "p1-50", 50, "60/40", 50.0, "₽", "Chanel", "Chance", "retail", false))
+	if _, err := resolveOrderVariant(db, OrderItem{ID: "p1", Volume: 30, Qty: 1}); !errors.Is(err, errUnknownVariant) {
+		t.Fatalf("expected errUnknownVariant, got %v", err)
+	}
+
+	// Without volume and mix the line is from a client unaware of variants.
+	mock.ExpectQuery("(?s)FROM perfumes p\\s+JOIN perfume_variants v").
+		WithArgs("p1", "", 0.0, "").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("v1", "p1-50", 50, "60/40", 50.0, "₽", "Chanel", "Chance", "retail", false))
+	v, err := resolveOrderVariant(db, OrderItem{ID: "p1", Qty: 1})
+	if err != nil || v.ID != "v1" || v.Volume != 50 {
+		t.Fatalf("expected the default variant, got %+v, %v", v, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
+
+func TestHandleDeleteVariantKeepsDefault(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("(?s)DELETE FROM perfume_variants WHERE id = \\$1 AND perfume_id = \\$2").
+		WithArgs("v1", "p1").
+		WillReturnRows(sqlmock.NewRows([]string{"is_default"}).AddRow(true))
+	mock.ExpectRollback()
+
+	s := NewServer(app.Config{JWTSecret: "test-secret"}, db)
+	req := httptest.NewRequest(http.MethodDelete, "/api/perfumes/p1/variants/v1", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "p1")
+	rctx.URLParams.Add("variantId", "v1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	rec := httptest.NewRecorder()
+	s.handleDeleteVariant(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("db expectations: %v", err)
+	}
+}
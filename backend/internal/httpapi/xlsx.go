@@ -0,0 +1,142 @@
+package httpapi
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// xlsxWriter streams a single-sheet workbook straight into w. Rows are
+// written as they arrive, so memory use does not grow with the sheet size.
+type xlsxWriter struct {
+	zw    *zip.Writer
+	sheet io.Writer
+	row   int
+}
+
+var xlsxStaticParts = []struct {
+	name string
+	body string
+}{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`},
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>
+</styleSheet>`},
+}
+
+func newXLSXWriter(w io.Writer, sheetName string) (*xlsxWriter, error) {
+	zw := zip.NewWriter(w)
+	for _, part := range xlsxStaticParts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return nil, err
+		}
+	}
+	wb, err := zw.Create("xl/workbook.xml")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(wb, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="`+xlsxEscape(sheetName)+`" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`); err != nil {
+		return nil, err
+	}
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`); err != nil {
+		return nil, err
+	}
+	return &xlsxWriter{zw: zw, sheet: sheet}, nil
+}
+
+// WriteHeader writes a bold row.
+func (x *xlsxWriter) WriteHeader(cells []string) error {
+	values := make([]interface{}, len(cells))
+	for i, c := range cells {
+		values[i] = c
+	}
+	return x.writeRow(values, ` s="1"`)
+}
+
+// WriteRow writes strings as inline strings and numeric values as numbers.
+func (x *xlsxWriter) WriteRow(cells []interface{}) error {
+	return x.writeRow(cells, "")
+}
+
+func (x *xlsxWriter) writeRow(cells []interface{}, style string) error {
+	x.row++
+	var b strings.Builder
+	b.WriteString(`<row r="` + strconv.Itoa(x.row) + `">`)
+	for _, c := range cells {
+		switch v := c.(type) {
+		case int:
+			b.WriteString(`<c` + style + `><v>` + strconv.Itoa(v) + `</v></c>`)
+		case float64:
+			b.WriteString(`<c` + style + `><v>` + strconv.FormatFloat(v, 'f', -1, 64) + `</v></c>`)
+		case bool:
+			val := "0"
+			if v {
+				val = "1"
+			}
+			b.WriteString(`<c t="b"` + style + `><v>` + val + `</v></c>`)
+		case string:
+			b.WriteString(`<c t="inlineStr"` + style + `><is><t xml:space="preserve">` + xlsxEscape(v) + `</t></is></c>`)
+		default:
+			b.WriteString(`<c` + style + `/>`)
+		}
+	}
+	b.WriteString(`</row>`)
+	_, err := io.WriteString(x.sheet, b.String())
+	return err
+}
+
+func (x *xlsxWriter) Close() error {
+	if _, err := io.WriteString(x.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return x.zw.Close()
+}
+
+// xlsxEscape escapes XML markup and drops control characters that are not
+// allowed in XML 1.0.
+func xlsxEscape(s string) string {
+	clean := strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' {
+			return -1
+		}
+		return r
+	}, s)
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(clean))
+	return b.String()
+}
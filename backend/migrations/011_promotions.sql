@@ -0,0 +1,39 @@
+CREATE TABLE IF NOT EXISTS promo_codes (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  code citext NOT NULL UNIQUE,
+  description text NOT NULL DEFAULT '',
+  kind text NOT NULL DEFAULT 'percent',
+  amount numeric NOT NULL DEFAULT 0,
+  min_total numeric NOT NULL DEFAULT 0,
+  max_uses integer,
+  max_uses_per_user integer,
+  used_count integer NOT NULL DEFAULT 0,
+  starts_at timestamptz,
+  ends_at timestamptz,
+  catalog_mode text NOT NULL DEFAULT '',
+  brands text[] NOT NULL DEFAULT ARRAY[]::text[],
+  perfume_ids text[] NOT NULL DEFAULT ARRAY[]::text[],
+  is_active boolean NOT NULL DEFAULT true,
+  created_at timestamptz NOT NULL DEFAULT now(),
+  updated_at timestamptz NOT NULL DEFAULT now(),
+  CONSTRAINT promo_codes_kind_check CHECK (kind IN ('percent', 'fixed'))
+);
+
+CREATE TABLE IF NOT EXISTS promo_redemptions (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  promo_id uuid NOT NULL REFERENCES promo_codes(id) ON DELETE CASCADE,
+  order_id uuid NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+  user_key text NOT NULL,
+  discount numeric NOT NULL DEFAULT 0,
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS promo_redemptions_promo_user_idx ON promo_redemptions (promo_id, user_key);
+
+ALTER TABLE orders
+  ADD COLUMN IF NOT EXISTS subtotal numeric NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS discount_total numeric NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS promo_code text NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS discounts jsonb NOT NULL DEFAULT '[]'::jsonb;
+
+UPDATE orders SET subtotal = total WHERE subtotal = 0 AND total > 0;
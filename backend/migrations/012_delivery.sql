@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS delivery_methods (
+  id text PRIMARY KEY,
+  kind text NOT NULL DEFAULT 'pickup',
+  title text NOT NULL,
+  description text NOT NULL DEFAULT '',
+  address text NOT NULL DEFAULT '',
+  price numeric NOT NULL DEFAULT 0,
+  free_from numeric,
+  catalog_modes text[] NOT NULL DEFAULT ARRAY['retail', 'wholesale']::text[],
+  is_active boolean NOT NULL DEFAULT true,
+  position integer NOT NULL DEFAULT 0,
+  created_at timestamptz NOT NULL DEFAULT now(),
+  updated_at timestamptz NOT NULL DEFAULT now(),
+  CONSTRAINT delivery_methods_kind_check CHECK (kind IN ('pickup', 'courier', 'post'))
+);
+
+CREATE INDEX IF NOT EXISTS delivery_methods_position_idx ON delivery_methods (position);
+
+-- Keep the historical values working for existing clients: checkout used
+-- to send either 'pickup' or 'delivery'. Courier zones are separate courier
+-- methods, each with its own price and free-shipping threshold; 'delivery'
+-- is the default zone.
+INSERT INTO delivery_methods (id, kind, title, price, position)
+VALUES ('pickup', 'pickup', 'Самовывоз', 0, 0),
+       ('delivery', 'courier', 'Доставка', 0, 1)
+ON CONFLICT (id) DO NOTHING;
+
+ALTER TABLE orders
+  ADD COLUMN IF NOT EXISTS delivery_fee numeric NOT NULL DEFAULT 0;
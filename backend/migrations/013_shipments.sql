@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS shipments (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  order_id uuid NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+  carrier text NOT NULL DEFAULT '',
+  tracking_number text NOT NULL DEFAULT '',
+  status text NOT NULL DEFAULT 'created',
+  shipped_at timestamptz,
+  delivered_at timestamptz,
+  last_polled_at timestamptz,
+  created_at timestamptz NOT NULL DEFAULT now(),
+  updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS shipments_order_idx ON shipments (order_id);
+CREATE INDEX IF NOT EXISTS shipments_pending_idx ON shipments (last_polled_at NULLS FIRST)
+  WHERE delivered_at IS NULL AND tracking_number <> '';
+
+CREATE TABLE IF NOT EXISTS shipment_events (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  shipment_id uuid NOT NULL REFERENCES shipments(id) ON DELETE CASCADE,
+  status text NOT NULL,
+  description text NOT NULL DEFAULT '',
+  location text NOT NULL DEFAULT '',
+  occurred_at timestamptz NOT NULL DEFAULT now(),
+  created_at timestamptz NOT NULL DEFAULT now(),
+  UNIQUE (shipment_id, status, occurred_at)
+);
+
+CREATE INDEX IF NOT EXISTS shipment_events_shipment_idx ON shipment_events (shipment_id, occurred_at);
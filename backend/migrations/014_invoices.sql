@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS invoice_counters (
+  year integer PRIMARY KEY,
+  last_number integer NOT NULL DEFAULT 0
+);
+
+ALTER TABLE orders
+  ADD COLUMN IF NOT EXISTS invoice_number text,
+  ADD COLUMN IF NOT EXISTS invoice_issued_at timestamptz;
+
+CREATE UNIQUE INDEX IF NOT EXISTS orders_invoice_number_idx ON orders (invoice_number)
+  WHERE invoice_number IS NOT NULL;
@@ -0,0 +1,16 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Keyset pagination for the supported sorts.
+CREATE INDEX IF NOT EXISTS orders_created_idx ON orders (created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS orders_total_idx ON orders (total, id);
+
+CREATE INDEX IF NOT EXISTS orders_channel_created_idx ON orders (channel, created_at DESC);
+CREATE INDEX IF NOT EXISTS orders_delivery_method_idx ON orders (delivery_method);
+CREATE INDEX IF NOT EXISTS orders_anonymous_created_idx ON orders (is_anonymous, created_at DESC);
+
+-- Substring search on customer fields.
+CREATE INDEX IF NOT EXISTS orders_display_name_trgm_idx ON orders USING gin (display_name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS orders_email_trgm_idx ON orders USING gin (email gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS orders_phone_trgm_idx ON orders USING gin (phone gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS orders_phone_digits_trgm_idx ON orders
+  USING gin ((regexp_replace(phone, '\D', '', 'g')) gin_trgm_ops);
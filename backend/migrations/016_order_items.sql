@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS order_items (
+  id bigserial PRIMARY KEY,
+  order_id uuid NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+  position integer NOT NULL DEFAULT 0,
+  perfume_id text NOT NULL,
+  brand text NOT NULL DEFAULT '',
+  name text NOT NULL DEFAULT '',
+  volume numeric NOT NULL DEFAULT 0,
+  mix text NOT NULL DEFAULT '',
+  qty integer NOT NULL CHECK (qty > 0),
+  unit_price numeric NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS order_items_order_idx ON order_items (order_id, position);
+CREATE INDEX IF NOT EXISTS order_items_perfume_idx ON order_items (perfume_id);
+
+-- Move the jsonb snapshots over; brand and name fall back to the current
+-- catalog for orders placed before they were recorded.
+DO $$
+BEGIN
+  IF EXISTS (
+    SELECT 1 FROM information_schema.columns
+    WHERE table_name = 'orders' AND column_name = 'items'
+  ) THEN
+    INSERT INTO order_items (order_id, position, perfume_id, brand, name, volume, mix, qty, unit_price)
+    SELECT o.id, item.ord::int - 1, item.value->>'id',
+      COALESCE(NULLIF(item.value->>'brand', ''), p.brand, ''),
+      COALESCE(NULLIF(item.value->>'name', ''), p.name, ''),
+      COALESCE((item.value->>'volume')::numeric, 0),
+      COALESCE(item.value->>'mix', ''),
+      (item.value->>'qty')::int,
+      COALESCE((item.value->>'price')::numeric, 0)
+    FROM orders o
+    CROSS JOIN LATERAL jsonb_array_elements(o.items) WITH ORDINALITY AS item(value, ord)
+    LEFT JOIN perfumes p ON p.id = item.value->>'id'
+    WHERE COALESCE(item.value->>'id', '') <> '' AND COALESCE((item.value->>'qty')::int, 0) > 0;
+
+    ALTER TABLE orders DROP COLUMN items;
+  END IF;
+END $$;
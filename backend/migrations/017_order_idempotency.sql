@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS order_idempotency_keys (
+  scope text NOT NULL,
+  key text NOT NULL,
+  request_hash text NOT NULL,
+  order_id uuid REFERENCES orders(id) ON DELETE SET NULL,
+  response_status integer,
+  response_body jsonb,
+  created_at timestamptz NOT NULL DEFAULT now(),
+  PRIMARY KEY (scope, key)
+);
+
+CREATE INDEX IF NOT EXISTS order_idempotency_keys_created_idx ON order_idempotency_keys (created_at);
@@ -0,0 +1,13 @@
+-- Snapshot the catalog of each sold line so analytics can be split by
+-- retail/wholesale without depending on today's catalog.
+ALTER TABLE order_items
+  ADD COLUMN IF NOT EXISTS catalog_mode text NOT NULL DEFAULT 'retail';
+
+UPDATE order_items oi
+SET catalog_mode = p.catalog_mode
+FROM perfumes p
+WHERE p.id = oi.perfume_id AND oi.catalog_mode <> p.catalog_mode;
+
+CREATE INDEX IF NOT EXISTS order_items_catalog_mode_idx ON order_items (catalog_mode, order_id);
+
+CREATE INDEX IF NOT EXISTS stat_events_daily_type_day_idx ON stat_events_daily (type, day);
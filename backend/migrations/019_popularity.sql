@@ -0,0 +1,6 @@
+ALTER TABLE perfumes
+  ADD COLUMN IF NOT EXISTS trending_score double precision NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS popularity_updated_at timestamptz;
+
+CREATE INDEX IF NOT EXISTS perfumes_trending_idx ON perfumes (catalog_mode, trending_score DESC, id);
+CREATE INDEX IF NOT EXISTS stat_events_daily_perfume_idx ON stat_events_daily (perfume_id, day);
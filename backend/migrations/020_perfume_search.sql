@@ -0,0 +1,51 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Cyrillic to Latin, mirrored by translitRuToLat in the API so that
+-- "шанель" can find "Chanel" and the other way round.
+CREATE OR REPLACE FUNCTION perfume_translit(input text) RETURNS text
+LANGUAGE sql IMMUTABLE AS $$
+  SELECT translate(
+    replace(replace(replace(replace(replace(replace(replace(replace(replace(lower(input),
+      'щ', 'sch'), 'ш', 'sh'), 'ч', 'ch'), 'ж', 'zh'), 'х', 'kh'), 'ц', 'ts'), 'ю', 'yu'), 'я', 'ya'), 'ё', 'e'),
+    'абвгдезийклмнопрстуфыэъь',
+    'abvgdeziyklmnoprstufye'
+  )
+$$;
+
+ALTER TABLE perfumes
+  ADD COLUMN IF NOT EXISTS search_vector tsvector,
+  ADD COLUMN IF NOT EXISTS search_text text NOT NULL DEFAULT '';
+
+CREATE OR REPLACE FUNCTION perfumes_search_refresh() RETURNS trigger
+LANGUAGE plpgsql AS $$
+DECLARE
+  notes text := array_to_string(NEW.notes_top || NEW.notes_heart || NEW.notes_base, ' ');
+  tags text := array_to_string(NEW.tags, ' ');
+  plain text;
+BEGIN
+  NEW.search_vector :=
+    setweight(to_tsvector('simple', concat_ws(' ', NEW.brand, NEW.name, NEW.search_name_ru)), 'A') ||
+    setweight(to_tsvector('russian', concat_ws(' ', NEW.name, NEW.search_name_ru)), 'A') ||
+    setweight(to_tsvector('russian', concat_ws(' ', NEW.family, tags)), 'B') ||
+    setweight(to_tsvector('russian', notes), 'C') ||
+    setweight(to_tsvector('russian', NEW.description), 'D');
+  plain := lower(concat_ws(' ', NEW.brand, NEW.name, NEW.search_name_ru, NEW.family, tags, notes));
+  NEW.search_text := plain || ' ' || perfume_translit(plain);
+  RETURN NEW;
+END
+$$;
+
+DROP TRIGGER IF EXISTS perfumes_search_refresh ON perfumes;
+CREATE TRIGGER perfumes_search_refresh
+  BEFORE INSERT OR UPDATE OF brand, name, search_name_ru, family, description, tags, notes_top, notes_heart, notes_base
+  ON perfumes
+  FOR EACH ROW EXECUTE FUNCTION perfumes_search_refresh();
+
+UPDATE perfumes SET brand = brand;
+
+CREATE INDEX IF NOT EXISTS perfumes_search_vector_idx ON perfumes USING gin (search_vector);
+CREATE INDEX IF NOT EXISTS perfumes_search_text_trgm_idx ON perfumes USING gin (search_text gin_trgm_ops);
+
+-- Typo-tolerant matches use "q <% search_text", which the trigram index
+-- supports. Its threshold is the pg_trgm.word_similarity_threshold setting,
+-- which the API sets per search transaction (beginPerfumeSearch).
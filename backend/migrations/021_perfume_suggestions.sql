@@ -0,0 +1,55 @@
+-- Autocomplete dictionary: one row per suggestion and lookup key, so that a
+-- prefix search is a single index range scan. Rebuilt by
+-- refresh_perfume_suggestions() whenever the catalog changes.
+CREATE TABLE IF NOT EXISTS perfume_suggestions (
+  kind text NOT NULL CHECK (kind IN ('brand', 'perfume', 'note')),
+  term text NOT NULL,
+  brand text NOT NULL DEFAULT '',
+  perfume_id text NOT NULL DEFAULT '',
+  match_key text NOT NULL,
+  retail_count int NOT NULL DEFAULT 0,
+  wholesale_count int NOT NULL DEFAULT 0,
+  PRIMARY KEY (kind, term, perfume_id, match_key)
+);
+
+CREATE INDEX IF NOT EXISTS perfume_suggestions_match_idx
+  ON perfume_suggestions (match_key text_pattern_ops);
+
+-- Concurrent catalog writes each rebuild the dictionary; the transaction
+-- level advisory lock runs the rebuilds one after another, so they cannot
+-- insert the same keys at the same time.
+CREATE OR REPLACE FUNCTION refresh_perfume_suggestions() RETURNS void
+LANGUAGE sql AS $$
+  SELECT pg_advisory_xact_lock(hashtext('refresh_perfume_suggestions'));
+
+  DELETE FROM perfume_suggestions;
+
+  WITH terms AS (
+    SELECT 'brand' AS kind, brand AS term, '' AS brand, '' AS perfume_id,
+      NULL::text AS alias, id AS source_id, catalog_mode
+    FROM perfumes
+    WHERE brand <> ''
+    UNION ALL
+    SELECT 'perfume', name, brand, id, NULLIF(lower(search_name_ru), ''), id, catalog_mode
+    FROM perfumes
+    WHERE name <> ''
+    UNION ALL
+    SELECT 'note', note, '', '', NULL, p.id, p.catalog_mode
+    FROM perfumes p, unnest(p.notes_top || p.notes_heart || p.notes_base) AS note
+    WHERE note <> ''
+  ), counted AS (
+    SELECT kind, term, brand, perfume_id,
+      COUNT(DISTINCT source_id) FILTER (WHERE catalog_mode = 'retail') AS retail_count,
+      COUNT(DISTINCT source_id) FILTER (WHERE catalog_mode = 'wholesale') AS wholesale_count,
+      array_agg(DISTINCT alias) FILTER (WHERE alias IS NOT NULL) AS aliases
+    FROM terms
+    GROUP BY kind, term, brand, perfume_id
+  )
+  INSERT INTO perfume_suggestions (kind, term, brand, perfume_id, match_key, retail_count, wholesale_count)
+  SELECT DISTINCT c.kind, c.term, c.brand, c.perfume_id, k.match_key, c.retail_count, c.wholesale_count
+  FROM counted c,
+    unnest(ARRAY[lower(c.term), perfume_translit(c.term)] || COALESCE(c.aliases, '{}')) AS k(match_key)
+  WHERE k.match_key <> '';
+$$;
+
+SELECT refresh_perfume_suggestions();
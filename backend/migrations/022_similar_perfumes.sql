@@ -0,0 +1,15 @@
+-- Helpers for the "similar perfumes" score.
+CREATE OR REPLACE FUNCTION text_array_overlap(a text[], b text[]) RETURNS int
+LANGUAGE sql IMMUTABLE AS $$
+  SELECT cardinality(ARRAY(SELECT unnest(a) INTERSECT SELECT unnest(b)))
+$$;
+
+CREATE OR REPLACE FUNCTION text_array_jaccard(a text[], b text[]) RETURNS float8
+LANGUAGE sql IMMUTABLE AS $$
+  SELECT COALESCE(
+    text_array_overlap(a, b)::float8 / NULLIF(cardinality(ARRAY(SELECT unnest(a) UNION SELECT unnest(b))), 0),
+    0
+  )
+$$;
+
+CREATE INDEX IF NOT EXISTS perfumes_mode_in_stock_idx ON perfumes (catalog_mode, in_stock);
@@ -0,0 +1,14 @@
+-- Co-purchase model rebuilt by the affinity job: how strongly related_id goes
+-- together with perfume_id in orders and carts. Both directions are stored.
+CREATE TABLE IF NOT EXISTS perfume_affinity (
+  perfume_id text NOT NULL REFERENCES perfumes(id) ON DELETE CASCADE,
+  related_id text NOT NULL REFERENCES perfumes(id) ON DELETE CASCADE,
+  orders int NOT NULL DEFAULT 0,
+  carts int NOT NULL DEFAULT 0,
+  score float8 NOT NULL DEFAULT 0,
+  updated_at timestamptz NOT NULL DEFAULT now(),
+  PRIMARY KEY (perfume_id, related_id)
+);
+
+CREATE INDEX IF NOT EXISTS perfume_affinity_score_idx ON perfume_affinity (perfume_id, score DESC);
+CREATE INDEX IF NOT EXISTS carts_updated_at_idx ON carts (updated_at);
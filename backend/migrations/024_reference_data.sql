@@ -0,0 +1,126 @@
+-- Brands, families and notes dictionaries. perfumes keep the canonical name
+-- in their text columns; aliases and the Russian/English names only serve to
+-- find that name, case- and ё-insensitively.
+CREATE OR REPLACE FUNCTION reference_key(input text) RETURNS text
+LANGUAGE sql IMMUTABLE AS $$
+  SELECT replace(lower(btrim(input)), 'ё', 'е')
+$$;
+
+CREATE OR REPLACE FUNCTION reference_keys(name text, name_ru text, name_en text, aliases text[]) RETURNS text[]
+LANGUAGE sql IMMUTABLE AS $$
+  SELECT ARRAY(
+    SELECT DISTINCT reference_key(v)
+    FROM unnest(ARRAY[name, name_ru, name_en] || aliases) v
+    WHERE reference_key(v) <> ''
+  )
+$$;
+
+-- Mirrored by referenceSlug in the API.
+CREATE OR REPLACE FUNCTION reference_slug(input text) RETURNS text
+LANGUAGE sql IMMUTABLE AS $$
+  SELECT btrim(regexp_replace(perfume_translit(reference_key(input)), '[^a-z0-9]+', '-', 'g'), '-')
+$$;
+
+CREATE TABLE IF NOT EXISTS brands (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  slug text NOT NULL UNIQUE,
+  name text NOT NULL,
+  name_ru text NOT NULL DEFAULT '',
+  name_en text NOT NULL DEFAULT '',
+  aliases text[] NOT NULL DEFAULT ARRAY[]::text[],
+  match_keys text[] GENERATED ALWAYS AS (reference_keys(name, name_ru, name_en, aliases)) STORED,
+  created_at timestamptz NOT NULL DEFAULT now(),
+  updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS families (LIKE brands INCLUDING ALL);
+CREATE TABLE IF NOT EXISTS notes (LIKE brands INCLUDING ALL);
+
+CREATE UNIQUE INDEX IF NOT EXISTS brands_name_key ON brands (reference_key(name));
+CREATE UNIQUE INDEX IF NOT EXISTS families_name_key ON families (reference_key(name));
+CREATE UNIQUE INDEX IF NOT EXISTS notes_name_key ON notes (reference_key(name));
+CREATE INDEX IF NOT EXISTS brands_match_keys_idx ON brands USING gin (match_keys);
+CREATE INDEX IF NOT EXISTS families_match_keys_idx ON families USING gin (match_keys);
+CREATE INDEX IF NOT EXISTS notes_match_keys_idx ON notes USING gin (match_keys);
+
+-- canonical_notes maps every note of input to its dictionary name, keeping
+-- the first position of each note and dropping duplicates. Unknown notes
+-- are kept as they are.
+CREATE OR REPLACE FUNCTION canonical_notes(input text[]) RETURNS text[]
+LANGUAGE sql STABLE AS $$
+  SELECT COALESCE(array_agg(name ORDER BY first), ARRAY[]::text[])
+  FROM (
+    SELECT COALESCE(n.name, t.v) AS name, min(t.i) AS first
+    FROM unnest(input) WITH ORDINALITY AS t(v, i)
+    LEFT JOIN notes n ON reference_key(t.v) = ANY(n.match_keys)
+    GROUP BY 1
+  ) s
+$$;
+
+-- Seed the dictionaries from the catalog. The most used spelling of a value
+-- becomes the canonical name; colliding slugs get a numeric suffix.
+WITH used AS (
+  SELECT brand AS v, COUNT(*) AS n FROM perfumes WHERE btrim(brand) <> '' GROUP BY 1
+), names AS (
+  SELECT DISTINCT ON (reference_key(v)) btrim(v) AS name
+  FROM used
+  ORDER BY reference_key(v), n DESC, v
+), slugged AS (
+  SELECT name, COALESCE(NULLIF(reference_slug(name), ''), 'brand') AS slug FROM names
+), numbered AS (
+  SELECT name, slug, row_number() OVER (PARTITION BY slug ORDER BY name) AS k FROM slugged
+)
+INSERT INTO brands (slug, name)
+SELECT slug || CASE WHEN k > 1 THEN '-' || k ELSE '' END, name FROM numbered
+ON CONFLICT DO NOTHING;
+
+WITH used AS (
+  SELECT family AS v, COUNT(*) AS n FROM perfumes WHERE btrim(family) <> '' GROUP BY 1
+), names AS (
+  SELECT DISTINCT ON (reference_key(v)) btrim(v) AS name
+  FROM used
+  ORDER BY reference_key(v), n DESC, v
+), slugged AS (
+  SELECT name, COALESCE(NULLIF(reference_slug(name), ''), 'family') AS slug FROM names
+), numbered AS (
+  SELECT name, slug, row_number() OVER (PARTITION BY slug ORDER BY name) AS k FROM slugged
+)
+INSERT INTO families (slug, name)
+SELECT slug || CASE WHEN k > 1 THEN '-' || k ELSE '' END, name FROM numbered
+ON CONFLICT DO NOTHING;
+
+WITH used AS (
+  SELECT v, COUNT(*) AS n
+  FROM perfumes, unnest(notes_top || notes_heart || notes_base) v
+  WHERE btrim(v) <> ''
+  GROUP BY 1
+), names AS (
+  SELECT DISTINCT ON (reference_key(v)) btrim(v) AS name
+  FROM used
+  ORDER BY reference_key(v), n DESC, v
+), slugged AS (
+  SELECT name, COALESCE(NULLIF(reference_slug(name), ''), 'note') AS slug FROM names
+), numbered AS (
+  SELECT name, slug, row_number() OVER (PARTITION BY slug ORDER BY name) AS k FROM slugged
+)
+INSERT INTO notes (slug, name)
+SELECT slug || CASE WHEN k > 1 THEN '-' || k ELSE '' END, name FROM numbered
+ON CONFLICT DO NOTHING;
+
+UPDATE perfumes p SET brand = b.name
+FROM brands b
+WHERE reference_key(p.brand) = ANY(b.match_keys) AND p.brand <> b.name;
+
+UPDATE perfumes p SET family = f.name
+FROM families f
+WHERE reference_key(p.family) = ANY(f.match_keys) AND p.family <> f.name;
+
+UPDATE perfumes SET
+  notes_top = canonical_notes(notes_top),
+  notes_heart = canonical_notes(notes_heart),
+  notes_base = canonical_notes(notes_base)
+WHERE notes_top <> canonical_notes(notes_top)
+   OR notes_heart <> canonical_notes(notes_heart)
+   OR notes_base <> canonical_notes(notes_base);
+
+SELECT refresh_perfume_suggestions();
@@ -0,0 +1,16 @@
+-- Landing page text of brands and notes, edited by admins. An empty
+-- hero_image falls back to the image of the most popular perfume.
+ALTER TABLE brands
+  ADD COLUMN IF NOT EXISTS title text NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS description text NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS hero_image text NOT NULL DEFAULT '';
+
+ALTER TABLE families
+  ADD COLUMN IF NOT EXISTS title text NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS description text NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS hero_image text NOT NULL DEFAULT '';
+
+ALTER TABLE notes
+  ADD COLUMN IF NOT EXISTS title text NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS description text NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS hero_image text NOT NULL DEFAULT '';
@@ -0,0 +1,86 @@
+-- A perfume is sold in several volumes and mixes, each with its own SKU,
+-- price and stock. base_price, base_volume, stock_qty and in_stock on
+-- perfumes stay as a summary of the variants for sorting and filtering:
+-- price and volume of the default variant, total tracked stock.
+CREATE TABLE IF NOT EXISTS perfume_variants (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  perfume_id text NOT NULL REFERENCES perfumes(id) ON DELETE CASCADE,
+  volume integer NOT NULL CHECK (volume > 0),
+  mix text NOT NULL DEFAULT '',
+  sku text NOT NULL UNIQUE,
+  price numeric NOT NULL DEFAULT 0 CHECK (price >= 0),
+  stock_qty integer CHECK (stock_qty >= 0),
+  barcode text NOT NULL DEFAULT '',
+  is_default boolean NOT NULL DEFAULT false,
+  position integer NOT NULL DEFAULT 0,
+  created_at timestamptz NOT NULL DEFAULT now(),
+  updated_at timestamptz NOT NULL DEFAULT now(),
+  UNIQUE (perfume_id, volume, mix)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS perfume_variants_default_idx ON perfume_variants (perfume_id) WHERE is_default;
+CREATE UNIQUE INDEX IF NOT EXISTS perfume_variants_barcode_idx ON perfume_variants (barcode) WHERE barcode <> '';
+
+-- sync_perfume_from_variants copies the variant summary to the perfume.
+-- Stock is only tracked on the perfume when every variant tracks it;
+-- otherwise in_stock stays a manual flag as before.
+CREATE OR REPLACE FUNCTION sync_perfume_from_variants(pid text) RETURNS void
+LANGUAGE sql AS $$
+  UPDATE perfumes p SET
+    base_price = d.price,
+    base_volume = d.volume,
+    stock_qty = s.stock_qty,
+    in_stock = CASE WHEN s.stock_qty IS NULL THEN p.in_stock ELSE s.stock_qty > 0 END
+  FROM (SELECT price, volume FROM perfume_variants WHERE perfume_id = pid AND is_default) d,
+       (SELECT CASE WHEN bool_and(stock_qty IS NOT NULL) THEN SUM(stock_qty)::int END AS stock_qty
+        FROM perfume_variants WHERE perfume_id = pid) s
+  WHERE p.id = pid
+$$;
+
+-- sync_default_variant applies perfume-level edits of base_price,
+-- base_volume and stock_qty to the default variant, creating it for new
+-- perfumes. Stock is only copied while the default is the only variant.
+CREATE OR REPLACE FUNCTION sync_default_variant(pid text) RETURNS void
+LANGUAGE plpgsql AS $$
+BEGIN
+  UPDATE perfume_variants v SET
+    price = p.base_price,
+    volume = p.base_volume,
+    stock_qty = CASE
+      WHEN (SELECT COUNT(*) FROM perfume_variants WHERE perfume_id = pid) = 1 THEN p.stock_qty
+      ELSE v.stock_qty
+    END,
+    updated_at = now()
+  FROM perfumes p
+  WHERE v.perfume_id = pid AND v.is_default AND p.id = pid
+    AND (v.price, v.volume, v.stock_qty) IS DISTINCT FROM (p.base_price, p.base_volume, p.stock_qty);
+
+  INSERT INTO perfume_variants (perfume_id, volume, mix, sku, price, stock_qty, is_default)
+  SELECT p.id, p.base_volume, '60/40', p.id || '-' || p.base_volume, p.base_price, p.stock_qty, true
+  FROM perfumes p
+  WHERE p.id = pid AND NOT EXISTS (SELECT 1 FROM perfume_variants WHERE perfume_id = pid)
+  ON CONFLICT DO NOTHING;
+
+  PERFORM sync_perfume_from_variants(pid);
+END
+$$;
+
+-- The storefront's default mix is 60/40.
+INSERT INTO perfume_variants (perfume_id, volume, mix, sku, price, stock_qty, is_default)
+SELECT id, GREATEST(base_volume, 1), '60/40', id || '-' || GREATEST(base_volume, 1), base_price, stock_qty, true
+FROM perfumes
+WHERE NOT EXISTS (SELECT 1 FROM perfume_variants v WHERE v.perfume_id = perfumes.id)
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE order_items
+  ADD COLUMN IF NOT EXISTS variant_id uuid REFERENCES perfume_variants(id) ON DELETE SET NULL,
+  ADD COLUMN IF NOT EXISTS sku text NOT NULL DEFAULT '';
+
+-- Past order lines point at the variant of their volume and mix. Lines no
+-- variant matches keep no variant and no SKU rather than borrowing the
+-- default variant's.
+UPDATE order_items oi SET variant_id = v.id, sku = v.sku
+FROM perfume_variants v
+WHERE oi.variant_id IS NULL AND v.perfume_id = oi.perfume_id AND v.volume = oi.volume AND v.mix = oi.mix;
+
+CREATE INDEX IF NOT EXISTS order_items_variant_idx ON order_items (variant_id);
@@ -0,0 +1,56 @@
+-- Every catalog edit of a perfume stores a snapshot of its editable fields,
+-- keyed by the perfumeEditableFields paths so that a snapshot can be written
+-- back as a PATCH. Stock is left out: restoring a description must not
+-- bring back old stock levels. Versions outlive their perfume, so
+-- perfume_id is not a foreign key.
+CREATE TABLE IF NOT EXISTS perfume_versions (
+  perfume_id text NOT NULL,
+  version integer NOT NULL,
+  snapshot jsonb NOT NULL,
+  changed_by uuid REFERENCES users(id) ON DELETE SET NULL,
+  restored_from integer,
+  created_at timestamptz NOT NULL DEFAULT now(),
+  PRIMARY KEY (perfume_id, version)
+);
+
+CREATE OR REPLACE FUNCTION perfume_snapshot(p perfumes) RETURNS jsonb
+LANGUAGE sql IMMUTABLE AS $$
+  SELECT jsonb_build_object(
+    'catalogMode', p.catalog_mode,
+    'brand', p.brand,
+    'name', p.name,
+    'family', p.family,
+    'description', p.description,
+    'tags', to_jsonb(p.tags),
+    'notes.top', to_jsonb(p.notes_top),
+    'notes.heart', to_jsonb(p.notes_heart),
+    'notes.base', to_jsonb(p.notes_base),
+    'seasons', to_jsonb(p.seasons),
+    'dayNight', to_jsonb(p.day_night),
+    'basePrice', p.base_price,
+    'baseVolume', p.base_volume,
+    'sillage', p.sillage,
+    'longevity', p.longevity,
+    'image', p.image_url,
+    'searchNameRu', p.search_name_ru,
+    'isHit', p.is_hit,
+    'currency', p.currency
+  )
+$$;
+
+-- record_perfume_version stores the current state of a perfume as its next
+-- version, unless it equals the latest one.
+CREATE OR REPLACE FUNCTION record_perfume_version(pid text, by uuid, restored integer) RETURNS void
+LANGUAGE sql AS $$
+  INSERT INTO perfume_versions (perfume_id, version, snapshot, changed_by, restored_from)
+  SELECT p.id, COALESCE(l.version, 0) + 1, perfume_snapshot(p), by, restored
+  FROM perfumes p
+  LEFT JOIN LATERAL (
+    SELECT version, snapshot FROM perfume_versions WHERE perfume_id = p.id ORDER BY version DESC LIMIT 1
+  ) l ON true
+  WHERE p.id = pid AND l.snapshot IS DISTINCT FROM perfume_snapshot(p)
+  ON CONFLICT (perfume_id, version) DO NOTHING
+$$;
+
+-- The current catalog is version 1 of every perfume.
+SELECT record_perfume_version(id, NULL, NULL) FROM perfumes;
@@ -0,0 +1,44 @@
+-- Scheduled sale prices. A rule without variant_id applies to the default
+-- variant, i.e. to the price the catalog shows and sorts by. When several
+-- rules are active the lowest sale price wins; a rule only counts while it
+-- is below the regular price.
+CREATE TABLE IF NOT EXISTS price_rules (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  perfume_id text NOT NULL REFERENCES perfumes(id) ON DELETE CASCADE,
+  variant_id uuid REFERENCES perfume_variants(id) ON DELETE CASCADE,
+  sale_price numeric NOT NULL CHECK (sale_price >= 0),
+  starts_at timestamptz NOT NULL DEFAULT now(),
+  ends_at timestamptz,
+  note text NOT NULL DEFAULT '',
+  created_at timestamptz NOT NULL DEFAULT now(),
+  updated_at timestamptz NOT NULL DEFAULT now(),
+  CHECK (ends_at IS NULL OR ends_at > starts_at)
+);
+
+CREATE INDEX IF NOT EXISTS price_rules_perfume_idx ON price_rules (perfume_id, starts_at);
+
+-- variant_sale returns the sale active now for a variant, if any.
+CREATE OR REPLACE FUNCTION variant_sale(vid uuid)
+RETURNS TABLE (sale_price numeric, ends_at timestamptz)
+LANGUAGE sql STABLE AS $$
+  SELECT r.sale_price, r.ends_at
+  FROM perfume_variants v
+  JOIN price_rules r ON r.perfume_id = v.perfume_id
+   AND (r.variant_id = v.id OR (r.variant_id IS NULL AND v.is_default))
+  WHERE v.id = vid
+    AND r.starts_at <= now() AND (r.ends_at IS NULL OR r.ends_at > now())
+    AND r.sale_price < v.price
+  ORDER BY r.sale_price, r.ends_at NULLS LAST
+  LIMIT 1
+$$;
+
+-- current_price is the price of a perfume now: the sale price of its
+-- default variant, or base.
+CREATE OR REPLACE FUNCTION current_price(pid text, base numeric) RETURNS numeric
+LANGUAGE sql STABLE AS $$
+  SELECT COALESCE((
+    SELECT s.sale_price
+    FROM perfume_variants v, variant_sale(v.id) s
+    WHERE v.perfume_id = pid AND v.is_default
+  ), base)
+$$;
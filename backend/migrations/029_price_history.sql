@@ -0,0 +1,100 @@
+-- Price history of the catalog price of every perfume. A 'base' entry is
+-- written whenever base_price changes, whichever path changed it (upsert,
+-- PATCH, import, restore or a variant edit), and lasts until the next one.
+-- A 'sale' entry is written whenever a price rule of the default variant is
+-- created, changed or deleted and covers starts_at..ends_at until the next
+-- entry of the same rule is written. Entries are never updated or removed,
+-- and outlive their perfume, so perfume_id is not a foreign key.
+CREATE TABLE IF NOT EXISTS price_history (
+  id bigserial PRIMARY KEY,
+  perfume_id text NOT NULL,
+  source text NOT NULL CHECK (source IN ('base', 'sale')),
+  price numeric NOT NULL,
+  rule_id uuid,
+  starts_at timestamptz NOT NULL DEFAULT now(),
+  ends_at timestamptz,
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS price_history_perfume_idx ON price_history (perfume_id, id);
+
+CREATE OR REPLACE FUNCTION perfumes_record_base_price() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+  IF TG_OP = 'INSERT' OR NEW.base_price IS DISTINCT FROM OLD.base_price THEN
+    INSERT INTO price_history (perfume_id, source, price) VALUES (NEW.id, 'base', NEW.base_price);
+  END IF;
+  RETURN NULL;
+END
+$$;
+
+DROP TRIGGER IF EXISTS perfumes_record_base_price ON perfumes;
+CREATE TRIGGER perfumes_record_base_price
+  AFTER INSERT OR UPDATE OF base_price
+  ON perfumes
+  FOR EACH ROW EXECUTE FUNCTION perfumes_record_base_price();
+
+-- A deleted rule that already started ends now; one that did not start yet
+-- never applied. Rules removed together with their perfume or their variant
+-- leave no entry, and like on insert only rules of the default variant count.
+CREATE OR REPLACE FUNCTION price_rules_record_sale() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    INSERT INTO price_history (perfume_id, source, price, rule_id, starts_at, ends_at)
+    SELECT p.id, 'sale', OLD.sale_price, OLD.id, OLD.starts_at,
+           CASE WHEN OLD.starts_at > now() THEN OLD.starts_at
+                ELSE LEAST(COALESCE(OLD.ends_at, 'infinity'), now()) END
+    FROM perfumes p
+    WHERE p.id = OLD.perfume_id
+      AND (OLD.variant_id IS NULL OR EXISTS (SELECT 1 FROM perfume_variants WHERE id = OLD.variant_id AND is_default));
+    RETURN NULL;
+  END IF;
+  IF NEW.variant_id IS NULL OR EXISTS (SELECT 1 FROM perfume_variants WHERE id = NEW.variant_id AND is_default) THEN
+    INSERT INTO price_history (perfume_id, source, price, rule_id, starts_at, ends_at)
+    VALUES (NEW.perfume_id, 'sale', NEW.sale_price, NEW.id, NEW.starts_at, NEW.ends_at);
+  END IF;
+  RETURN NULL;
+END
+$$;
+
+DROP TRIGGER IF EXISTS price_rules_record_sale ON price_rules;
+CREATE TRIGGER price_rules_record_sale
+  AFTER INSERT OR UPDATE OR DELETE
+  ON price_rules
+  FOR EACH ROW EXECUTE FUNCTION price_rules_record_sale();
+
+-- lowest_price is the lowest catalog price a perfume had at any moment
+-- since the given time, sales included. A sale entry applied from starts_at
+-- until its ends_at or the next entry of its rule, whichever came first, so
+-- a rule that was shortened or changed still counts for the time it ran.
+CREATE OR REPLACE FUNCTION lowest_price(pid text, since timestamptz) RETURNS numeric
+LANGUAGE sql STABLE AS $$
+  WITH base AS (
+    SELECT price, lead(starts_at) OVER (ORDER BY starts_at, id) AS ends_at
+    FROM price_history
+    WHERE perfume_id = pid AND source = 'base'
+  ), sale AS (
+    -- LEAST ignores NULLs: an open rule without a later entry stays open.
+    SELECT price, starts_at,
+           LEAST(ends_at, lead(created_at) OVER (PARTITION BY rule_id ORDER BY id)) AS ends_at
+    FROM price_history
+    WHERE perfume_id = pid AND source = 'sale'
+  )
+  SELECT min(price) FROM (
+    SELECT price FROM base WHERE ends_at IS NULL OR ends_at > since
+    UNION ALL
+    SELECT s.price FROM sale s
+    WHERE s.starts_at <= now() AND (s.ends_at IS NULL OR s.ends_at > GREATEST(s.starts_at, since))
+  ) t
+$$;
+
+-- History starts with the current prices and the rules already saved.
+INSERT INTO price_history (perfume_id, source, price)
+SELECT id, 'base', base_price FROM perfumes;
+
+INSERT INTO price_history (perfume_id, source, price, rule_id, starts_at, ends_at)
+SELECT r.perfume_id, 'sale', r.sale_price, r.id, r.starts_at, r.ends_at
+FROM price_rules r
+LEFT JOIN perfume_variants v ON v.id = r.variant_id
+WHERE r.variant_id IS NULL OR v.is_default;
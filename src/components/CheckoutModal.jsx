@@ -3,7 +3,13 @@ import { X, Copy, MessageCircle, Send, Instagram } from "lucide-react";
 import { THEME } from "../data/theme";
 import { SELLER_CONTACTS } from "../data/sellerContacts";
 import { useAuth } from "../state/auth";
-import { createOrder } from "../services/ordersRepo";
+import { createOrder, fetchDeliveryMethods } from "../services/ordersRepo";
+
+// Shown until the server list loads, or if it cannot be loaded.
+const FALLBACK_DELIVERY_METHODS = [
+  { id: "pickup", title: "Самовывоз", requiresAddress: false },
+  { id: "delivery", title: "Доставка", requiresAddress: true },
+];
 
 function formatLine(item) {
   const p = item.perfume || {};
@@ -20,8 +26,8 @@ function formatLine(item) {
 function buildMessage(items, total, delivery, contact) {
   const currency = (items[0]?.perfume?.currency) || "₽";
   const lines = items.map(formatLine).join("\n");
-  const method = delivery?.method === "delivery" ? "Доставка" : "Самовывоз";
-  const address = delivery?.method === "delivery" ? String(delivery?.address || "").trim() : "";
+  const method = delivery?.title || "Самовывоз";
+  const address = delivery?.requiresAddress ? String(delivery?.address || "").trim() : "";
   const name = String(contact?.name || "").trim();
   const phone = String(contact?.phone || "").trim();
   const email = String(contact?.email || "").trim();
@@ -101,6 +107,7 @@ function isValidPhone(value) {
 
 export default function CheckoutModal({ open, items, total, onClose }) {
   const [copied, setCopied] = useState(false);
+  const [deliveryMethods, setDeliveryMethods] = useState(FALLBACK_DELIVERY_METHODS);
   const [deliveryMethod, setDeliveryMethod] = useState("pickup");
   const [address, setAddress] = useState("");
   const [contactName, setContactName] = useState("");
@@ -108,17 +115,37 @@ export default function CheckoutModal({ open, items, total, onClose }) {
   const [contactEmail, setContactEmail] = useState("");
   const [phoneCursor, setPhoneCursor] = useState(null);
   const phoneRef = useRef(null);
+  const orderKeyRef = useRef({ scope: "", key: "" });
   const { user } = useAuth();
 
-  const delivery = { method: deliveryMethod, address };
+  const selectedMethod = deliveryMethods.find((m) => m.id === deliveryMethod) || deliveryMethods[0];
+  const needsAddress = Boolean(selectedMethod?.requiresAddress);
+  const delivery = { method: deliveryMethod, title: selectedMethod?.title, requiresAddress: needsAddress, address };
   const contact = { name: contactName, phone: contactPhone, email: contactEmail };
   const guestNeedsPhone = Boolean(user?.isAnonymous);
   const phoneRequiredMissing = guestNeedsPhone && !String(contactPhone || "").trim();
   const phoneInvalid = guestNeedsPhone && String(contactPhone || "").trim() && !isValidPhone(contactPhone);
   const message = useMemo(
     () => buildMessage(items || [], total || 0, delivery, contact),
-    [items, total, deliveryMethod, address, contactName, contactPhone, contactEmail]
+    [items, total, deliveryMethod, deliveryMethods, address, contactName, contactPhone, contactEmail]
   );
+
+  React.useEffect(() => {
+    if (!open) return undefined;
+    let cancelled = false;
+    fetchDeliveryMethods()
+      .then((list) => {
+        if (cancelled || !Array.isArray(list) || !list.length) return;
+        setDeliveryMethods(list);
+        setDeliveryMethod((current) => (list.some((m) => m.id === current) ? current : list[0].id));
+      })
+      .catch(() => {
+        // остаёмся на стандартных способах
+      });
+    return () => {
+      cancelled = true;
+    };
+  }, [open]);
   const encoded = useMemo(() => encodeURIComponent(message), [message]);
 
   React.useEffect(() => {
@@ -136,6 +163,12 @@ export default function CheckoutModal({ open, items, total, onClose }) {
 
   const openWithCopy = async (url, channel) => {
     if (phoneRequiredMissing || phoneInvalid) return;
+    // Повторная отправка того же заказа не создаёт дубликат на сервере.
+    const scope = `${channel}\n${message}`;
+    if (orderKeyRef.current.scope !== scope) {
+      const key = globalThis.crypto?.randomUUID?.() || `${Date.now()}-${Math.random().toString(36).slice(2)}`;
+      orderKeyRef.current = { scope, key };
+    }
     try {
       await createOrder({
         user,
@@ -143,7 +176,8 @@ export default function CheckoutModal({ open, items, total, onClose }) {
         total,
         channel,
         contact,
-        delivery: { method: deliveryMethod, address },
+        delivery: { method: deliveryMethod, address: needsAddress ? address : "" },
+        idempotencyKey: orderKeyRef.current.key,
       });
     } catch {
       // не ломаем UX, если запись заказа не удалась
@@ -308,8 +342,12 @@ export default function CheckoutModal({ open, items, total, onClose }) {
                 className="mt-2 w-full rounded-2xl border bg-transparent px-3 py-2 text-sm outline-none focus:ring-2 focus:ring-[rgba(127,122,73,0.40)]"
                 style={{ borderColor: THEME.border2, color: THEME.text }}
               >
-                <option value="pickup">Самовывоз</option>
-                <option value="delivery">Доставка</option>
+                {deliveryMethods.map((m) => (
+                  <option key={m.id} value={m.id}>
+                    {m.title}
+                    {Number(m.price) > 0 ? ` · ${m.price}₽` : ""}
+                  </option>
+                ))}
               </select>
             </label>
             <label className="text-xs" style={{ color: THEME.muted }}>
@@ -317,14 +355,14 @@ export default function CheckoutModal({ open, items, total, onClose }) {
               <input
                 value={address}
                 onChange={(e) => setAddress(e.target.value)}
-                disabled={deliveryMethod !== "delivery"}
+                disabled={!needsAddress}
                 placeholder="Город, улица, дом, квартира"
                 className="mt-2 w-full rounded-2xl border bg-transparent px-3 py-2 text-sm outline-none focus:ring-2 focus:ring-[rgba(127,122,73,0.40)]"
                 style={{ borderColor: THEME.border2, color: THEME.text }}
               />
             </label>
           </div>
-          {deliveryMethod === "delivery" && !address.trim() ? (
+          {needsAddress && !address.trim() ? (
             <div className="mt-2 text-xs" style={{ color: THEME.muted2 }}>
               Укажи адрес — он попадёт в текст заказа.
             </div>
@@ -121,7 +121,7 @@ export default function PerfumeCard({
   const scoreLabel =
     score >= 10 ? "Точное попадание" : score >= 6 ? "Хорошо подходит" : score >= 3 ? "Похоже" : "Слабое совпадение";
 
-  const computedPrice = priceForVolume(perfume.price, volume, perfume.baseVolume, mix);
+  const computedPrice = priceForVolume(perfume.price, volume, perfume.baseVolume, mix, perfume.variants);
   const summaryCount = Number(reviewSummary?.count ?? 0);
   const summaryAvg = Number(reviewSummary?.avg ?? 0);
   const reviewCount = summaryCount || Number(perfume.ratingCount ?? perfume.reviewCount ?? 0);
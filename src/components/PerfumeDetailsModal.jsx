@@ -124,7 +124,7 @@ export default function PerfumeDetailsModal({
   const [reviewFormOpen, setReviewFormOpen] = React.useState(false);
 
   const title = perfume ? `${perfume.brand} — ${perfume.name}` : "";
-  const price = perfume ? priceForVolume(perfume.price, volume, perfume.baseVolume, mix) : 0;
+  const price = perfume ? priceForVolume(perfume.price, volume, perfume.baseVolume, mix, perfume.variants) : 0;
 
   React.useEffect(() => {
     if (!open || !perfume?.id) return;
@@ -15,10 +15,12 @@ const MIX_PRICE_TABLE = {
   },
 };
 
-export function priceForVolume(basePrice, volume, baseVolume, mix) {
+export function priceForVolume(basePrice, volume, baseVolume, mix, variants) {
   const bv = Number(baseVolume) || 50;
   const v = Number(volume) || bv;
   const ratio = mix || "60/40";
+  const variant = (variants || []).find((x) => Number(x.volume) === v && x.mix === ratio);
+  if (variant) return Number(variant.salePrice ?? variant.price) || 0;
   const table = MIX_PRICE_TABLE[ratio];
   if (table && table[v] != null) return table[v];
   const p = Number(basePrice) || 0;
@@ -908,6 +908,7 @@ export default function AdminPage() {
       longevity: Number(p.longevity || 3),
       image: p.image || "",
       currency: p.currency || "₽",
+      version: p.version || "",
     });
     setEditorOpen(true);
   }
@@ -951,7 +952,9 @@ export default function AdminPage() {
 
     setSaving(true);
     try {
-      await upsertPerfume(id, payload, catalogMode === "wholesale" ? "wholesale" : "retail");
+      await upsertPerfume(id, payload, catalogMode === "wholesale" ? "wholesale" : "retail", {
+        version: draft.version,
+      });
       await load();
       setEditorOpen(false);
     } catch (e) {
@@ -32,7 +32,7 @@ export default function CartPage() {
             sum: 0,
           };
         }
-        const unit = priceForVolume(perfume.basePrice ?? perfume.price, row.volume, perfume.baseVolume, row.mix);
+        const unit = priceForVolume(perfume.basePrice ?? perfume.price, row.volume, perfume.baseVolume, row.mix, perfume.variants);
         const sum = unit * row.qty;
         return { ...row, perfume, unit, sum };
       })
@@ -6,6 +6,7 @@ import { priceForVolume } from "../lib/scoring";
 import { clearJsonLd, setCanonical, setJsonLd, setMeta, setOpenGraphImage } from "../lib/seo";
 import { useShop } from "../state/shop";
 import SafeImage from "../components/SafeImage";
+import { fetchSimilarPerfumes } from "../services/perfumesRepo";
 
 export default function PerfumePage() {
   const navigate = useNavigate();
@@ -116,7 +117,7 @@ export default function PerfumePage() {
   }
 
   const liked = favorites.includes(perfume.id);
-  const price = priceForVolume(perfume.basePrice ?? perfume.price, volume, perfume.baseVolume, mix);
+  const price = priceForVolume(perfume.basePrice ?? perfume.price, volume, perfume.baseVolume, mix, perfume.variants);
   const noteLinks = useMemo(() => {
     if (!perfume?.notes) return [];
     const all = [
@@ -130,7 +131,27 @@ export default function PerfumePage() {
     return uniq.slice(0, 10);
   }, [perfume]);
 
+  const [similarFromApi, setSimilarFromApi] = React.useState(null);
+
+  React.useEffect(() => {
+    if (!id) return;
+    let alive = true;
+    setSimilarFromApi(null);
+    fetchSimilarPerfumes(id, 6)
+      .then((items) => {
+        if (alive) setSimilarFromApi(items);
+      })
+      .catch((e) => {
+        console.error(e);
+      });
+    return () => {
+      alive = false;
+    };
+  }, [id]);
+
+  // Server ranking when available, otherwise brand and shared notes locally.
   const relatedPerfumes = useMemo(() => {
+    if (similarFromApi && similarFromApi.length) return similarFromApi;
     if (!perfume || !Array.isArray(perfumes)) return [];
     const noteSet = new Set(noteLinks.map((n) => n.toLowerCase()));
     const brand = String(perfume.brand || "").toLowerCase();
@@ -153,7 +174,7 @@ export default function PerfumePage() {
       .filter((x) => x.score > 0)
       .sort((a, b) => b.score - a.score || String(a.perfume.name || "").localeCompare(String(b.perfume.name || "")));
     return scored.slice(0, 6).map((x) => x.perfume);
-  }, [perfume, perfumes, noteLinks]);
+  }, [perfume, perfumes, noteLinks, similarFromApi]);
 
   return (
     <div className="min-h-screen" style={{ background: THEME.bg, color: THEME.text }}>
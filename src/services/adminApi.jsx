@@ -1,8 +1,9 @@
 import { apiFetch } from "./api";
 
-export function listOrders({ page = 1, pageSize = 20, channel = "all", fulfilled } = {}) {
+export function listOrders({ page = 1, pageSize = 20, cursor = "", channel = "all", fulfilled } = {}) {
   const params = new URLSearchParams();
-  params.set("page", String(page));
+  if (cursor) params.set("cursor", cursor);
+  else params.set("page", String(page));
   params.set("pageSize", String(pageSize));
   if (channel && channel !== "all") params.set("channel", channel);
   if (typeof fulfilled === "boolean") params.set("fulfilled", String(fulfilled));
@@ -16,24 +17,32 @@ export function updateOrder(orderId, fulfilled) {
   });
 }
 
+// issueInvoice assigns the invoice number before the order is fulfilled;
+// fulfilling an order issues it otherwise.
+export function issueInvoice(orderId) {
+  return apiFetch(`/api/orders/${encodeURIComponent(orderId)}/invoice`, { method: "POST" });
+}
+
 export function deleteOrder(orderId) {
   return apiFetch(`/api/orders/${encodeURIComponent(orderId)}`, { method: "DELETE" });
 }
 
-export function listUsers({ page = 1, pageSize = 20, q = "" } = {}) {
+export function listUsers({ page = 1, pageSize = 20, cursor = "", q = "" } = {}) {
   const params = new URLSearchParams();
-  params.set("page", String(page));
+  if (cursor) params.set("cursor", cursor);
+  else params.set("page", String(page));
   params.set("pageSize", String(pageSize));
   if (q) params.set("q", q);
   return apiFetch(`/api/users?${params.toString()}`);
 }
 
-export function listStock({ q = "", includeUnlimited = true, low = 5, page = 1, pageSize = 20 } = {}) {
+export function listStock({ q = "", includeUnlimited = true, low = 5, page = 1, pageSize = 20, cursor = "" } = {}) {
   const params = new URLSearchParams();
   if (q) params.set("q", q);
   params.set("includeUnlimited", String(Boolean(includeUnlimited)));
   params.set("low", String(low));
-  params.set("page", String(page));
+  if (cursor) params.set("cursor", cursor);
+  else params.set("page", String(page));
   params.set("pageSize", String(pageSize));
   return apiFetch(`/api/stock?${params.toString()}`);
 }
@@ -84,3 +93,108 @@ export function setUserAdmin(userId, isAdmin) {
 export function deleteUser(userId) {
   return apiFetch(`/api/users/${encodeURIComponent(userId)}`, { method: "DELETE" });
 }
+
+// kind is "brands", "families" or "notes".
+export function listReference(kind, q = "") {
+  const params = new URLSearchParams();
+  if (q) params.set("q", q);
+  return apiFetch(`/api/admin/reference/${encodeURIComponent(kind)}?${params.toString()}`);
+}
+
+export function saveReference(kind, entry) {
+  const id = entry?.id;
+  const payload = {
+    slug: entry?.slug || "",
+    name: entry?.name || "",
+    nameRu: entry?.nameRu || "",
+    nameEn: entry?.nameEn || "",
+    aliases: Array.isArray(entry?.aliases) ? entry.aliases : [],
+  };
+  const base = `/api/admin/reference/${encodeURIComponent(kind)}`;
+  if (id) {
+    return apiFetch(`${base}/${encodeURIComponent(id)}`, {
+      method: "PUT",
+      body: JSON.stringify(payload),
+    });
+  }
+  return apiFetch(base, {
+    method: "POST",
+    body: JSON.stringify(payload),
+  });
+}
+
+export function deleteReference(kind, id) {
+  return apiFetch(`/api/admin/reference/${encodeURIComponent(kind)}/${encodeURIComponent(id)}`, { method: "DELETE" });
+}
+
+export function mergeReference(kind, id, into) {
+  return apiFetch(`/api/admin/reference/${encodeURIComponent(kind)}/${encodeURIComponent(id)}/merge`, {
+    method: "POST",
+    body: JSON.stringify({ into }),
+  });
+}
+
+export function saveLanding(kind, id, { title = "", description = "", heroImage = "" } = {}) {
+  return apiFetch(`/api/admin/reference/${encodeURIComponent(kind)}/${encodeURIComponent(id)}/landing`, {
+    method: "PUT",
+    body: JSON.stringify({ title, description, heroImage }),
+  });
+}
+
+export function saveVariant(perfumeId, variant) {
+  const base = `/api/perfumes/${encodeURIComponent(perfumeId)}/variants`;
+  return apiFetch(variant.id ? `${base}/${encodeURIComponent(variant.id)}` : base, {
+    method: variant.id ? "PUT" : "POST",
+    body: JSON.stringify(variant),
+  });
+}
+
+export function deleteVariant(perfumeId, variantId) {
+  return apiFetch(`/api/perfumes/${encodeURIComponent(perfumeId)}/variants/${encodeURIComponent(variantId)}`, {
+    method: "DELETE",
+  });
+}
+
+export function exportCatalog({ mode = "retail", format = "json" } = {}) {
+  const params = new URLSearchParams({ mode, format });
+  return apiFetch(`/api/admin/catalog/export?${params.toString()}`);
+}
+
+// importCatalog returns the diff report; with row errors it rejects with the
+// report in err.data.
+export function importCatalog(content, { mode = "retail", format = "json", dryRun = true, replace = false } = {}) {
+  const params = new URLSearchParams({ mode, format, dryRun: String(dryRun), replace: String(replace) });
+  return apiFetch(`/api/admin/catalog/import?${params.toString()}`, {
+    method: "POST",
+    headers: { "Content-Type": format === "csv" ? "text/csv" : "application/json" },
+    body: content,
+  });
+}
+
+export function fetchPerfumeHistory(perfumeId) {
+  return apiFetch(`/api/perfumes/${encodeURIComponent(perfumeId)}/history`);
+}
+
+export function restorePerfumeVersion(perfumeId, version) {
+  return apiFetch(`/api/perfumes/${encodeURIComponent(perfumeId)}/history/${encodeURIComponent(version)}/restore`, {
+    method: "POST",
+  });
+}
+
+export function listPriceRules(perfumeId) {
+  return apiFetch(`/api/perfumes/${encodeURIComponent(perfumeId)}/price-rules`);
+}
+
+export function savePriceRule(perfumeId, rule) {
+  const base = `/api/perfumes/${encodeURIComponent(perfumeId)}/price-rules`;
+  return apiFetch(rule.id ? `${base}/${encodeURIComponent(rule.id)}` : base, {
+    method: rule.id ? "PUT" : "POST",
+    body: JSON.stringify(rule),
+  });
+}
+
+export function deletePriceRule(perfumeId, ruleId) {
+  return apiFetch(`/api/perfumes/${encodeURIComponent(perfumeId)}/price-rules/${encodeURIComponent(ruleId)}`, {
+    method: "DELETE",
+  });
+}
@@ -61,6 +61,7 @@ export async function apiFetch(path, options = {}) {
     const message = isJSON ? data?.error || res.statusText : res.statusText;
     const err = new Error(message || "Request failed");
     err.status = res.status;
+    err.data = data;
     throw err;
   }
 
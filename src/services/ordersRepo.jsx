@@ -3,6 +3,7 @@ import { apiFetch } from "./api";
 function buildOrderItems(items) {
   return (items || []).map((x) => ({
     id: x.id,
+    variantId: x.variantId || undefined,
     volume: Number(x.volume) || 0,
     mix: String(x.mix || "60/40"),
     qty: Number(x.qty) || 0,
@@ -10,7 +11,16 @@ function buildOrderItems(items) {
   }));
 }
 
-export async function createOrder({ user, items, total, channel, delivery, contact }) {
+// fetchDeliveryMethods lists the active delivery methods, optionally only
+// those serving a catalog mode.
+export async function fetchDeliveryMethods(mode = "") {
+  const params = new URLSearchParams();
+  if (mode) params.set("mode", mode);
+  const query = params.toString();
+  return apiFetch(`/api/delivery/methods${query ? `?${query}` : ""}`);
+}
+
+export async function createOrder({ user, items, total, channel, delivery, contact, idempotencyKey }) {
   const uid = user?.uid || null;
   if (!uid) throw new Error("no uid");
 
@@ -34,8 +44,20 @@ export async function createOrder({ user, items, total, channel, delivery, conta
     },
   };
 
-  await apiFetch("/api/orders", {
+  // Retries of the same checkout reuse the key so the server does not
+  // create a second order.
+  const headers = idempotencyKey ? { "Idempotency-Key": String(idempotencyKey) } : undefined;
+
+  // The response carries accessToken, which opens the order (and its
+  // tracking) without an account; guests need it to come back to it.
+  return apiFetch("/api/orders", {
     method: "POST",
+    headers,
     body: JSON.stringify(payload),
   });
 }
+
+export async function fetchOrder(orderId, accessToken = "") {
+  const query = accessToken ? `?token=${encodeURIComponent(accessToken)}` : "";
+  return apiFetch(`/api/orders/${encodeURIComponent(orderId)}${query}`);
+}
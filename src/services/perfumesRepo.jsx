@@ -103,7 +103,9 @@ export function normalizePerfume(raw, id, issues = []) {
     seasons,
     dayNight,
     basePrice,
-    price: basePrice,
+    price: asNumber(raw.price, basePrice),
+    compareAtPrice: raw.compareAtPrice == null ? null : asNumber(raw.compareAtPrice, basePrice),
+    saleEndsAt: raw.saleEndsAt || null,
     baseVolume,
     volume: baseVolume,
     sillage,
@@ -114,6 +116,7 @@ export function normalizePerfume(raw, id, issues = []) {
     orderCount,
     inStock,
     stockQty,
+    variants: Array.isArray(raw.variants) ? raw.variants : [],
 
     currency,
     popularity,
@@ -121,6 +124,7 @@ export function normalizePerfume(raw, id, issues = []) {
     popularityMonthKey,
     reviewAvg: asNumber(raw.reviewAvg ?? raw.ratingAvg ?? 0, 0),
     reviewCount: asNumber(raw.reviewCount ?? raw.ratingCount ?? 0, 0),
+    version: asString(raw.version, ""),
   };
 
   return perfume;
@@ -138,6 +142,7 @@ export async function fetchPerfumesPage({
   mode = "retail",
   page = 1,
   pageSize = 6,
+  cursor = "",
   q = "",
   mustNotes = [],
   avoidNotes = [],
@@ -145,10 +150,17 @@ export async function fetchPerfumesPage({
   dayNight = [],
   sort = "",
   presetIds = [],
+  families = [],
+  brands = [],
+  minPrice = null,
+  maxPrice = null,
+  inStock = null,
+  facets = "",
 } = {}) {
   const params = new URLSearchParams();
   params.set("mode", mode);
-  params.set("page", String(page));
+  if (cursor) params.set("cursor", cursor);
+  else params.set("page", String(page));
   params.set("pageSize", String(pageSize));
   if (q) params.set("q", q);
   if (sort) params.set("sort", sort);
@@ -157,9 +169,48 @@ export async function fetchPerfumesPage({
   if (Array.isArray(seasons) && seasons.length) params.set("seasons", seasons.join(","));
   if (Array.isArray(dayNight) && dayNight.length) params.set("dayNight", dayNight.join(","));
   if (Array.isArray(presetIds) && presetIds.length) params.set("presetIds", presetIds.join(","));
+  if (Array.isArray(families) && families.length) params.set("families", families.join(","));
+  if (Array.isArray(brands) && brands.length) params.set("brands", brands.join(","));
+  if (minPrice != null) params.set("minPrice", String(minPrice));
+  if (maxPrice != null) params.set("maxPrice", String(maxPrice));
+  if (inStock != null) params.set("inStock", String(inStock));
+  if (facets) params.set("facets", Array.isArray(facets) ? facets.join(",") : String(facets));
   return apiFetch(`/api/perfumes?${params.toString()}`);
 }
 
+export async function fetchSimilarPerfumes(id, limit = 6) {
+  const data = await apiFetch(`/api/perfumes/${encodeURIComponent(id)}/similar?limit=${limit}`);
+  return Array.isArray(data?.items) ? data.items : [];
+}
+
+export async function fetchBoughtTogether(id, limit = 6) {
+  const data = await apiFetch(`/api/perfumes/${encodeURIComponent(id)}/bought-together?limit=${limit}`);
+  return Array.isArray(data?.items) ? data.items : [];
+}
+
+// fetchPriceHistory returns the recorded prices and lowestPrice30d, the
+// reference price for "old price" displays.
+export async function fetchPriceHistory(id) {
+  return apiFetch(`/api/perfumes/${encodeURIComponent(id)}/price-history`);
+}
+
+export async function fetchCartRecommendations(ids, limit = 6) {
+  if (!Array.isArray(ids) || !ids.length) return [];
+  const params = new URLSearchParams();
+  params.set("ids", ids.join(","));
+  params.set("limit", String(limit));
+  const data = await apiFetch(`/api/cart/recommendations?${params.toString()}`);
+  return Array.isArray(data?.items) ? data.items : [];
+}
+
+export async function fetchSuggestions(q, { mode = "", limit = 5 } = {}) {
+  const params = new URLSearchParams();
+  params.set("q", q);
+  if (mode) params.set("mode", mode);
+  if (limit) params.set("limit", String(limit));
+  return apiFetch(`/api/perfumes/suggest?${params.toString()}`);
+}
+
 export async function fetchCatalogWithDiagnostics(collectionName) {
   const mode = modeFromCollection(collectionName || "perfumes");
   const data = await fetchPerfumes(mode);
@@ -186,11 +237,14 @@ export async function fetchPerfumesWithDiagnostics() {
   return fetchCatalogWithDiagnostics("perfumes");
 }
 
-export async function upsertPerfume(id, data, catalogMode = "retail") {
+export async function upsertPerfume(id, data, catalogMode = "retail", { version } = {}) {
   if (!id) throw new Error("id обязателен");
   const payload = { ...data, id, catalogMode };
+  // С version сервер откажет (409), если товар уже изменил кто-то другой.
+  const headers = version ? { "If-Match": `"${version}"` } : undefined;
   await apiFetch(`/api/perfumes/${encodeURIComponent(id)}`, {
     method: "PUT",
+    headers,
     body: JSON.stringify(payload),
   });
 }
@@ -199,3 +253,19 @@ export async function deletePerfume(id) {
   if (!id) throw new Error("id обязателен");
   await apiFetch(`/api/perfumes/${encodeURIComponent(id)}`, { method: "DELETE" });
 }
+
+export async function fetchBrands() {
+  const data = await apiFetch("/api/brands");
+  return Array.isArray(data) ? data : [];
+}
+
+// params take the same filters as fetchPerfumesPage.
+export async function fetchBrandLanding(slug, params = {}) {
+  const query = new URLSearchParams(params);
+  return apiFetch(`/api/brands/${encodeURIComponent(slug)}?${query.toString()}`);
+}
+
+export async function fetchNoteLanding(slug, params = {}) {
+  const query = new URLSearchParams(params);
+  return apiFetch(`/api/notes/${encodeURIComponent(slug)}?${query.toString()}`);
+}